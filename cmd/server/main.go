@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"xia_adpter/internal/api"
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/dedup"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/pipeline"
+	"xia_adpter/internal/platform"
+	"xia_adpter/internal/platform/lark"
+	"xia_adpter/internal/platform/wecom"
+	"xia_adpter/internal/session"
+	"xia_adpter/internal/voice"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// shutdownTimeout 关闭 HTTP 服务的超时时间
+	shutdownTimeout = 10 * time.Second
+	// drainTimeout 等待处理中消息完成的超时时间
+	drainTimeout = 60 * time.Second
+)
+
+func main() {
+	configPath := flag.String("config", "configs/config.yaml", "配置文件路径")
+	logLevel := flag.String("log-level", "info", "日志级别: debug, info, warn, error")
+	flag.Parse()
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	if err := run(*configPath, logger); err != nil {
+		logger.Error("Server exited with error", zap.Error(err))
+		logger.Sync()
+		os.Exit(1)
+	}
+}
+
+// run 加载配置、启动各组件并在收到退出信号后依次关闭
+func run(configPath string, logger *zap.Logger) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+
+	// 收到 SIGINT/SIGTERM 时取消 ctx，所有组件据此开始关闭
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 消息队列（wal 后端会恢复上次未处理完的消息）
+	queue, err := message.NewQueueFromConfig(cfg.Queue)
+	if err != nil {
+		return fmt.Errorf("failed to create message queue: %w", err)
+	}
+	defer func() {
+		if err := queue.Close(); err != nil {
+			logger.Warn("Failed to close message queue", zap.Error(err))
+		}
+	}()
+	if n := queue.Stats().Length; n > 0 {
+		logger.Info("Recovered pending messages from queue", zap.Int("count", n))
+	}
+
+	p := pipeline.New(cfg, logger)
+
+	// 会话存储（保存各 Agent 的 conversation_id）
+	sessions, err := session.New(cfg.Session)
+	if err != nil {
+		return fmt.Errorf("failed to create session store: %w", err)
+	}
+	defer func() {
+		if err := sessions.Close(); err != nil {
+			logger.Warn("Failed to close session store", zap.Error(err))
+		}
+	}()
+	p.SetSessionStore(sessions)
+
+	// 语音识别（未启用时语音消息回复提示）
+	transcriber, err := voice.New(cfg.Voice, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create voice transcriber: %w", err)
+	}
+	p.SetTranscriber(transcriber)
+
+	// 入站事件去重（平台重推的消息只处理一次）
+	events, err := dedup.New(cfg.Dedup)
+	if err != nil {
+		return fmt.Errorf("failed to create dedup store: %w", err)
+	}
+	defer func() {
+		if err := events.Close(); err != nil {
+			logger.Warn("Failed to close dedup store", zap.Error(err))
+		}
+	}()
+
+	// 按配置注册平台适配器
+	platforms := platform.NewRegistry(logger)
+	if cfg.Platform.Lark.Enabled {
+		adapter := lark.NewAdapter(cfg.Platform.Lark, queue, logger)
+		adapter.SetDedupStore(events)
+		if err := platforms.Register(adapter); err != nil {
+			return err
+		}
+	}
+	if cfg.Platform.WeCom.Enabled {
+		adapter := wecom.NewAdapter(cfg.Platform.WeCom, queue, logger)
+		adapter.SetDedupStore(events)
+		if err := platforms.Register(adapter); err != nil {
+			return err
+		}
+	}
+	p.SetPlatforms(platforms)
+
+	// 启动平台适配器（ctx 取消时自动停止）
+	platforms.StartAll(ctx)
+
+	var wg sync.WaitGroup
+
+	// 启动消息处理管道
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := p.Start(ctx, queue); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("Pipeline exited with error", zap.Error(err))
+		}
+	}()
+
+	// 启动管理 API 服务
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(gin.Recovery())
+	apiServer := api.NewServer(cfg, configPath, logger)
+	apiServer.SetPlatforms(platforms)
+	apiServer.SetQueue(queue)
+	apiServer.SetPipeline(p)
+	apiServer.SetupRoutes(router)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler: router,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Starting API server", zap.String("addr", server.Addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	// 等待退出信号或 API 服务异常退出
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received")
+	case err, ok := <-serverErr:
+		if ok {
+			runErr = fmt.Errorf("API server failed: %w", err)
+		}
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Failed to shutdown API server", zap.Error(err))
+	}
+
+	// 等待适配器和管道停止接收消息
+	platforms.StopAll()
+	wg.Wait()
+
+	// 等待已开始处理的消息完成并发送回复
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := p.Wait(drainCtx); err != nil {
+		logger.Warn("Timed out waiting for in-flight messages", zap.Error(err))
+	}
+
+	logger.Info("Server stopped")
+	return runErr
+}
+
+// newLogger 根据日志级别创建 logger
+func newLogger(level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	zapCfg := zap.NewProductionConfig()
+	zapCfg.Level = zap.NewAtomicLevelAt(lvl)
+	zapCfg.Encoding = "console"
+	zapCfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	zapCfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	return zapCfg.Build()
+}
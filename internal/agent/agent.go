@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/session"
+
+	"go.uber.org/zap"
+)
+
+// Capabilities Agent 能力描述
+type Capabilities struct {
+	Streaming bool `json:"streaming"` // 支持流式输出
+	Images    bool `json:"images"`    // 支持图片输入
+	Files     bool `json:"files"`     // 支持文件输入
+}
+
+// Agent 统一的 Agent 接口，Dify、Coze 等后端都实现该接口
+type Agent interface {
+	// Name 实例名称（配置中的 name）
+	Name() string
+	// Type 后端类型（dify、coze 等）
+	Type() string
+	// Capabilities 返回 Agent 支持的能力
+	Capabilities() Capabilities
+	// Chat 发送请求并返回完整响应
+	Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error)
+}
+
+// StreamHandler 接收流式输出的增量文本
+type StreamHandler func(delta string)
+
+// StreamingAgent 支持流式输出的 Agent
+type StreamingAgent interface {
+	Agent
+	// ChatStream 发送请求，每收到一段增量文本调用一次 onDelta，结束后返回完整响应
+	ChatStream(ctx context.Context, req *message.AgentRequest, onDelta StreamHandler) (*message.AgentResponse, error)
+}
+
+// SessionAware 需要访问会话存储的 Agent 实现该接口，用于延续多轮对话
+type SessionAware interface {
+	SetSessionStore(store session.Store)
+}
+
+// Factory 根据实例配置创建 Agent
+type Factory func(cfg config.AgentInstanceConfig, logger *zap.Logger) (Agent, error)
+
+// Registry Agent 注册表，按类型保存工厂，按名称保存实例
+type Registry struct {
+	logger    *zap.Logger
+	factories map[string]Factory
+	agents    map[string]Agent
+	order     []string
+	mu        sync.RWMutex
+}
+
+// NewRegistry 创建 Agent 注册表
+func NewRegistry(logger *zap.Logger) *Registry {
+	return &Registry{
+		logger:    logger,
+		factories: make(map[string]Factory),
+		agents:    make(map[string]Agent),
+	}
+}
+
+// RegisterFactory 注册 Agent 类型的工厂
+func (r *Registry) RegisterFactory(agentType string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[agentType] = factory
+}
+
+// Register 注册 Agent 实例，名称不能重复
+func (r *Registry) Register(a Agent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := a.Name()
+	if _, exists := r.agents[name]; exists {
+		return fmt.Errorf("agent %q already registered", name)
+	}
+	r.agents[name] = a
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Create 使用对应类型的工厂创建 Agent 并注册
+func (r *Registry) Create(cfg config.AgentInstanceConfig) (Agent, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+
+	r.mu.RLock()
+	factory, ok := r.factories[cfg.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown agent type %q", cfg.Type)
+	}
+
+	a, err := factory(cfg, r.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent %q: %w", cfg.Name, err)
+	}
+
+	if err := r.Register(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Load 根据配置创建所有启用的 Agent
+// 单个实例创建失败只记录日志，不影响其他实例
+func (r *Registry) Load(cfg config.AgentConfig) {
+	for _, inst := range cfg.EnabledInstances() {
+		a, err := r.Create(inst)
+		if err != nil {
+			r.logger.Error("Failed to load agent",
+				zap.String("name", inst.Name),
+				zap.String("type", inst.Type),
+				zap.Error(err),
+			)
+			continue
+		}
+		r.logger.Info("Agent loaded",
+			zap.String("name", a.Name()),
+			zap.String("type", a.Type()),
+		)
+	}
+}
+
+// SetSessionStore 为所有支持会话存储的 Agent 设置会话存储
+func (r *Registry) SetSessionStore(store session.Store) {
+	for _, a := range r.List() {
+		if sa, ok := a.(SessionAware); ok {
+			sa.SetSessionStore(store)
+		}
+	}
+}
+
+// Get 按名称获取 Agent
+func (r *Registry) Get(name string) (Agent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	a, ok := r.agents[name]
+	return a, ok
+}
+
+// List 按注册顺序返回所有 Agent
+func (r *Registry) List() []Agent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agents := make([]Agent, 0, len(r.order))
+	for _, name := range r.order {
+		agents = append(agents, r.agents[name])
+	}
+	return agents
+}
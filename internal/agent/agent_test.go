@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/session"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeAgent 只记录配置的 Agent
+type fakeAgent struct {
+	cfg   config.AgentInstanceConfig
+	store session.Store
+}
+
+func (a *fakeAgent) Name() string               { return a.cfg.Name }
+func (a *fakeAgent) Type() string               { return a.cfg.Type }
+func (a *fakeAgent) Capabilities() Capabilities { return Capabilities{} }
+func (a *fakeAgent) SetSessionStore(store session.Store) {
+	a.store = store
+}
+
+func (a *fakeAgent) Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error) {
+	return &message.AgentResponse{Content: a.cfg.Name}, nil
+}
+
+func fakeFactory(cfg config.AgentInstanceConfig, logger *zap.Logger) (Agent, error) {
+	if cfg.APIKey == "" {
+		return nil, errors.New("api_key is required")
+	}
+	return &fakeAgent{cfg: cfg}, nil
+}
+
+func newTestRegistry(logger *zap.Logger) *Registry {
+	r := NewRegistry(logger)
+	r.RegisterFactory("dify", fakeFactory)
+	r.RegisterFactory("coze", fakeFactory)
+	return r
+}
+
+func names(agents []Agent) string {
+	var s []string
+	for _, a := range agents {
+		s = append(s, a.Name()+":"+a.Type())
+	}
+	return strings.Join(s, ",")
+}
+
+func TestRegistryCreate(t *testing.T) {
+	r := newTestRegistry(zap.NewNop())
+
+	// 未设置名称时以类型作为名称
+	a, err := r.Create(config.AgentInstanceConfig{Type: "dify", APIKey: "k"})
+	if err != nil || a.Name() != "dify" {
+		t.Fatalf("Create = %v, %v", a, err)
+	}
+	if got, ok := r.Get("dify"); !ok || got != a {
+		t.Error("created agent not registered")
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.AgentInstanceConfig
+		want string
+	}{
+		{"unknown type", config.AgentInstanceConfig{Name: "x", Type: "openai", APIKey: "k"}, `unknown agent type "openai"`},
+		{"factory error", config.AgentInstanceConfig{Name: "nokey", Type: "coze"}, `failed to create agent "nokey"`},
+		{"duplicate name", config.AgentInstanceConfig{Name: "dify", Type: "coze", APIKey: "k"}, `agent "dify" already registered`},
+	}
+	for _, tt := range tests {
+		if _, err := r.Create(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if got := names(r.List()); got != "dify:dify" {
+		t.Errorf("agents = %s, want only dify", got)
+	}
+}
+
+func TestRegistryLoad(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	r := newTestRegistry(zap.New(core))
+
+	r.Load(config.AgentConfig{
+		Dify: config.DifyConfig{Enabled: true, APIKey: "k"},
+		Coze: config.CozeConfig{Enabled: false, APIKey: "k"},
+		Instances: []config.AgentInstanceConfig{
+			{Name: "support", Type: "coze", Enabled: true, APIKey: "k"},
+			{Name: "disabled", Type: "dify", Enabled: false, APIKey: "k"},
+			{Name: "broken", Type: "dify", Enabled: true},
+			{Name: "unknown", Type: "openai", Enabled: true, APIKey: "k"},
+			{Name: "support", Type: "dify", Enabled: true, APIKey: "k"},
+			{Name: "sales", Type: "dify", Enabled: true, APIKey: "k"},
+		},
+	})
+
+	// 禁用的实例不创建，失败的实例只记录日志，其余按配置顺序注册
+	if got := names(r.List()); got != "dify:dify,support:coze,sales:dify" {
+		t.Errorf("agents = %s", got)
+	}
+	if logs.Len() != 3 {
+		t.Errorf("logged %d load errors, want 3 (broken, unknown, duplicate)", logs.Len())
+	}
+
+	store := session.NewMemoryStore(time.Hour)
+	r.SetSessionStore(store)
+	for _, a := range r.List() {
+		if a.(*fakeAgent).store != store {
+			t.Errorf("%s has no session store", a.Name())
+		}
+	}
+}
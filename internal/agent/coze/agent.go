@@ -8,46 +8,125 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strings"
 	"time"
 
+	"xia_adpter/internal/agent"
 	"xia_adpter/internal/config"
 	"xia_adpter/internal/message"
+	"xia_adpter/internal/session"
 
 	"go.uber.org/zap"
 )
 
+// Type Agent 类型标识
+const Type = "coze"
+
+// maxSSELineSize SSE 单行最大长度
+const maxSSELineSize = 1024 * 1024
+
+// defaultAPIBase 默认 API 地址
+const defaultAPIBase = "https://api.coze.cn"
+
 // Agent Coze Agent
 type Agent struct {
-	cfg    config.CozeConfig
-	logger *zap.Logger
-	client *http.Client
+	name      string
+	converter *message.Converter
+	cfg       config.CozeConfig
+	logger    *zap.Logger
+	client    *http.Client
+
+	// 已上传文件的 file_id 缓存
+	uploads *agent.UploadCache
 }
 
 // NewAgent 创建新的 Coze Agent
 func NewAgent(cfg config.CozeConfig, logger *zap.Logger) *Agent {
 	return &Agent{
-		cfg:    cfg,
-		logger: logger,
+		name:      Type,
+		converter: message.NewConverter(),
+		cfg:       cfg,
+		logger:    logger,
 		client: &http.Client{
 			Timeout: 120 * time.Second,
 		},
+		uploads: agent.NewUploadCache(uploadCacheTTL),
+	}
+}
+
+// Factory 根据通用实例配置创建 Coze Agent，用于注册到 agent.Registry
+func Factory(cfg config.AgentInstanceConfig, logger *zap.Logger) (agent.Agent, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("Coze API key is empty")
+	}
+	apiBase := cfg.APIBase
+	if apiBase == "" {
+		apiBase = defaultAPIBase
+	}
+
+	a := NewAgent(config.CozeConfig{
+		Enabled: cfg.Enabled,
+		APIKey:  cfg.APIKey,
+		APIBase: apiBase,
+		BotID:   cfg.BotID,
+		UserID:  cfg.UserID,
+	}, logger.With(zap.String("agent", cfg.Name)))
+	a.name = cfg.Name
+	return a, nil
+}
+
+// SetSessionStore 设置会话存储，构建请求时从中查询 conversation_id
+func (a *Agent) SetSessionStore(store session.Store) {
+	a.converter.SetSessionStore(store)
+}
+
+// Name 返回实例名称
+func (a *Agent) Name() string {
+	return a.name
+}
+
+// Type 返回 Agent 类型
+func (a *Agent) Type() string {
+	return Type
+}
+
+// Capabilities 返回 Coze Agent 支持的能力
+func (a *Agent) Capabilities() agent.Capabilities {
+	return agent.Capabilities{
+		Streaming: true,
+		Images:    true,
+		Files:     true,
 	}
 }
 
 // Chat 发送聊天消息（使用 AgentRequest 格式）
 func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error) {
-	converter := message.NewConverter()
-	
+	return a.ChatStream(ctx, req, nil)
+}
+
+// ChatStream 发送聊天消息，每收到一段回答调用一次 onDelta（可为 nil），结束后返回完整响应
+func (a *Agent) ChatStream(ctx context.Context, req *message.AgentRequest, onDelta agent.StreamHandler) (*message.AgentResponse, error) {
+	converter := a.converter
+
+	// base64 图片及平台下载的文件需先上传，再以 file_id 引用
+	req = a.withUploadedFiles(ctx, req)
+
 	// 构建 Coze 请求
 	payload := converter.BuildCozeRequest(req, a.cfg.BotID)
 	payload["user_id"] = req.UserID
 	if a.cfg.UserID != "" {
 		payload["user_id"] = a.cfg.UserID
 	}
-	
+
 	url := fmt.Sprintf("%s/v3/chat", a.cfg.APIBase)
 
+	// Coze v3 的会话 ID 通过查询参数传递
+	if cid, ok := payload["conversation_id"].(string); ok && cid != "" {
+		url = fmt.Sprintf("%s?conversation_id=%s", url, neturl.QueryEscape(cid))
+		delete(payload, "conversation_id")
+	}
+
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal payload: %w", err)
@@ -81,47 +160,93 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	var fullResponse strings.Builder
 	var conversationID string
 	var messageID string
+	var imageURLs []string
+	var files []message.Attachment
+	var suggestions []string
 	scanner := bufio.NewScanner(resp.Body)
-	
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+
+	var eventName string
 	var eventData string
-	
+	// 收到过增量后，completed 事件中的完整内容不再重复拼接
+	gotDelta := false
+
+scan:
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		
+		line := strings.TrimSpace(scanner.Text())
+
 		if line == "" {
 			// 空行表示一个事件结束
-			if eventData != "" {
-				var data map[string]interface{}
-				if err := json.Unmarshal([]byte(eventData), &data); err == nil {
-					// 解析响应
-					agentResp := converter.ParseCozeResponse(data)
-					if agentResp.Content != "" {
+			if eventData == "" {
+				eventName = ""
+				continue
+			}
+
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(eventData), &data); err == nil {
+				// 解析响应
+				agentResp := converter.ParseCozeResponse(data)
+
+				// 提取会话 ID
+				if cid, ok := agentResp.Metadata["conversation_id"]; ok {
+					conversationID = cid
+				}
+
+				// 提取消息 ID
+				if mid, ok := agentResp.Metadata["message_id"]; ok {
+					messageID = mid
+				}
+
+				msgType, _ := data["type"].(string)
+				contentType, _ := data["content_type"].(string)
+				switch eventName {
+				case "conversation.message.delta":
+					// 多模态回答在 completed 事件中整体解析
+					if msgType == "answer" && contentType != "object_string" && agentResp.Content != "" {
+						gotDelta = true
 						fullResponse.WriteString(agentResp.Content)
+						if onDelta != nil {
+							onDelta(agentResp.Content)
+						}
 					}
-					
-					// 提取会话 ID
-					if cid, ok := agentResp.Metadata["conversation_id"]; ok {
-						conversationID = cid
+				case "conversation.message.completed":
+					if msgType == "answer" && contentType == "object_string" {
+						text, images, outFiles := parseObjectString(agentResp.Content)
+						fullResponse.WriteString(text)
+						imageURLs = append(imageURLs, images...)
+						files = append(files, outFiles...)
+					} else if msgType == "answer" && !gotDelta {
+						fullResponse.WriteString(agentResp.Content)
+					} else if msgType == "follow_up" && agentResp.Content != "" {
+						// Bot 开启了推荐问题时，每个问题是一条 follow_up 消息
+						suggestions = append(suggestions, agentResp.Content)
 					}
-					
-					// 提取消息 ID
-					if mid, ok := agentResp.Metadata["message_id"]; ok {
-						messageID = mid
+				case "conversation.chat.failed", "error":
+					return nil, fmt.Errorf("Coze stream error: %s", cozeErrorMessage(data))
+				case "":
+					// 没有 event 行时按旧格式处理
+					if agentResp.Content != "" {
+						fullResponse.WriteString(agentResp.Content)
 					}
 				}
 			}
+			eventName = ""
 			eventData = ""
 			continue
 		}
-		
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			if data != "[DONE]" {
-				eventData = data
-			} else {
-				break
+
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			if eventName == "done" {
+				break scan
+			}
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" || data == `"[DONE]"` {
+				break scan
 			}
+			eventData = data
 		}
 	}
 
@@ -130,17 +255,19 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	}
 
 	response := fullResponse.String()
-	if response == "" {
+	if response == "" && len(imageURLs) == 0 && len(files) == 0 {
 		response = "抱歉，我没有理解您的问题。"
 	}
 
 	// 构建 AgentResponse
 	agentResp := &message.AgentResponse{
-		Content:   response,
-		ImageURLs: []string{},
-		Metadata:  make(map[string]string),
+		Content:     response,
+		ImageURLs:   append([]string{}, imageURLs...),
+		Files:       files,
+		Suggestions: suggestions,
+		Metadata:    make(map[string]string),
 	}
-	
+
 	if conversationID != "" {
 		agentResp.Metadata["conversation_id"] = conversationID
 	}
@@ -151,3 +278,62 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	return agentResp, nil
 }
 
+// parseObjectString 解析 object_string 格式的回答，返回其中的文本、图片和文件
+func parseObjectString(content string) (string, []string, []message.Attachment) {
+	var items []struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		FileURL string `json:"file_url"`
+		URL     string `json:"url"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(content), &items); err != nil {
+		// 无法解析时按文本处理
+		return content, nil, nil
+	}
+
+	var texts []string
+	var images []string
+	var files []message.Attachment
+	for _, item := range items {
+		fileURL := item.FileURL
+		if fileURL == "" {
+			fileURL = item.URL
+		}
+		switch item.Type {
+		case "text":
+			if item.Text != "" {
+				texts = append(texts, item.Text)
+			}
+		case "image":
+			if fileURL != "" {
+				images = append(images, fileURL)
+			}
+		case "file", "audio", "video":
+			if fileURL != "" {
+				files = append(files, message.Attachment{
+					Type: message.MessageTypeFile,
+					URL:  fileURL,
+					Name: item.Name,
+				})
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images, files
+}
+
+// cozeErrorMessage 从错误事件中提取错误信息
+func cozeErrorMessage(data map[string]interface{}) string {
+	if lastErr, ok := data["last_error"].(map[string]interface{}); ok {
+		if msg, ok := lastErr["msg"].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	if msg, ok := data["msg"].(string); ok && msg != "" {
+		return msg
+	}
+	if msg, ok := data["message"].(string); ok {
+		return msg
+	}
+	return "unknown error"
+}
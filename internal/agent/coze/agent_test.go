@@ -0,0 +1,82 @@
+package coze
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+func TestChatUploadsMediaAndReferencesFileID(t *testing.T) {
+	const fileName = `合同 "v2"\草稿.docx`
+
+	var uploaded string
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/files/upload":
+			_, header, err := r.FormFile("file")
+			if err != nil {
+				t.Fatalf("FormFile: %v", err)
+			}
+			uploaded = header.Filename
+			io.WriteString(w, `{"code":0,"msg":"","data":{"id":"file-1"}}`)
+		case "/v3/chat":
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode chat payload: %v", err)
+			}
+			io.WriteString(w, "event: conversation.message.delta\n")
+			io.WriteString(w, "data: {\"type\":\"answer\",\"content\":\"好的\",\"conversation_id\":\"c1\"}\n\n")
+			io.WriteString(w, "event: done\n")
+			io.WriteString(w, "data: \"[DONE]\"\n\n")
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	a := NewAgent(config.CozeConfig{APIKey: "key", APIBase: server.URL, BotID: "bot"}, zap.NewNop())
+	req := &message.AgentRequest{
+		Query:  "总结一下",
+		UserID: "u1",
+		Media:  []message.MediaFile{{Name: fileName, Data: []byte("PK\x03\x04 docx")}},
+	}
+	resp, err := a.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Content != "好的" {
+		t.Errorf("content = %q", resp.Content)
+	}
+	if uploaded != fileName {
+		t.Errorf("uploaded file name = %q, want %q", uploaded, fileName)
+	}
+	if len(req.Files) != 0 {
+		t.Error("original request modified")
+	}
+
+	// 上传的文件以 file_id 出现在 object_string 消息中
+	messages, _ := payload["additional_messages"].([]interface{})
+	if len(messages) != 1 {
+		t.Fatalf("additional_messages = %v", payload["additional_messages"])
+	}
+	msg := messages[0].(map[string]interface{})
+	if msg["content_type"] != "object_string" {
+		t.Fatalf("content_type = %v", msg["content_type"])
+	}
+	var content []map[string]string
+	if err := json.Unmarshal([]byte(msg["content"].(string)), &content); err != nil {
+		t.Fatalf("unmarshal content: %v", err)
+	}
+	if len(content) != 2 || content[0]["text"] != "总结一下" || content[1]["type"] != "file" || content[1]["file_id"] != "file-1" {
+		t.Errorf("content = %v", content)
+	}
+}
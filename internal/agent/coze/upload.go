@@ -0,0 +1,131 @@
+package coze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"xia_adpter/internal/agent"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxFileSize Coze 允许上传的文件大小上限
+	maxFileSize = 512 * 1024 * 1024
+	// uploadCacheTTL 上传结果缓存时间，同一消息重试或回退时复用已上传的文件
+	uploadCacheTTL = time.Hour
+)
+
+// uploadResponse /v1/files/upload 接口响应
+type uploadResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		ID       string `json:"id"`
+		Bytes    int64  `json:"bytes"`
+		FileName string `json:"file_name"`
+	} `json:"data"`
+}
+
+// withUploadedFiles 上传请求中的 base64 图片及从平台下载的文件，返回以 file_id 引用这些文件的请求副本
+// 请求会在多个 Agent 间回退复用，因此不修改原请求；无法上传的文件记录日志后跳过
+func (a *Agent) withUploadedFiles(ctx context.Context, req *message.AgentRequest) *message.AgentRequest {
+	var files []message.AgentFile
+	for _, image := range req.ImageURLs {
+		if !agent.IsInlineData(image) {
+			continue
+		}
+
+		data, err := agent.DecodeInlineData(image)
+		if err != nil {
+			a.logger.Warn("Failed to decode image", zap.Error(err))
+			continue
+		}
+		file, err := a.uploadFile(ctx, data, "")
+		if err != nil {
+			a.logger.Warn("Failed to upload file to Coze", zap.Error(err))
+			continue
+		}
+		files = append(files, file)
+	}
+	for _, media := range req.Media {
+		file, err := a.uploadFile(ctx, media.Data, media.Name)
+		if err != nil {
+			a.logger.Warn("Failed to upload file to Coze",
+				zap.String("name", media.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return req
+	}
+
+	r := *req
+	r.Files = append(append([]message.AgentFile(nil), req.Files...), files...)
+	return &r
+}
+
+// uploadFile 通过 /v1/files/upload 上传文件，图片以外的内容按普通文件引用
+// filename 为空时按内容类型生成
+func (a *Agent) uploadFile(ctx context.Context, data []byte, filename string) (message.AgentFile, error) {
+	if len(data) > maxFileSize {
+		return message.AgentFile{}, fmt.Errorf("file too large: %d bytes (max %d)", len(data), maxFileSize)
+	}
+
+	// 以实际内容判断类型，data URI 中声明的类型不一定可靠
+	mimeType := http.DetectContentType(data)
+	fileType := "file"
+	if strings.HasPrefix(mimeType, "image/") {
+		fileType = "image"
+	}
+
+	cacheKey := agent.UploadCacheKey(a.name, data)
+	if fileID, ok := a.uploads.Get(cacheKey); ok {
+		return message.AgentFile{Type: fileType, FileID: fileID}, nil
+	}
+
+	if filename == "" {
+		filename = "file"
+		if exts, _ := mime.ExtensionsByType(strings.Split(mimeType, ";")[0]); len(exts) > 0 {
+			filename += exts[0]
+		}
+	}
+
+	url := fmt.Sprintf("%s/v1/files/upload", a.cfg.APIBase)
+	status, respBody, err := agent.UploadFile(ctx, a.client, url, a.cfg.APIKey, filename, mimeType, data, nil)
+	if err != nil {
+		return message.AgentFile{}, err
+	}
+	if status != http.StatusOK {
+		return message.AgentFile{}, fmt.Errorf("Coze upload error: %d, %s", status, string(respBody))
+	}
+
+	var result uploadResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return message.AgentFile{}, fmt.Errorf("failed to parse upload response: %w", err)
+	}
+	if result.Code != 0 {
+		return message.AgentFile{}, fmt.Errorf("Coze upload error: %d, %s", result.Code, result.Msg)
+	}
+	if result.Data.ID == "" {
+		return message.AgentFile{}, fmt.Errorf("Coze upload response missing file id")
+	}
+
+	a.logger.Debug("Uploaded file to Coze",
+		zap.String("file_id", result.Data.ID),
+		zap.String("mime_type", mimeType),
+		zap.Int("size", len(data)),
+	)
+
+	a.uploads.Set(cacheKey, result.Data.ID)
+	return message.AgentFile{Type: fileType, FileID: result.Data.ID}, nil
+}
@@ -8,44 +8,123 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"path"
 	"strings"
 	"time"
 
+	"xia_adpter/internal/agent"
 	"xia_adpter/internal/config"
 	"xia_adpter/internal/message"
+	"xia_adpter/internal/session"
 
 	"go.uber.org/zap"
 )
 
+// Type Agent 类型标识
+const Type = "dify"
+
+// maxSSELineSize SSE 单行最大长度，message_end 事件可能携带较大的检索元数据
+const maxSSELineSize = 1024 * 1024
+
+// defaultAPIBase 默认 API 地址
+const defaultAPIBase = "https://api.dify.ai/v1"
+
 // Agent Dify Agent
 type Agent struct {
-	cfg    config.DifyConfig
-	logger *zap.Logger
-	client *http.Client
+	name      string
+	converter *message.Converter
+	cfg       config.DifyConfig
+	logger    *zap.Logger
+	client    *http.Client
+
+	// 已上传图片的 upload_file_id 缓存
+	uploads *agent.UploadCache
 }
 
 // NewAgent 创建新的 Dify Agent
 func NewAgent(cfg config.DifyConfig, logger *zap.Logger) *Agent {
 	return &Agent{
-		cfg:    cfg,
-		logger: logger,
+		name:      Type,
+		converter: message.NewConverter(),
+		cfg:       cfg,
+		logger:    logger,
 		client: &http.Client{
 			Timeout: 120 * time.Second,
 		},
+		uploads: agent.NewUploadCache(uploadCacheTTL),
+	}
+}
+
+// Factory 根据通用实例配置创建 Dify Agent，用于注册到 agent.Registry
+func Factory(cfg config.AgentInstanceConfig, logger *zap.Logger) (agent.Agent, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("Dify API key is empty")
+	}
+	apiBase := cfg.APIBase
+	if apiBase == "" {
+		apiBase = defaultAPIBase
+	}
+
+	a := NewAgent(config.DifyConfig{
+		Enabled: cfg.Enabled,
+		APIKey:  cfg.APIKey,
+		APIBase: apiBase,
+		AppID:   cfg.AppID,
+		UserID:  cfg.UserID,
+	}, logger.With(zap.String("agent", cfg.Name)))
+	a.name = cfg.Name
+	return a, nil
+}
+
+// SetSessionStore 设置会话存储，构建请求时从中查询 conversation_id
+func (a *Agent) SetSessionStore(store session.Store) {
+	a.converter.SetSessionStore(store)
+}
+
+// Name 返回实例名称
+func (a *Agent) Name() string {
+	return a.name
+}
+
+// Type 返回 Agent 类型
+func (a *Agent) Type() string {
+	return Type
+}
+
+// Capabilities 返回 Dify Agent 支持的能力
+func (a *Agent) Capabilities() agent.Capabilities {
+	return agent.Capabilities{
+		Streaming: true,
+		Images:    true,
+		Files:     true,
 	}
 }
 
 // Chat 发送聊天消息（使用 AgentRequest 格式）
 func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error) {
-	converter := message.NewConverter()
-	
+	return a.ChatStream(ctx, req, nil)
+}
+
+// ChatStream 发送聊天消息，每收到一段回答调用一次 onDelta（可为 nil），结束后返回完整响应
+func (a *Agent) ChatStream(ctx context.Context, req *message.AgentRequest, onDelta agent.StreamHandler) (*message.AgentResponse, error) {
+	converter := a.converter
+
 	// 构建 Dify 请求
 	payload := converter.BuildDifyRequest(req, map[string]interface{}{})
 	payload["user"] = req.SessionID // 使用 session_id 作为 user
 	if a.cfg.UserID != "" {
 		payload["user"] = a.cfg.UserID
 	}
-	
+
+	// base64 图片及平台下载的文件需先上传，再以 upload_file_id 引用（上传时的 user 必须与对话一致）
+	uploaded := a.uploadImages(ctx, req.ImageURLs, payload["user"].(string))
+	uploaded = append(uploaded, a.uploadMedia(ctx, req.Media, payload["user"].(string))...)
+	if len(uploaded) > 0 {
+		files, _ := payload["files"].([]map[string]interface{})
+		payload["files"] = append(files, uploaded...)
+	}
+
 	// 调试日志：检查 payload 中的 conversation_id
 	if cid, ok := payload["conversation_id"].(string); ok {
 		a.logger.Info("Dify request payload contains conversation_id",
@@ -55,7 +134,7 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	} else {
 		a.logger.Info("Dify request payload does not contain conversation_id, will create new conversation")
 	}
-	
+
 	url := fmt.Sprintf("%s/chat-messages", a.cfg.APIBase)
 
 	jsonData, err := json.Marshal(payload)
@@ -77,7 +156,7 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	authHeader := fmt.Sprintf("Bearer %s", a.cfg.APIKey)
 	httpReq.Header.Set("Authorization", authHeader)
 	httpReq.Header.Set("Content-Type", "application/json")
-	
+
 	// 调试日志
 	a.logger.Debug("Dify API request",
 		zap.String("url", url),
@@ -106,8 +185,12 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	var fullResponse strings.Builder
 	var conversationID string
 	var messageID string
+	var imageURLs []string
+	var files []message.Attachment
 	scanner := bufio.NewScanner(resp.Body)
-	
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineSize)
+
+scan:
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "data: ") {
@@ -115,29 +198,64 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 			if data == "[DONE]" {
 				break
 			}
-			
+
 			var event map[string]interface{}
 			if err := json.Unmarshal([]byte(data), &event); err != nil {
 				a.logger.Warn("Failed to parse SSE event", zap.Error(err))
 				continue
 			}
 
-			// 提取消息内容
-			if answer, ok := event["answer"].(string); ok {
-				fullResponse.WriteString(answer)
-			}
-			
 			// 提取会话 ID
 			if cid, ok := event["conversation_id"].(string); ok && cid != "" {
 				conversationID = cid
 			}
-			
+
 			// 提取消息 ID
 			if mid, ok := event["message_id"].(string); ok && mid != "" {
 				messageID = mid
 			}
-			
-			// 处理文件（图片等）- 文件信息会在最终响应中返回
+
+			eventType, _ := event["event"].(string)
+			switch eventType {
+			case "message", "agent_message":
+				// 增量回答
+				if answer, ok := event["answer"].(string); ok && answer != "" {
+					fullResponse.WriteString(answer)
+					if onDelta != nil {
+						onDelta(answer)
+					}
+				}
+			case "message_replace":
+				// 内容审查替换整条回答
+				if answer, ok := event["answer"].(string); ok {
+					fullResponse.Reset()
+					fullResponse.WriteString(answer)
+				}
+			case "message_file":
+				// Agent 生成的图片/文件（工具输出等），用户上传的文件不再回传
+				if belongsTo, _ := event["belongs_to"].(string); belongsTo == "user" {
+					break
+				}
+				fileURL, _ := event["url"].(string)
+				if fileURL == "" {
+					break
+				}
+				fileURL = a.resolveFileURL(fileURL)
+				if fileType, _ := event["type"].(string); fileType == "image" {
+					imageURLs = append(imageURLs, fileURL)
+				} else {
+					files = append(files, message.Attachment{
+						Type: message.MessageTypeFile,
+						URL:  fileURL,
+						Name: path.Base(strings.SplitN(fileURL, "?", 2)[0]),
+					})
+				}
+			case "message_end":
+				break scan
+			case "error":
+				errMsg, _ := event["message"].(string)
+				return nil, fmt.Errorf("Dify stream error: %s", errMsg)
+			}
 		}
 	}
 
@@ -146,17 +264,18 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	}
 
 	response := fullResponse.String()
-	if response == "" {
+	if response == "" && len(imageURLs) == 0 && len(files) == 0 {
 		response = "抱歉，我没有理解您的问题。"
 	}
 
 	// 构建 AgentResponse
 	agentResp := &message.AgentResponse{
 		Content:   response,
-		ImageURLs: []string{},
+		ImageURLs: append([]string{}, imageURLs...),
+		Files:     files,
 		Metadata:  make(map[string]string),
 	}
-	
+
 	if conversationID != "" {
 		agentResp.Metadata["conversation_id"] = conversationID
 	}
@@ -167,3 +286,18 @@ func (a *Agent) Chat(ctx context.Context, req *message.AgentRequest) (*message.A
 	return agentResp, nil
 }
 
+// resolveFileURL 将 Dify 返回的相对路径（如 /files/tools/...）补全为完整地址
+func (a *Agent) resolveFileURL(fileURL string) string {
+	if strings.HasPrefix(fileURL, "http://") || strings.HasPrefix(fileURL, "https://") {
+		return fileURL
+	}
+	base, err := neturl.Parse(a.cfg.APIBase)
+	if err != nil {
+		return fileURL
+	}
+	ref, err := neturl.Parse(fileURL)
+	if err != nil {
+		return fileURL
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,102 @@
+package dify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// pngHeader PNG 文件头，按内容检测类型时识别为 image/png
+const pngHeader = "\x89PNG\r\n\x1a\n0000"
+
+func TestChatUploadsFilesAndReferencesThem(t *testing.T) {
+	const fileName = `季度 "报告" \ 终版.pdf`
+
+	var uploads []string
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/v1/files/upload":
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				t.Fatalf("FormFile: %v", err)
+			}
+			data, _ := io.ReadAll(file)
+			if r.FormValue("user") != "u1" {
+				t.Errorf("upload user = %q", r.FormValue("user"))
+			}
+			uploads = append(uploads, header.Filename+"|"+header.Header.Get("Content-Type"))
+			id := "file-doc"
+			if string(data) == pngHeader {
+				id = "file-img"
+			}
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"id":"`+id+`"}`)
+		case "/v1/chat-messages":
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode chat payload: %v", err)
+			}
+			io.WriteString(w, "data: {\"event\":\"message\",\"answer\":\"收到\",\"conversation_id\":\"c1\"}\n\n")
+			io.WriteString(w, "data: {\"event\":\"message_end\"}\n\n")
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	a := NewAgent(config.DifyConfig{APIKey: "key", APIBase: server.URL + "/v1", UserID: "u1"}, zap.NewNop())
+	req := &message.AgentRequest{
+		Query:     "看看这些",
+		SessionID: "s1",
+		ImageURLs: []string{"data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(pngHeader))},
+		Media:     []message.MediaFile{{Name: fileName, MimeType: "application/pdf", Data: []byte("%PDF-1.4")}},
+	}
+	resp, err := a.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Content != "收到" || resp.Metadata["conversation_id"] != "c1" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// 文件名中的引号和反斜杠需转义，否则 multipart 头无法解析
+	want := []string{"image.png|image/png", fileName + "|application/pdf"}
+	if len(uploads) != 2 || uploads[0] != want[0] || uploads[1] != want[1] {
+		t.Errorf("uploads = %q, want %q", uploads, want)
+	}
+
+	files, _ := payload["files"].([]interface{})
+	if len(files) != 2 {
+		t.Fatalf("payload files = %v", payload["files"])
+	}
+	for i, want := range []struct{ fileType, id string }{{"image", "file-img"}, {"document", "file-doc"}} {
+		f := files[i].(map[string]interface{})
+		if f["type"] != want.fileType || f["transfer_method"] != "local_file" || f["upload_file_id"] != want.id {
+			t.Errorf("files[%d] = %v", i, f)
+		}
+	}
+	if payload["user"] != "u1" {
+		t.Errorf("chat user = %v", payload["user"])
+	}
+
+	// 同一用户再次发送相同内容时复用已上传的文件
+	if _, err := a.Chat(context.Background(), req); err != nil {
+		t.Fatalf("second Chat: %v", err)
+	}
+	if len(uploads) != 2 {
+		t.Errorf("files uploaded again: %q", uploads)
+	}
+}
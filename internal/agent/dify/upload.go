@@ -0,0 +1,176 @@
+package dify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"xia_adpter/internal/agent"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxImageSize Dify 默认允许上传的图片大小上限
+	maxImageSize = 10 * 1024 * 1024
+	// maxDocumentSize、maxAudioSize、maxVideoSize Dify 默认允许上传的文档、音频、视频大小上限
+	maxDocumentSize = 15 * 1024 * 1024
+	maxAudioSize    = 50 * 1024 * 1024
+	maxVideoSize    = 100 * 1024 * 1024
+	// uploadCacheTTL 上传结果缓存时间，同一消息重试或回退时复用已上传的文件
+	uploadCacheTTL = time.Hour
+)
+
+// imageExtensions Dify 支持的图片类型及上传时使用的扩展名
+var imageExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// uploadResponse /files/upload 接口响应
+type uploadResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+}
+
+// uploadImages 上传请求中的 base64 图片，返回 chat-messages 的 files 参数
+// 无法上传的图片记录日志后跳过，不影响文本部分的对话
+func (a *Agent) uploadImages(ctx context.Context, images []string, user string) []map[string]interface{} {
+	var files []map[string]interface{}
+	for _, image := range images {
+		if !agent.IsInlineData(image) {
+			continue
+		}
+
+		fileID, err := a.uploadImage(ctx, image, user)
+		if err != nil {
+			a.logger.Warn("Failed to upload image to Dify", zap.Error(err))
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"type":            "image",
+			"transfer_method": "local_file",
+			"upload_file_id":  fileID,
+		})
+	}
+	return files
+}
+
+// uploadImage 通过 /files/upload 上传单张图片，返回 upload_file_id
+func (a *Agent) uploadImage(ctx context.Context, image string, user string) (string, error) {
+	data, err := agent.DecodeInlineData(image)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxImageSize {
+		return "", fmt.Errorf("image too large: %d bytes (max %d)", len(data), maxImageSize)
+	}
+
+	// 以实际内容判断类型，data URI 中声明的类型不一定可靠
+	mimeType := http.DetectContentType(data)
+	ext, ok := imageExtensions[mimeType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type: %s", mimeType)
+	}
+
+	return a.upload(ctx, data, "image."+ext, mimeType, user)
+}
+
+// uploadMedia 上传从平台下载的视频、音频、文档，返回 chat-messages 的 files 参数
+// 文件类型按内容判断，应用未开启对应的文件上传时 Dify 会返回错误，记录日志后跳过
+func (a *Agent) uploadMedia(ctx context.Context, media []message.MediaFile, user string) []map[string]interface{} {
+	var files []map[string]interface{}
+	for _, m := range media {
+		fileType, maxSize := difyFileType(m.MimeType)
+		if len(m.Data) > maxSize {
+			a.logger.Warn("File too large for Dify, skipped",
+				zap.String("name", m.Name),
+				zap.Int("size", len(m.Data)),
+				zap.Int("max_size", maxSize),
+			)
+			continue
+		}
+
+		name := m.Name
+		if name == "" {
+			name = "file"
+			if exts, _ := mime.ExtensionsByType(strings.Split(m.MimeType, ";")[0]); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+
+		fileID, err := a.upload(ctx, m.Data, name, m.MimeType, user)
+		if err != nil {
+			a.logger.Warn("Failed to upload file to Dify",
+				zap.String("name", name),
+				zap.Error(err),
+			)
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"type":            fileType,
+			"transfer_method": "local_file",
+			"upload_file_id":  fileID,
+		})
+	}
+	return files
+}
+
+// difyFileType 根据内容类型返回 Dify 的文件类型及大小上限
+func difyFileType(mimeType string) (string, int) {
+	if _, ok := imageExtensions[mimeType]; ok {
+		return "image", maxImageSize
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video", maxVideoSize
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio", maxAudioSize
+	default:
+		return "document", maxDocumentSize
+	}
+}
+
+// upload 通过 /files/upload 上传文件，返回 upload_file_id，同一用户上传相同内容时复用结果
+func (a *Agent) upload(ctx context.Context, data []byte, filename, mimeType, user string) (string, error) {
+	cacheKey := agent.UploadCacheKey(user, data)
+	if fileID, ok := a.uploads.Get(cacheKey); ok {
+		return fileID, nil
+	}
+
+	url := fmt.Sprintf("%s/files/upload", a.cfg.APIBase)
+	status, respBody, err := agent.UploadFile(ctx, a.client, url, a.cfg.APIKey, filename, mimeType, data, map[string]string{"user": user})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return "", fmt.Errorf("Dify upload error: %d, %s", status, string(respBody))
+	}
+
+	var result uploadResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("failed to parse upload response: %w", err)
+	}
+	if result.ID == "" {
+		return "", fmt.Errorf("Dify upload response missing file id")
+	}
+
+	a.logger.Debug("Uploaded file to Dify",
+		zap.String("file_id", result.ID),
+		zap.String("name", filename),
+		zap.String("mime_type", mimeType),
+		zap.Int("size", len(data)),
+	)
+
+	a.uploads.Set(cacheKey, result.ID)
+	return result.ID, nil
+}
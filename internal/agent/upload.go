@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quoteEscaper 转义 multipart 头中带引号的参数值，与 multipart.Writer.CreateFormFile 相同，另外去掉换行
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"", "\r", "", "\n", "")
+
+// IsInlineData 判断图片/文件是否为 base64 数据（需要上传到 Agent 平台），否则视为 URL
+func IsInlineData(s string) bool {
+	return strings.HasPrefix(s, "data:") ||
+		(len(s) > 100 && !strings.HasPrefix(s, "http"))
+}
+
+// DecodeInlineData 解码 data URI 或纯 base64 数据
+func DecodeInlineData(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		idx := strings.Index(s, ",")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid data URI")
+		}
+		s = s[idx+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 data: %w", err)
+	}
+	return data, nil
+}
+
+// UploadCache 缓存已上传文件的 ID，同一消息重试或回退时复用，避免重复上传
+type UploadCache struct {
+	ttl     time.Duration
+	entries map[string]uploadCacheEntry
+	mu      sync.Mutex
+}
+
+type uploadCacheEntry struct {
+	fileID    string
+	expiresAt time.Time
+}
+
+// NewUploadCache 创建上传缓存
+func NewUploadCache(ttl time.Duration) *UploadCache {
+	return &UploadCache{
+		ttl:     ttl,
+		entries: make(map[string]uploadCacheEntry),
+	}
+}
+
+// UploadCacheKey 根据上传者与文件内容生成缓存键
+func UploadCacheKey(user string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return user + ":" + hex.EncodeToString(sum[:])
+}
+
+// Get 获取未过期的文件 ID
+func (c *UploadCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return "", false
+	}
+	return e.fileID, true
+}
+
+// Set 保存文件 ID，同时清理过期的记录
+func (c *UploadCache) Set(key, fileID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = uploadCacheEntry{fileID: fileID, expiresAt: now.Add(c.ttl)}
+}
+
+// UploadFile 以 multipart/form-data 上传文件，文件放在 file 字段，fields 为其余表单字段
+// 返回响应状态码和内容，由调用方按各平台的格式解析
+func UploadFile(ctx context.Context, client *http.Client, url, apiKey, filename, mimeType string, data []byte, fields map[string]string) (int, []byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", mimeType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return 0, nil, fmt.Errorf("failed to write form file: %w", err)
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := w.WriteField(name, fields[name]); err != nil {
+			return 0, nil, fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, nil, fmt.Errorf("failed to close form: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	httpReq.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read upload response: %w", err)
+	}
+	return resp.StatusCode, respBody, nil
+}
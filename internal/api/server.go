@@ -1,10 +1,14 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"sync"
 
 	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/pipeline"
+	"xia_adpter/internal/platform"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -12,10 +16,14 @@ import (
 
 // Server API 服务器
 type Server struct {
-	cfg      *config.Config
+	cfg        *config.Config
 	configPath string
-	logger   *zap.Logger
-	mu       sync.RWMutex
+	logger     *zap.Logger
+	mu         sync.RWMutex
+
+	platforms *platform.Registry
+	queue     *message.Queue
+	pipeline  *pipeline.Pipeline
 }
 
 // NewServer 创建新的 API 服务器
@@ -27,6 +35,27 @@ func NewServer(cfg *config.Config, configPath string, logger *zap.Logger) *Serve
 	}
 }
 
+// SetPlatforms 设置平台注册表，用于查看和控制平台适配器
+func (s *Server) SetPlatforms(platforms *platform.Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.platforms = platforms
+}
+
+// SetQueue 设置消息队列，用于查看队列统计和死信
+func (s *Server) SetQueue(queue *message.Queue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = queue
+}
+
+// SetPipeline 设置消息处理管道，用于查看工作池统计
+func (s *Server) SetPipeline(p *pipeline.Pipeline) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pipeline = p
+}
+
 // SetupRoutes 设置路由
 func (s *Server) SetupRoutes(router *gin.Engine) {
 	// 静态文件服务（使用绝对路径）
@@ -42,6 +71,15 @@ func (s *Server) SetupRoutes(router *gin.Engine) {
 		api.GET("/config", s.getConfig)
 		api.PUT("/config", s.updateConfig)
 		api.GET("/status", s.getStatus)
+		api.GET("/platforms", s.listPlatforms)
+		api.POST("/platforms/:name/start", s.startPlatform)
+		api.POST("/platforms/:name/stop", s.stopPlatform)
+		api.GET("/queue", s.getQueueStats)
+		api.GET("/queue/dead-letters", s.listDeadLetters)
+		api.GET("/pipeline", s.getPipelineStats)
+		api.GET("/delivery/dead-letters", s.listDeliveryDeadLetters)
+		api.POST("/delivery/dead-letters/:id/resend", s.resendDeliveryDeadLetter)
+		api.DELETE("/delivery/dead-letters/:id", s.discardDeliveryDeadLetter)
 	}
 }
 
@@ -100,14 +138,24 @@ func (s *Server) getStatus(c *gin.Context) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// 平台运行状态
+	running := make(map[string]bool)
+	if s.platforms != nil {
+		for _, status := range s.platforms.Status() {
+			running[status.Name] = status.Running
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
 			"lark": gin.H{
 				"enabled": s.cfg.Platform.Lark.Enabled,
+				"running": running["lark"],
 			},
 			"wecom": gin.H{
 				"enabled": s.cfg.Platform.WeCom.Enabled,
+				"running": running["wecom"],
 			},
 			"dify": gin.H{
 				"enabled": s.cfg.Agent.Dify.Enabled,
@@ -119,3 +167,191 @@ func (s *Server) getStatus(c *gin.Context) {
 	})
 }
 
+// listPlatforms 获取平台适配器运行状态
+func (s *Server) listPlatforms(c *gin.Context) {
+	s.mu.RLock()
+	platforms := s.platforms
+	s.mu.RUnlock()
+
+	statuses := []platform.Status{}
+	if platforms != nil {
+		statuses = platforms.Status()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    statuses,
+	})
+}
+
+// startPlatform 启动平台适配器
+func (s *Server) startPlatform(c *gin.Context) {
+	s.controlPlatform(c, func(platforms *platform.Registry, name string) error {
+		return platforms.Start(name)
+	})
+}
+
+// stopPlatform 停止平台适配器
+func (s *Server) stopPlatform(c *gin.Context) {
+	s.controlPlatform(c, func(platforms *platform.Registry, name string) error {
+		return platforms.Stop(name)
+	})
+}
+
+// controlPlatform 对指定平台执行启动/停止操作
+func (s *Server) controlPlatform(c *gin.Context, action func(*platform.Registry, string) error) {
+	s.mu.RLock()
+	platforms := s.platforms
+	s.mu.RUnlock()
+
+	if platforms == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "平台注册表未初始化",
+		})
+		return
+	}
+
+	name := c.Param("name")
+	if _, ok := platforms.Get(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "平台不存在: " + name,
+		})
+		return
+	}
+
+	if err := action(platforms, name); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    platforms.Status(),
+	})
+}
+
+// getQueueStats 获取消息队列统计
+func (s *Server) getQueueStats(c *gin.Context) {
+	s.mu.RLock()
+	queue := s.queue
+	s.mu.RUnlock()
+
+	if queue == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "消息队列未初始化",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    queue.Stats(),
+	})
+}
+
+// listDeadLetters 获取无法入队的消息
+func (s *Server) listDeadLetters(c *gin.Context) {
+	s.mu.RLock()
+	queue := s.queue
+	s.mu.RUnlock()
+
+	deadLetters := []message.DeadLetter{}
+	if queue != nil {
+		deadLetters = append(deadLetters, queue.DeadLetters()...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    deadLetters,
+	})
+}
+
+// getPipelineStats 获取消息处理工作池统计
+func (s *Server) getPipelineStats(c *gin.Context) {
+	s.mu.RLock()
+	p := s.pipeline
+	s.mu.RUnlock()
+
+	if p == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "消息处理管道未初始化",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    p.Stats(),
+	})
+}
+
+// listDeliveryDeadLetters 获取重试后仍无法送达的回复
+func (s *Server) listDeliveryDeadLetters(c *gin.Context) {
+	s.mu.RLock()
+	p := s.pipeline
+	s.mu.RUnlock()
+
+	deadLetters := []pipeline.DeliveryDeadLetter{}
+	if p != nil {
+		deadLetters = append(deadLetters, p.DeadLetters()...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    deadLetters,
+	})
+}
+
+// resendDeliveryDeadLetter 重新投递未送达的回复
+func (s *Server) resendDeliveryDeadLetter(c *gin.Context) {
+	s.controlDeadLetter(c, func(p *pipeline.Pipeline, id string) error {
+		return p.ResendDeadLetter(c.Request.Context(), id)
+	})
+}
+
+// discardDeliveryDeadLetter 删除未送达的回复
+func (s *Server) discardDeliveryDeadLetter(c *gin.Context) {
+	s.controlDeadLetter(c, func(p *pipeline.Pipeline, id string) error {
+		return p.DiscardDeadLetter(id)
+	})
+}
+
+// controlDeadLetter 对指定的未送达回复执行重发/删除操作
+func (s *Server) controlDeadLetter(c *gin.Context, action func(*pipeline.Pipeline, string) error) {
+	s.mu.RLock()
+	p := s.pipeline
+	s.mu.RUnlock()
+
+	if p == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "消息处理管道未初始化",
+		})
+		return
+	}
+
+	id := c.Param("id")
+	if err := action(p, id); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, pipeline.ErrDeadLetterNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    p.DeadLetters(),
+	})
+}
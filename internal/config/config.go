@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -12,6 +13,12 @@ type Config struct {
 	Server   ServerConfig   `mapstructure:"server" json:"server"`
 	Platform PlatformConfig `mapstructure:"platform" json:"platform"`
 	Agent    AgentConfig    `mapstructure:"agent" json:"agent"`
+	Routes   []RouteConfig  `mapstructure:"routes" json:"routes"`
+	Session  SessionConfig  `mapstructure:"session" json:"session"`
+	Dedup    DedupConfig    `mapstructure:"dedup" json:"dedup"`
+	Queue    QueueConfig    `mapstructure:"queue" json:"queue"`
+	Pipeline PipelineConfig `mapstructure:"pipeline" json:"pipeline"`
+	Voice    VoiceConfig    `mapstructure:"voice" json:"voice"`
 }
 
 // ServerConfig 服务器配置
@@ -33,33 +40,134 @@ type LarkConfig struct {
 	AppSecret string `mapstructure:"app_secret" json:"app_secret"`
 	Domain    string `mapstructure:"domain" json:"domain"` // feishu.cn 或 larksuite.com
 	BotName   string `mapstructure:"bot_name" json:"bot_name"`
+	BotOpenID string `mapstructure:"bot_open_id" json:"bot_open_id"` // 机器人 open_id，为空时启动时通过接口获取
+
+	// 群聊触发方式：always 响应所有消息、mention 仅响应 @机器人、keyword 响应 @机器人或以关键词开头的消息
+	GroupMode string           `mapstructure:"group_mode" json:"group_mode"`
+	Keywords  []string         `mapstructure:"keywords" json:"keywords"` // keyword 模式的前缀，匹配后从内容中去除
+	Chats     []LarkChatConfig `mapstructure:"chats" json:"chats"`       // 按群覆盖触发方式
+
+	Reply     LarkReplyConfig     `mapstructure:"reply" json:"reply"`
+	Streaming LarkStreamingConfig `mapstructure:"streaming" json:"streaming"`
+}
+
+// LarkReplyConfig 飞书回复方式
+type LarkReplyConfig struct {
+	Mode          string `mapstructure:"mode" json:"mode"`                     // chat 直接发送到会话、quote 引用提问消息回复、thread 在话题中回复
+	MentionSender bool   `mapstructure:"mention_sender" json:"mention_sender"` // 群聊中回复时 @ 提问者
+}
+
+// LarkChatConfig 单个群聊的触发方式，未设置的字段使用平台配置
+type LarkChatConfig struct {
+	ChatID    string   `mapstructure:"chat_id" json:"chat_id"`
+	GroupMode string   `mapstructure:"group_mode" json:"group_mode"`
+	Keywords  []string `mapstructure:"keywords" json:"keywords"`
+}
+
+// LarkStreamingConfig 飞书流式回复配置（先发送卡片，再逐步更新内容）
+type LarkStreamingConfig struct {
+	Enabled    bool `mapstructure:"enabled" json:"enabled"`
+	IntervalMs int  `mapstructure:"interval_ms" json:"interval_ms"` // 两次更新的最小间隔（毫秒）
+	MinChars   int  `mapstructure:"min_chars" json:"min_chars"`     // 新增字符数达到该值时立即更新
 }
 
 // WeComConfig 企微配置
 type WeComConfig struct {
-	Enabled       bool   `mapstructure:"enabled" json:"enabled"`
-	CorpID        string `mapstructure:"corp_id" json:"corp_id"`
-	Secret        string `mapstructure:"secret" json:"secret"`
-	Token         string `mapstructure:"token" json:"token"`
+	Enabled        bool   `mapstructure:"enabled" json:"enabled"`
+	CorpID         string `mapstructure:"corp_id" json:"corp_id"`
+	Secret         string `mapstructure:"secret" json:"secret"`
+	Token          string `mapstructure:"token" json:"token"`
 	EncodingAESKey string `mapstructure:"encoding_aes_key" json:"encoding_aes_key"`
-	Port          int    `mapstructure:"port" json:"port"`
-	Host          string `mapstructure:"host" json:"host"`
-	AgentID       int    `mapstructure:"agent_id" json:"agent_id"` // 应用 AgentID
+	Port           int    `mapstructure:"port" json:"port"`
+	Host           string `mapstructure:"host" json:"host"`
+	AgentID        int    `mapstructure:"agent_id" json:"agent_id"`               // 应用 AgentID
+	ReplyFormat    string `mapstructure:"reply_format" json:"reply_format"`       // 回复格式：auto, text, markdown, card
+	APIBase        string `mapstructure:"api_base" json:"api_base"`               // 企微接口地址，可指向转发代理
+	HTTPTimeoutMs  int    `mapstructure:"http_timeout_ms" json:"http_timeout_ms"` // 调用企微接口的超时时间（毫秒）
+	Proxy          string `mapstructure:"proxy" json:"proxy"`                     // 调用企微接口使用的 HTTP 代理，为空时使用环境变量
+
+	PassiveReply WeComPassiveReplyConfig `mapstructure:"passive_reply" json:"passive_reply"`
+	Events       []WeComEventConfig      `mapstructure:"events" json:"events"` // 事件处理规则，按顺序匹配第一条
+}
+
+// WeComEventConfig 企微事件（进入应用、关注、菜单点击、上报位置等）的处理方式
+type WeComEventConfig struct {
+	Event    string `mapstructure:"event" json:"event"`         // enter_agent, subscribe, unsubscribe, click, view, LOCATION, template_card_event
+	EventKey string `mapstructure:"event_key" json:"event_key"` // 菜单、按钮的 key，为空时匹配该事件的所有 key
+	Action   string `mapstructure:"action" json:"action"`       // reply 直接回复、query 作为问题发送给 Agent、ignore 忽略
+	Text     string `mapstructure:"text" json:"text"`           // 回复内容或问题，{key}、{latitude}、{longitude}、{user} 会被替换
+}
+
+// WeComPassiveReplyConfig 企微被动回复：在回调响应中直接返回回复，超时后改为主动发送
+type WeComPassiveReplyConfig struct {
+	Enabled   bool `mapstructure:"enabled" json:"enabled"`
+	TimeoutMs int  `mapstructure:"timeout_ms" json:"timeout_ms"` // 等待回复的时间（毫秒），需小于企微的 5 秒响应时限
 }
 
 // AgentConfig Agent 配置
 type AgentConfig struct {
 	Dify DifyConfig `mapstructure:"dify" json:"dify"`
 	Coze CozeConfig `mapstructure:"coze" json:"coze"`
+
+	// Default 默认使用的 Agent 名称，为空时按注册顺序选择
+	Default string `mapstructure:"default" json:"default"`
+	// Instances 额外的 Agent 实例，按 type 创建
+	Instances []AgentInstanceConfig `mapstructure:"instances" json:"instances"`
+}
+
+// AgentInstanceConfig 通用 Agent 实例配置
+type AgentInstanceConfig struct {
+	Name    string `mapstructure:"name" json:"name"` // 实例名称，路由等处通过名称引用
+	Type    string `mapstructure:"type" json:"type"` // dify, coze
+	Enabled bool   `mapstructure:"enabled" json:"enabled"`
+	APIKey  string `mapstructure:"api_key" json:"api_key"`
+	APIBase string `mapstructure:"api_base" json:"api_base"`
+	AppID   string `mapstructure:"app_id" json:"app_id"` // Dify 应用 ID
+	BotID   string `mapstructure:"bot_id" json:"bot_id"` // Coze Bot ID
+	UserID  string `mapstructure:"user_id" json:"user_id"`
+}
+
+// EnabledInstances 返回所有启用的 Agent 实例配置
+// 兼容旧的 dify/coze 配置段，分别以 "dify"、"coze" 作为实例名称
+func (c AgentConfig) EnabledInstances() []AgentInstanceConfig {
+	var instances []AgentInstanceConfig
+	if c.Dify.Enabled {
+		instances = append(instances, AgentInstanceConfig{
+			Name:    "dify",
+			Type:    "dify",
+			Enabled: true,
+			APIKey:  c.Dify.APIKey,
+			APIBase: c.Dify.APIBase,
+			AppID:   c.Dify.AppID,
+			UserID:  c.Dify.UserID,
+		})
+	}
+	if c.Coze.Enabled {
+		instances = append(instances, AgentInstanceConfig{
+			Name:    "coze",
+			Type:    "coze",
+			Enabled: true,
+			APIKey:  c.Coze.APIKey,
+			APIBase: c.Coze.APIBase,
+			BotID:   c.Coze.BotID,
+			UserID:  c.Coze.UserID,
+		})
+	}
+	for _, inst := range c.Instances {
+		if inst.Enabled {
+			instances = append(instances, inst)
+		}
+	}
+	return instances
 }
 
 // DifyConfig Dify 配置
 type DifyConfig struct {
-	Enabled  bool   `mapstructure:"enabled" json:"enabled"`
-	APIKey   string `mapstructure:"api_key" json:"api_key"`
-	APIBase  string `mapstructure:"api_base" json:"api_base"`
-	AppID    string `mapstructure:"app_id" json:"app_id"` // Dify 应用 ID
-	UserID   string `mapstructure:"user_id" json:"user_id"`
+	Enabled bool   `mapstructure:"enabled" json:"enabled"`
+	APIKey  string `mapstructure:"api_key" json:"api_key"`
+	APIBase string `mapstructure:"api_base" json:"api_base"`
+	AppID   string `mapstructure:"app_id" json:"app_id"` // Dify 应用 ID
+	UserID  string `mapstructure:"user_id" json:"user_id"`
 }
 
 // CozeConfig Coze 配置
@@ -71,6 +179,76 @@ type CozeConfig struct {
 	UserID  string `mapstructure:"user_id" json:"user_id"`
 }
 
+// RouteConfig 消息路由规则，所有非空条件都满足时命中，按顺序匹配第一条
+type RouteConfig struct {
+	Name        string `mapstructure:"name" json:"name"`
+	Agent       string `mapstructure:"agent" json:"agent"`               // 目标 Agent 名称
+	Fallback    bool   `mapstructure:"fallback" json:"fallback"`         // 目标 Agent 失败时是否回退到其他 Agent
+	Platform    string `mapstructure:"platform" json:"platform"`         // lark, wecom
+	ChatType    string `mapstructure:"chat_type" json:"chat_type"`       // p2p, group
+	ChatID      string `mapstructure:"chat_id" json:"chat_id"`           // 群聊 ID
+	UserID      string `mapstructure:"user_id" json:"user_id"`           // 发送者 ID
+	Content     string `mapstructure:"content" json:"content"`           // 消息内容正则
+	MessageType string `mapstructure:"message_type" json:"message_type"` // text, image, voice, file
+	ReplyFormat string `mapstructure:"reply_format" json:"reply_format"` // 覆盖平台的回复格式，为空时使用平台配置
+}
+
+// SessionConfig 会话存储配置，保存平台会话与 Agent 会话 ID 的映射以支持多轮对话
+type SessionConfig struct {
+	Backend string `mapstructure:"backend" json:"backend"` // memory, file
+	Path    string `mapstructure:"path" json:"path"`       // file 后端的文件路径
+	TTL     int    `mapstructure:"ttl" json:"ttl"`         // 会话有效期（秒）
+}
+
+// DedupConfig 入站事件去重配置，平台重推的消息在有效期内只处理一次
+type DedupConfig struct {
+	Backend string `mapstructure:"backend" json:"backend"` // memory, file
+	Path    string `mapstructure:"path" json:"path"`       // file 后端的文件路径
+	TTL     int    `mapstructure:"ttl" json:"ttl"`         // 消息 ID 保留时间（秒）
+}
+
+// QueueConfig 消息队列配置
+type QueueConfig struct {
+	Backend        string `mapstructure:"backend" json:"backend"`                   // memory, wal
+	Size           int    `mapstructure:"size" json:"size"`                         // 队列容量
+	PushTimeoutMs  int    `mapstructure:"push_timeout_ms" json:"push_timeout_ms"`   // 队列满时入队的最长等待时间（毫秒）
+	DeadLetterSize int    `mapstructure:"dead_letter_size" json:"dead_letter_size"` // 保留的死信数量
+	WALPath        string `mapstructure:"wal_path" json:"wal_path"`                 // wal 后端的日志文件路径
+	WALSync        bool   `mapstructure:"wal_sync" json:"wal_sync"`                 // 每次写入后是否 fsync
+}
+
+// PipelineConfig 消息处理配置，同一会话的消息按顺序处理，不同会话并行处理
+type PipelineConfig struct {
+	Workers          int `mapstructure:"workers" json:"workers"`                       // 全局并发数
+	SessionQueueSize int `mapstructure:"session_queue_size" json:"session_queue_size"` // 每个会话最多排队的消息数，超出时记入队列死信
+	MaxPending       int `mapstructure:"max_pending" json:"max_pending"`               // 所有会话合计最多排队的消息数，达到后暂停从队列取消息
+	MaxMediaSizeMB   int `mapstructure:"max_media_size_mb" json:"max_media_size_mb"`   // 下载后交给 Agent 的图片、视频、文件大小上限
+
+	Delivery DeliveryConfig `mapstructure:"delivery" json:"delivery"`
+}
+
+// DeliveryConfig 回复投递配置，可重试的发送错误按指数退避重试，仍失败的回复记入死信，可通过管理接口重发
+type DeliveryConfig struct {
+	MaxAttempts      int `mapstructure:"max_attempts" json:"max_attempts"`             // 最多投递次数（含第一次）
+	InitialBackoffMs int `mapstructure:"initial_backoff_ms" json:"initial_backoff_ms"` // 第一次重试前的等待时间（毫秒），之后每次翻倍
+	MaxBackoffMs     int `mapstructure:"max_backoff_ms" json:"max_backoff_ms"`         // 重试等待时间上限（毫秒）
+	DeadLetterSize   int `mapstructure:"dead_letter_size" json:"dead_letter_size"`     // 保留的未送达回复数量
+}
+
+// VoiceConfig 语音识别配置，语音消息先转码为 wav 再调用识别服务，识别结果作为查询内容
+type VoiceConfig struct {
+	Enabled    bool   `mapstructure:"enabled" json:"enabled"`
+	Provider   string `mapstructure:"provider" json:"provider"`       // whisper（OpenAI 兼容接口）, command（本地命令）
+	APIBase    string `mapstructure:"api_base" json:"api_base"`       // whisper 接口地址
+	APIKey     string `mapstructure:"api_key" json:"api_key"`         // whisper 接口密钥
+	Model      string `mapstructure:"model" json:"model"`             // whisper 模型名称
+	Language   string `mapstructure:"language" json:"language"`       // 语言代码，为空时自动识别
+	Command    string `mapstructure:"command" json:"command"`         // command 识别命令，{input} 替换为 wav 文件路径
+	FFmpegPath string `mapstructure:"ffmpeg_path" json:"ffmpeg_path"` // 转码使用的 ffmpeg
+	TimeoutMs  int    `mapstructure:"timeout_ms" json:"timeout_ms"`   // 单条语音识别的超时时间（毫秒）
+	MaxSizeMB  int    `mapstructure:"max_size_mb" json:"max_size_mb"` // 语音文件大小上限
+}
+
 // Load 加载配置文件
 func Load(configPath string) (*Config, error) {
 	viper.SetConfigFile(configPath)
@@ -101,10 +279,44 @@ func setDefaults() {
 	viper.SetDefault("server.host", "0.0.0.0")
 	viper.SetDefault("server.port", 8080)
 	viper.SetDefault("platform.lark.domain", "feishu.cn")
+	viper.SetDefault("platform.lark.group_mode", "mention")
+	viper.SetDefault("platform.lark.reply.mode", "quote")
+	viper.SetDefault("platform.lark.reply.mention_sender", true)
+	viper.SetDefault("platform.lark.streaming.interval_ms", 800)
+	viper.SetDefault("platform.lark.streaming.min_chars", 50)
 	viper.SetDefault("platform.wecom.host", "0.0.0.0")
 	viper.SetDefault("platform.wecom.port", 8888)
+	viper.SetDefault("platform.wecom.reply_format", "auto")
+	viper.SetDefault("platform.wecom.api_base", "https://qyapi.weixin.qq.com")
+	viper.SetDefault("platform.wecom.http_timeout_ms", 10000)
+	viper.SetDefault("platform.wecom.passive_reply.timeout_ms", 4000)
 	viper.SetDefault("agent.dify.api_base", "https://api.dify.ai/v1")
 	viper.SetDefault("agent.coze.api_base", "https://api.coze.cn")
+	viper.SetDefault("session.backend", "memory")
+	viper.SetDefault("session.path", "data/sessions.log")
+	viper.SetDefault("session.ttl", 86400)
+	viper.SetDefault("dedup.backend", "memory")
+	viper.SetDefault("dedup.path", "data/dedup.log")
+	viper.SetDefault("dedup.ttl", 86400)
+	viper.SetDefault("queue.backend", "memory")
+	viper.SetDefault("queue.size", 1000)
+	viper.SetDefault("queue.push_timeout_ms", 3000)
+	viper.SetDefault("queue.dead_letter_size", 100)
+	viper.SetDefault("queue.wal_path", "data/queue.wal")
+	viper.SetDefault("pipeline.workers", 16)
+	viper.SetDefault("pipeline.session_queue_size", 20)
+	viper.SetDefault("pipeline.max_pending", 200)
+	viper.SetDefault("pipeline.max_media_size_mb", 20)
+	viper.SetDefault("pipeline.delivery.max_attempts", 4)
+	viper.SetDefault("pipeline.delivery.initial_backoff_ms", 500)
+	viper.SetDefault("pipeline.delivery.max_backoff_ms", 10000)
+	viper.SetDefault("pipeline.delivery.dead_letter_size", 100)
+	viper.SetDefault("voice.provider", "whisper")
+	viper.SetDefault("voice.api_base", "https://api.openai.com/v1")
+	viper.SetDefault("voice.model", "whisper-1")
+	viper.SetDefault("voice.ffmpeg_path", "ffmpeg")
+	viper.SetDefault("voice.timeout_ms", 60000)
+	viper.SetDefault("voice.max_size_mb", 25)
 }
 
 func overrideFromEnv(cfg *Config) {
@@ -126,6 +338,9 @@ func overrideFromEnv(cfg *Config) {
 	if cozeKey := os.Getenv("COZE_API_KEY"); cozeKey != "" {
 		cfg.Agent.Coze.APIKey = cozeKey
 	}
+	if voiceKey := os.Getenv("VOICE_API_KEY"); voiceKey != "" {
+		cfg.Voice.APIKey = voiceKey
+	}
 }
 
 // Save 保存配置到文件
@@ -143,6 +358,15 @@ func Save(cfg *Config, configPath string) error {
 	viper.Set("platform.lark.app_secret", cfg.Platform.Lark.AppSecret)
 	viper.Set("platform.lark.domain", cfg.Platform.Lark.Domain)
 	viper.Set("platform.lark.bot_name", cfg.Platform.Lark.BotName)
+	viper.Set("platform.lark.bot_open_id", cfg.Platform.Lark.BotOpenID)
+	viper.Set("platform.lark.group_mode", cfg.Platform.Lark.GroupMode)
+	viper.Set("platform.lark.keywords", cfg.Platform.Lark.Keywords)
+	viper.Set("platform.lark.chats", toPlain(cfg.Platform.Lark.Chats))
+	viper.Set("platform.lark.reply.mode", cfg.Platform.Lark.Reply.Mode)
+	viper.Set("platform.lark.reply.mention_sender", cfg.Platform.Lark.Reply.MentionSender)
+	viper.Set("platform.lark.streaming.enabled", cfg.Platform.Lark.Streaming.Enabled)
+	viper.Set("platform.lark.streaming.interval_ms", cfg.Platform.Lark.Streaming.IntervalMs)
+	viper.Set("platform.lark.streaming.min_chars", cfg.Platform.Lark.Streaming.MinChars)
 
 	viper.Set("platform.wecom.enabled", cfg.Platform.WeCom.Enabled)
 	viper.Set("platform.wecom.corp_id", cfg.Platform.WeCom.CorpID)
@@ -152,6 +376,13 @@ func Save(cfg *Config, configPath string) error {
 	viper.Set("platform.wecom.host", cfg.Platform.WeCom.Host)
 	viper.Set("platform.wecom.port", cfg.Platform.WeCom.Port)
 	viper.Set("platform.wecom.agent_id", cfg.Platform.WeCom.AgentID)
+	viper.Set("platform.wecom.reply_format", cfg.Platform.WeCom.ReplyFormat)
+	viper.Set("platform.wecom.api_base", cfg.Platform.WeCom.APIBase)
+	viper.Set("platform.wecom.http_timeout_ms", cfg.Platform.WeCom.HTTPTimeoutMs)
+	viper.Set("platform.wecom.proxy", cfg.Platform.WeCom.Proxy)
+	viper.Set("platform.wecom.passive_reply.enabled", cfg.Platform.WeCom.PassiveReply.Enabled)
+	viper.Set("platform.wecom.passive_reply.timeout_ms", cfg.Platform.WeCom.PassiveReply.TimeoutMs)
+	viper.Set("platform.wecom.events", toPlain(cfg.Platform.WeCom.Events))
 
 	// Agent 配置
 	viper.Set("agent.dify.enabled", cfg.Agent.Dify.Enabled)
@@ -166,7 +397,65 @@ func Save(cfg *Config, configPath string) error {
 	viper.Set("agent.coze.bot_id", cfg.Agent.Coze.BotID)
 	viper.Set("agent.coze.user_id", cfg.Agent.Coze.UserID)
 
+	viper.Set("agent.default", cfg.Agent.Default)
+	viper.Set("agent.instances", toPlain(cfg.Agent.Instances))
+
+	// 路由配置
+	viper.Set("routes", toPlain(cfg.Routes))
+
+	// 会话存储配置
+	viper.Set("session.backend", cfg.Session.Backend)
+	viper.Set("session.path", cfg.Session.Path)
+	viper.Set("session.ttl", cfg.Session.TTL)
+
+	// 入站事件去重配置
+	viper.Set("dedup.backend", cfg.Dedup.Backend)
+	viper.Set("dedup.path", cfg.Dedup.Path)
+	viper.Set("dedup.ttl", cfg.Dedup.TTL)
+
+	// 消息队列配置
+	viper.Set("queue.backend", cfg.Queue.Backend)
+	viper.Set("queue.size", cfg.Queue.Size)
+	viper.Set("queue.push_timeout_ms", cfg.Queue.PushTimeoutMs)
+	viper.Set("queue.dead_letter_size", cfg.Queue.DeadLetterSize)
+	viper.Set("queue.wal_path", cfg.Queue.WALPath)
+	viper.Set("queue.wal_sync", cfg.Queue.WALSync)
+
+	// 消息处理配置
+	viper.Set("pipeline.workers", cfg.Pipeline.Workers)
+	viper.Set("pipeline.session_queue_size", cfg.Pipeline.SessionQueueSize)
+	viper.Set("pipeline.max_pending", cfg.Pipeline.MaxPending)
+	viper.Set("pipeline.max_media_size_mb", cfg.Pipeline.MaxMediaSizeMB)
+	viper.Set("pipeline.delivery.max_attempts", cfg.Pipeline.Delivery.MaxAttempts)
+	viper.Set("pipeline.delivery.initial_backoff_ms", cfg.Pipeline.Delivery.InitialBackoffMs)
+	viper.Set("pipeline.delivery.max_backoff_ms", cfg.Pipeline.Delivery.MaxBackoffMs)
+	viper.Set("pipeline.delivery.dead_letter_size", cfg.Pipeline.Delivery.DeadLetterSize)
+
+	// 语音识别配置
+	viper.Set("voice.enabled", cfg.Voice.Enabled)
+	viper.Set("voice.provider", cfg.Voice.Provider)
+	viper.Set("voice.api_base", cfg.Voice.APIBase)
+	viper.Set("voice.api_key", cfg.Voice.APIKey)
+	viper.Set("voice.model", cfg.Voice.Model)
+	viper.Set("voice.language", cfg.Voice.Language)
+	viper.Set("voice.command", cfg.Voice.Command)
+	viper.Set("voice.ffmpeg_path", cfg.Voice.FFmpegPath)
+	viper.Set("voice.timeout_ms", cfg.Voice.TimeoutMs)
+	viper.Set("voice.max_size_mb", cfg.Voice.MaxSizeMB)
+
 	// 写入文件
 	return viper.WriteConfig()
 }
 
+// toPlain 将结构体转换为 map/slice，使写入文件的字段名与配置键一致
+func toPlain(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var plain interface{}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return nil
+	}
+	return plain
+}
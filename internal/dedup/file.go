@@ -0,0 +1,189 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultFilePath 文件存储默认路径
+	defaultFilePath = "data/dedup.log"
+	// compactThreshold 日志记录数超过该值且多数已过期或删除时重写日志
+	compactThreshold = 1000
+)
+
+// fileRecord 日志中的一条记录，每行一个 JSON；ExpiresAt 为零值表示删除该键
+type fileRecord struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// FileStore 文件去重存储，在内存存储的基础上把事件键追加到日志文件，重启后平台重推的事件仍能识别
+// 每个新事件只追加一行，记录数远多于有效键时重写日志，去掉过期和已删除的记录
+type FileStore struct {
+	*MemoryStore
+	path    string
+	file    *os.File
+	records int // 日志中的记录数
+}
+
+// NewFileStore 创建文件去重存储，并加载已有的事件键
+func NewFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	if path == "" {
+		path = defaultFilePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dedup directory: %w", err)
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(ttl),
+		path:        path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	// 重写日志，去掉加载时已过期的记录
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seen 记录事件键，新的事件键追加到日志
+func (s *FileStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen(key) {
+		return true, nil
+	}
+	return false, s.append(fileRecord{Key: key, ExpiresAt: s.entries[key]})
+}
+
+// Forget 删除事件键并追加删除记录
+func (s *FileStore) Forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return s.append(fileRecord{Key: key})
+}
+
+// Close 重写日志后关闭文件
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.rewrite()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+// load 重放日志，恢复未过期的事件键
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dedup file: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// 最后一条记录可能因进程中断写入不完整，忽略无法解析的行
+			continue
+		}
+		if rec.ExpiresAt.IsZero() || now.After(rec.ExpiresAt) {
+			delete(s.entries, rec.Key)
+			continue
+		}
+		s.entries[rec.Key] = rec.ExpiresAt
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dedup file: %w", err)
+	}
+	return nil
+}
+
+// append 追加一条记录，记录数过多时重写日志，调用方需持有锁
+func (s *FileStore) append(rec fileRecord) error {
+	if s.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dedup record: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write dedup file: %w", err)
+	}
+	s.records++
+
+	if s.records > compactThreshold && s.records > 2*len(s.entries) {
+		return s.rewrite()
+	}
+	return nil
+}
+
+// rewrite 只保留未过期的事件键重写日志（先写临时文件再重命名），调用方需持有锁
+func (s *FileStore) rewrite() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create dedup file: %w", err)
+	}
+
+	now := time.Now()
+	records := 0
+	w := bufio.NewWriter(f)
+	for key, expiresAt := range s.entries {
+		if now.After(expiresAt) {
+			continue
+		}
+		data, err := json.Marshal(fileRecord{Key: key, ExpiresAt: expiresAt})
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to marshal dedup record: %w", err)
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+		records++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dedup file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to replace dedup file: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	// 继续使用同一文件句柄追加
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek dedup file: %w", err)
+	}
+	s.file = f
+	s.records = records
+	return nil
+}
@@ -0,0 +1,117 @@
+package dedup
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// defaultTTL 事件键默认保留时间，需覆盖平台重推的时间范围
+const defaultTTL = 24 * time.Hour
+
+// Store 入站事件去重存储，记录已处理的平台消息 ID、事件 ID
+// 企微回调超时重试、飞书长连接重连后重推的事件在有效期内只处理一次
+type Store interface {
+	// Seen 记录事件键，有效期内已记录过时返回 true
+	Seen(key string) (bool, error)
+	// Forget 删除事件键，事件未能入队时调用，使平台重推的事件可以重新处理
+	Forget(key string) error
+	// Close 关闭存储
+	Close() error
+}
+
+// New 根据配置创建去重存储
+func New(cfg config.DedupConfig) (Store, error) {
+	ttl := time.Duration(cfg.TTL) * time.Second
+
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(ttl), nil
+	case "file":
+		return NewFileStore(cfg.Path, ttl)
+	default:
+		return nil, fmt.Errorf("unknown dedup backend %q", cfg.Backend)
+	}
+}
+
+// Key 返回平台内事件的去重键
+func Key(platform, id string) string {
+	return platform + "|" + id
+}
+
+// Check 记录事件键，有效期内已记录过时返回 true
+// 键为空时不去重；存储出错时记录日志并按新事件处理，避免存储故障导致丢消息
+func Check(store Store, key string, logger *zap.Logger) bool {
+	if key == "" {
+		return false
+	}
+	seen, err := store.Seen(key)
+	if err != nil {
+		logger.Warn("Failed to record event for dedup", zap.String("key", key), zap.Error(err))
+	}
+	return seen
+}
+
+// MemoryStore 内存去重存储
+type MemoryStore struct {
+	ttl       time.Duration
+	entries   map[string]time.Time // 键到过期时间
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore 创建内存去重存储，ttl 不大于 0 时使用默认有效期
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &MemoryStore{
+		ttl:       ttl,
+		entries:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Seen 记录事件键
+func (s *MemoryStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen(key), nil
+}
+
+// seen 记录事件键并定期清理过期记录，调用方需持有锁
+func (s *MemoryStore) seen(key string) bool {
+	now := time.Now()
+	if expiresAt, ok := s.entries[key]; ok && now.Before(expiresAt) {
+		return true
+	}
+	s.entries[key] = now.Add(s.ttl)
+
+	// 每分钟最多清理一次过期记录
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, expiresAt := range s.entries {
+			if now.After(expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	return false
+}
+
+// Forget 删除事件键
+func (s *MemoryStore) Forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// Close 关闭存储
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package dedup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	key := Key("wecom", "m1")
+	if seen, _ := s.Seen(key); seen {
+		t.Fatal("new key reported as seen")
+	}
+	if seen, _ := s.Seen(key); !seen {
+		t.Fatal("duplicate key not detected")
+	}
+	if seen, _ := s.Seen(Key("lark", "m1")); seen {
+		t.Error("keys of different platforms collided")
+	}
+
+	s.Forget(Key("lark", "m1"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if seen, _ := s.Seen(key); !seen {
+		t.Error("key lost after restart")
+	}
+	if seen, _ := s.Seen(Key("lark", "m1")); seen {
+		t.Error("forgotten key still reported as seen")
+	}
+}
+
+func TestFileStoreAppendsAndCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+
+	// 每个新键追加一条记录；记录远多于有效键时重写日志
+	for i := 0; i < 3*compactThreshold; i++ {
+		key := Key("wecom", strconv.Itoa(i))
+		s.Seen(key)
+		s.Forget(key)
+	}
+	s.Seen(Key("wecom", "kept"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > compactThreshold+1 {
+		t.Errorf("log has %d records, want compaction", lines)
+	}
+	if s.records != bytes.Count(data, []byte("\n")) {
+		t.Errorf("records = %d, log lines = %d", s.records, bytes.Count(data, []byte("\n")))
+	}
+}
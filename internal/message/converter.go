@@ -6,16 +6,54 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"unicode/utf8"
+
+	"xia_adpter/internal/session"
 )
 
 // Converter 消息格式转换器
-type Converter struct{}
+type Converter struct {
+	// 会话存储，用于查询和保存 Agent 的 conversation_id
+	sessions session.Store
+}
 
 // NewConverter 创建消息转换器
 func NewConverter() *Converter {
 	return &Converter{}
 }
 
+// SetSessionStore 设置会话存储
+func (c *Converter) SetSessionStore(store session.Store) {
+	c.sessions = store
+}
+
+// sessionKey 构建请求对应的会话键
+func sessionKey(req *AgentRequest) session.Key {
+	return session.Key{
+		Platform:  req.Platform,
+		SessionID: req.SessionID,
+		UserID:    req.UserID,
+		Agent:     req.Agent,
+	}
+}
+
+// ConversationID 从会话存储中查询请求对应 Agent 的 conversation_id
+func (c *Converter) ConversationID(req *AgentRequest) string {
+	if c.sessions == nil || req.Agent == "" {
+		return ""
+	}
+	cid, _ := c.sessions.Get(sessionKey(req))
+	return cid
+}
+
+// SaveConversationID 保存 Agent 返回的 conversation_id，下一轮对话继续使用
+func (c *Converter) SaveConversationID(req *AgentRequest, conversationID string) error {
+	if c.sessions == nil || req.Agent == "" || conversationID == "" {
+		return nil
+	}
+	return c.sessions.Set(sessionKey(req), conversationID)
+}
+
 // PlatformMessage 平台消息接口（各平台适配器实现）
 type PlatformMessage interface {
 	GetPlatform() string
@@ -28,20 +66,32 @@ type PlatformMessage interface {
 
 // AgentRequest Agent 请求格式
 type AgentRequest struct {
-	Query       string                   `json:"query"`        // 文本查询
-	ImageURLs   []string                 `json:"image_urls"`   // 图片 URL 列表（base64 或 URL）
-	SessionID   string                   `json:"session_id"`   // 会话 ID
-	UserID      string                   `json:"user_id"`      // 用户 ID
-	SystemPrompt string                  `json:"system_prompt,omitempty"` // 系统提示词
-	Contexts    []map[string]interface{} `json:"contexts,omitempty"`      // 历史上下文
-	Metadata    map[string]string        `json:"metadata,omitempty"`      // 元数据
+	Query        string                   `json:"query"`                   // 文本查询
+	ImageURLs    []string                 `json:"image_urls"`              // 图片 URL 列表（base64 或 URL）
+	SessionID    string                   `json:"session_id"`              // 会话 ID
+	UserID       string                   `json:"user_id"`                 // 用户 ID
+	Platform     string                   `json:"platform"`                // 来源平台
+	Agent        string                   `json:"agent"`                   // 处理请求的 Agent 名称
+	SystemPrompt string                   `json:"system_prompt,omitempty"` // 系统提示词
+	Contexts     []map[string]interface{} `json:"contexts,omitempty"`      // 历史上下文
+	Metadata     map[string]string        `json:"metadata,omitempty"`      // 元数据
+	Files        []AgentFile              `json:"files,omitempty"`         // 已上传到 Agent 平台的文件
+	Media        []MediaFile              `json:"-"`                       // 从平台下载的视频、文件等，由 Agent 上传
+}
+
+// AgentFile 已上传到 Agent 平台的文件，请求中通过 FileID 引用
+type AgentFile struct {
+	Type   string `json:"type"`    // image, file
+	FileID string `json:"file_id"` // Agent 平台返回的文件 ID
 }
 
 // AgentResponse Agent 响应格式
 type AgentResponse struct {
-	Content   string            `json:"content"`    // 文本内容
-	ImageURLs []string          `json:"image_urls"` // 图片 URL 列表
-	Metadata  map[string]string `json:"metadata"`   // 元数据
+	Content     string            `json:"content"`               // 文本内容
+	ImageURLs   []string          `json:"image_urls"`            // 图片 URL 列表
+	Files       []Attachment      `json:"files,omitempty"`       // 图片以外的文件
+	Suggestions []string          `json:"suggestions,omitempty"` // 推荐的后续问题
+	Metadata    map[string]string `json:"metadata"`              // 元数据
 }
 
 // ToAgentRequest 将统一消息格式转换为 Agent 请求格式
@@ -50,10 +100,12 @@ func (c *Converter) ToAgentRequest(msg *Message) *AgentRequest {
 		Query:     msg.Content,
 		SessionID: msg.SessionID,
 		UserID:    msg.UserID,
+		Platform:  msg.Platform,
+		Agent:     msg.Metadata["agent"],
 		ImageURLs: []string{},
 		Metadata:  make(map[string]string),
 	}
-	
+
 	// 复制原始消息的 Metadata，以便保存和复用 conversation_id
 	// 注意：只复制有效的 UUID 格式的 conversation_id，清除错误的格式
 	if msg.Metadata != nil {
@@ -70,11 +122,16 @@ func (c *Converter) ToAgentRequest(msg *Message) *AgentRequest {
 		}
 	}
 
+	// 从会话存储中恢复路由选定 Agent 的 conversation_id
+	if cid := c.ConversationID(req); cid != "" {
+		req.Metadata["conversation_id"] = cid
+	}
+
 	// 处理图片消息
 	if msg.MessageType == "image" {
 		// 检查 Content 是否是 base64
-		if strings.HasPrefix(msg.Content, "data:image/") || 
-		   (len(msg.Content) > 100 && !strings.HasPrefix(msg.Content, "http")) {
+		if strings.HasPrefix(msg.Content, "data:image/") ||
+			(len(msg.Content) > 100 && !strings.HasPrefix(msg.Content, "http")) {
 			// 可能是 base64 图片
 			if imageData, err := c.extractBase64Image(msg.Content); err == nil {
 				req.ImageURLs = append(req.ImageURLs, imageData)
@@ -83,11 +140,12 @@ func (c *Converter) ToAgentRequest(msg *Message) *AgentRequest {
 			// URL 图片
 			req.ImageURLs = append(req.ImageURLs, msg.Content)
 		}
+		// 图片内容不作为文本查询
+		req.Query = "[图片]"
 
 		// 从 Metadata 中获取图片信息
 		if mediaID, ok := msg.Metadata["media_id"]; ok {
-			// 企微的 media_id，需要下载后转换为 base64
-			// 这里先保存 media_id，后续需要下载
+			// 企微的 media_id，图片已由管道下载为 base64 放入 Content
 			if req.Metadata == nil {
 				req.Metadata = make(map[string]string)
 			}
@@ -101,11 +159,17 @@ func (c *Converter) ToAgentRequest(msg *Message) *AgentRequest {
 		// 检查是否有图片元数据
 		if imageKey, ok := msg.Metadata["image_key"]; ok && imageKey != "" {
 			// 飞书图片，Content 中应该已经有 base64
-			if strings.HasPrefix(msg.Content, "data:image/") || 
-			   (len(msg.Content) > 100 && !strings.HasPrefix(msg.Content, "http")) {
+			if strings.HasPrefix(msg.Content, "data:image/") ||
+				(len(msg.Content) > 100 && !strings.HasPrefix(msg.Content, "http")) {
 				req.ImageURLs = append(req.ImageURLs, msg.Content)
 			}
 		}
+		// 富文本中嵌入的图片
+		for _, image := range msg.Images {
+			if imageData, err := c.extractBase64Image(image); err == nil {
+				req.ImageURLs = append(req.ImageURLs, imageData)
+			}
+		}
 	}
 
 	return req
@@ -122,16 +186,15 @@ func (c *Converter) FromAgentResponse(resp *AgentResponse, originalMsg *Message)
 		Metadata:    resp.Metadata,
 	}
 
-	// 如果有图片，设置为图片消息
-	if len(resp.ImageURLs) > 0 {
-		msg.MessageType = "image"
-		// 将第一张图片作为 Content
-		msg.Content = resp.ImageURLs[0]
-		// 其他图片保存在 Metadata 中
-		if len(resp.ImageURLs) > 1 {
-			msg.Metadata["additional_images"] = strings.Join(resp.ImageURLs[1:], ",")
-		}
+	// 图片和文件作为附件，与文本分开发送
+	for _, imgURL := range resp.ImageURLs {
+		msg.Attachments = append(msg.Attachments, Attachment{
+			Type: MessageTypeImage,
+			URL:  imgURL,
+		})
 	}
+	msg.Attachments = append(msg.Attachments, resp.Files...)
+	msg.Suggestions = resp.Suggestions
 
 	return msg
 }
@@ -149,6 +212,19 @@ func (c *Converter) ToPlatformMessage(msg *Message) PlatformMessage {
 	}
 }
 
+// ToOutboundMessage 将统一消息格式的文本转换为出站消息，附件由调用方单独发送
+// 包含 Markdown 语法的内容作为 markdown 片段，由平台渲染为卡片等格式
+func (c *Converter) ToOutboundMessage(msg *Message) *OutboundMessage {
+	out := NewOutboundMessage(msg.SessionID)
+	if LooksLikeMarkdown(msg.Content) {
+		out.Parts = append(out.Parts, MarkdownPart(msg.Content))
+	} else if msg.Content != "" {
+		out.Parts = append(out.Parts, TextPart(msg.Content))
+	}
+	out.Suggestions = msg.Suggestions
+	return out
+}
+
 // PlatformMessageImpl 平台消息实现
 type PlatformMessageImpl struct {
 	platform    string
@@ -159,11 +235,11 @@ type PlatformMessageImpl struct {
 	metadata    map[string]string
 }
 
-func (p *PlatformMessageImpl) GetPlatform() string    { return p.platform }
-func (p *PlatformMessageImpl) GetSessionID() string   { return p.sessionID }
-func (p *PlatformMessageImpl) GetUserID() string      { return p.userID }
-func (p *PlatformMessageImpl) GetContent() string     { return p.content }
-func (p *PlatformMessageImpl) GetMessageType() string { return p.messageType }
+func (p *PlatformMessageImpl) GetPlatform() string            { return p.platform }
+func (p *PlatformMessageImpl) GetSessionID() string           { return p.sessionID }
+func (p *PlatformMessageImpl) GetUserID() string              { return p.userID }
+func (p *PlatformMessageImpl) GetContent() string             { return p.content }
+func (p *PlatformMessageImpl) GetMessageType() string         { return p.messageType }
 func (p *PlatformMessageImpl) GetMetadata() map[string]string { return p.metadata }
 
 // extractBase64Image 提取 base64 图片数据
@@ -200,9 +276,9 @@ func (c *Converter) NormalizeContent(msg *Message) {
 	// 处理图片内容
 	if msg.MessageType == "image" {
 		// 确保图片内容是有效的 base64 或 URL
-		if !strings.HasPrefix(msg.Content, "http") && 
-		   !strings.HasPrefix(msg.Content, "data:image/") &&
-		   len(msg.Content) > 100 {
+		if !strings.HasPrefix(msg.Content, "http") &&
+			!strings.HasPrefix(msg.Content, "data:image/") &&
+			len(msg.Content) > 100 {
 			// 可能是纯 base64，添加 data URI 前缀
 			msg.Content = "data:image/png;base64," + msg.Content
 		}
@@ -211,6 +287,11 @@ func (c *Converter) NormalizeContent(msg *Message) {
 
 // SplitLongText 分割长文本（用于平台限制）
 func (c *Converter) SplitLongText(text string, maxLen int) []string {
+	return SplitText(text, maxLen)
+}
+
+// SplitText 分割长文本（用于平台限制），优先在换行和句末标点处分割
+func SplitText(text string, maxLen int) []string {
 	if maxLen <= 0 {
 		maxLen = 2048 // 默认 2048 字符
 	}
@@ -228,22 +309,20 @@ func (c *Converter) SplitLongText(text string, maxLen int) []string {
 			end = len(text)
 		}
 
-			// 尝试在标点符号处分割
-			if end < len(text) {
-				// 向前查找合适的分割点
-				cutPos := end
-				for i := end; i > start+maxLen/2; i-- {
-					if i < len(text) {
-						char := rune(text[i-1])
-						if char == '\n' || char == '。' || char == '！' || 
-						   char == '？' || char == '.' || char == '!' || char == '?' {
-							cutPos = i
-							break
-						}
-					}
+		// 尝试在换行或句末标点处分割
+		if end < len(text) {
+			// 不在多字节字符中间截断
+			for end > start+1 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+			window := text[start:end]
+			if i := strings.LastIndexAny(window, "\n。！？.!?"); i >= 0 {
+				_, size := utf8.DecodeRuneInString(window[i:])
+				if cut := start + i + size; cut > start+maxLen/2 {
+					end = cut
 				}
-				end = cutPos
 			}
+		}
 
 		chunks = append(chunks, text[start:end])
 		start = end
@@ -267,9 +346,12 @@ func (c *Converter) BuildDifyRequest(req *AgentRequest, variables map[string]int
 	}
 
 	// 检查是否有有效的 conversation_id（UUID 格式）
-	// 优先从 Metadata 中获取之前保存的 conversation_id
-	var conversationID string
-	if req.Metadata != nil {
+	// 优先从会话存储中获取，其次是 Metadata 中之前保存的 conversation_id
+	conversationID := c.ConversationID(req)
+	if !isUUID(conversationID) {
+		conversationID = ""
+	}
+	if conversationID == "" && req.Metadata != nil {
 		if cid, ok := req.Metadata["conversation_id"]; ok && cid != "" {
 			// 验证 conversation_id 是否是有效的 UUID
 			// 如果之前错误地保存了非 UUID 格式的 ID（如飞书的 chat_id），则忽略并清除
@@ -281,7 +363,7 @@ func (c *Converter) BuildDifyRequest(req *AgentRequest, variables map[string]int
 			}
 		}
 	}
-	
+
 	// 如果没有从 Metadata 获取到有效的 UUID，尝试使用 SessionID（但必须是 UUID 格式）
 	// 注意：SessionID 通常是飞书的 chat_id（oc_xxx），不是 UUID，所以这里通常不会匹配
 	if conversationID == "" && isUUID(req.SessionID) {
@@ -309,16 +391,10 @@ func (c *Converter) BuildDifyRequest(req *AgentRequest, variables map[string]int
 	if len(req.ImageURLs) > 0 {
 		files := []map[string]interface{}{}
 		for _, imgURL := range req.ImageURLs {
-			// 如果是 base64，需要先上传
-			if strings.HasPrefix(imgURL, "data:image/") || 
-			   (len(imgURL) > 100 && !strings.HasPrefix(imgURL, "http")) {
-				// base64 图片，需要上传后获取 file_id
-				// 这里先保存，由 Agent 客户端处理上传
-				files = append(files, map[string]interface{}{
-					"type":            "image",
-					"transfer_method": "local_file",
-					"base64_data":     imgURL,
-				})
+			if strings.HasPrefix(imgURL, "data:image/") ||
+				(len(imgURL) > 100 && !strings.HasPrefix(imgURL, "http")) {
+				// base64 图片需通过 /files/upload 上传后以 upload_file_id 引用，由 Dify Agent 处理
+				continue
 			} else {
 				// URL 图片
 				files = append(files, map[string]interface{}{
@@ -345,18 +421,19 @@ func (c *Converter) BuildCozeRequest(req *AgentRequest, botID string) map[string
 		"auto_save_history": true,
 	}
 
-	if req.SessionID != "" {
-		payload["conversation_id"] = req.SessionID
+	// 从会话存储中获取之前的 Coze 会话，平台的 SessionID 不是 Coze 的会话 ID，不能直接使用
+	if cid := c.ConversationID(req); cid != "" {
+		payload["conversation_id"] = cid
 	}
 
 	// 构建消息列表
 	messages := []map[string]interface{}{}
 
-	// 处理多模态消息（文本 + 图片）
-	if len(req.ImageURLs) > 0 {
+	// 处理多模态消息（文本 + 图片 / 文件）
+	if len(req.ImageURLs) > 0 || len(req.Files) > 0 {
 		// 构建 object_string 格式
 		content := []map[string]interface{}{}
-		
+
 		// 添加文本
 		if req.Query != "" {
 			content = append(content, map[string]interface{}{
@@ -365,37 +442,50 @@ func (c *Converter) BuildCozeRequest(req *AgentRequest, botID string) map[string
 			})
 		}
 
-		// 添加图片（base64 需要先上传）
+		// 添加已上传的文件
+		for _, f := range req.Files {
+			content = append(content, map[string]interface{}{
+				"type":    f.Type,
+				"file_id": f.FileID,
+			})
+		}
+
+		// 添加图片
 		for _, imgURL := range req.ImageURLs {
-			if strings.HasPrefix(imgURL, "data:image/") || 
-			   (len(imgURL) > 100 && !strings.HasPrefix(imgURL, "http")) {
-				// base64 图片，标记需要上传
-				content = append(content, map[string]interface{}{
-					"type":      "image",
-					"base64":    imgURL,
-					"need_upload": true,
-				})
+			if strings.HasPrefix(imgURL, "data:image/") ||
+				(len(imgURL) > 100 && !strings.HasPrefix(imgURL, "http")) {
+				// base64 图片需通过 /v1/files/upload 上传后以 file_id 引用，由 Coze Agent 处理并放入 Files
+				continue
 			} else {
 				// URL 图片
 				content = append(content, map[string]interface{}{
-					"type": "image",
-					"url":  imgURL,
+					"type":     "image",
+					"file_url": imgURL,
 				})
 			}
 		}
 
-		// 转换为 JSON 字符串
-		contentJSON, _ := json.Marshal(content)
-		messages = append(messages, map[string]interface{}{
-			"role":        "user",
-			"content":     string(contentJSON),
-			"content_type": "object_string",
-		})
+		// 图片都未能上传时按纯文本发送
+		if len(content) == 1 && req.Query != "" {
+			messages = append(messages, map[string]interface{}{
+				"role":         "user",
+				"content":      req.Query,
+				"content_type": "text",
+			})
+		} else if len(content) > 0 {
+			// 转换为 JSON 字符串
+			contentJSON, _ := json.Marshal(content)
+			messages = append(messages, map[string]interface{}{
+				"role":         "user",
+				"content":      string(contentJSON),
+				"content_type": "object_string",
+			})
+		}
 	} else if req.Query != "" {
 		// 纯文本消息
 		messages = append(messages, map[string]interface{}{
-			"role":        "user",
-			"content":     req.Query,
+			"role":         "user",
+			"content":      req.Query,
 			"content_type": "text",
 		})
 	}
@@ -413,9 +503,9 @@ func (c *Converter) BuildCozeRequest(req *AgentRequest, botID string) map[string
 // ParseDifyResponse 解析 Dify 响应
 func (c *Converter) ParseDifyResponse(data map[string]interface{}) *AgentResponse {
 	resp := &AgentResponse{
-		Content:  "",
+		Content:   "",
 		ImageURLs: []string{},
-		Metadata: make(map[string]string),
+		Metadata:  make(map[string]string),
 	}
 
 	// 提取文本内容
@@ -529,6 +619,15 @@ func (c *Converter) FormatForWeCom(msg *Message) map[string]interface{} {
 		},
 	}
 
+	// Markdown 内容转换为企微支持的 Markdown 子集
+	if msg.MessageType == "text" && LooksLikeMarkdown(msg.Content) {
+		result["msgtype"] = "markdown"
+		delete(result, "text")
+		result["markdown"] = map[string]interface{}{
+			"content": MarkdownToWeCom(msg.Content),
+		}
+	}
+
 	// 处理图片消息
 	if msg.MessageType == "image" {
 		if mediaID, ok := msg.Metadata["media_id"]; ok {
@@ -588,4 +687,3 @@ func (c *Converter) MergeMessages(messages []*Message) *Message {
 
 	return merged
 }
-
@@ -0,0 +1,257 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 飞书消息类型
+const (
+	LarkMsgTypePost        = "post"
+	LarkMsgTypeInteractive = "interactive"
+)
+
+// MaxLarkCardSize 卡片 JSON 的大小上限（飞书限制 30KB，预留余量），超出时改用富文本发送
+const MaxLarkCardSize = 28 * 1024
+
+var (
+	mdFenceRe     = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#.-]*)\\s*$")
+	mdHeadingRe   = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdHrRe        = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	mdTableSepRe  = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+	mdBulletRe    = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	mdImageRe     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	mdSyntaxRe    = regexp.MustCompile("(?m)^\\s{0,3}#{1,6}\\s|^\\s*(```|~~~)|^\\s*([-*+]|\\d+\\.)\\s+\\S|^\\s*\\|.*\\|\\s*$|\\*\\*[^*]+\\*\\*|\\[[^\\]]+\\]\\([^)]+\\)|`[^`]+`")
+	mdTableCellRe = regexp.MustCompile(`\\\|`)
+)
+
+// LarkCard 飞书交互卡片（卡片 JSON 1.0）
+type LarkCard struct {
+	Config   LarkCardConfig           `json:"config"`
+	Elements []map[string]interface{} `json:"elements"`
+}
+
+// LarkCardConfig 卡片配置
+type LarkCardConfig struct {
+	WideScreenMode bool `json:"wide_screen_mode"`
+	UpdateMulti    bool `json:"update_multi,omitempty"` // 为 true 时卡片发送后允许更新
+}
+
+// LooksLikeMarkdown 判断文本是否包含 Markdown 语法（标题、列表、代码、表格、加粗、链接等）
+func LooksLikeMarkdown(text string) bool {
+	return mdSyntaxRe.MatchString(text)
+}
+
+// RenderLarkMarkdown 将 Markdown 渲染为飞书消息，返回消息类型及 content
+// 优先使用交互卡片，卡片超出大小限制时改用富文本（post）的 md 标签
+func RenderLarkMarkdown(md string, mentions []Mention) (string, string, error) {
+	card := MarkdownToLarkCard(md)
+	if len(mentions) > 0 {
+		var at strings.Builder
+		for _, m := range mentions {
+			fmt.Fprintf(&at, "<at id=%s></at> ", m.UserID)
+		}
+		card.Elements = append([]map[string]interface{}{
+			{"tag": "markdown", "content": strings.TrimSpace(at.String())},
+		}, card.Elements...)
+	}
+
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal card: %w", err)
+	}
+	if len(data) <= MaxLarkCardSize {
+		return LarkMsgTypeInteractive, string(data), nil
+	}
+
+	content, err := json.Marshal(MarkdownToLarkPost(md, mentions))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal post: %w", err)
+	}
+	return LarkMsgTypePost, string(content), nil
+}
+
+// MarkdownToLarkPost 将 Markdown 放入富文本的 md 标签，mentions 中的用户以 at 标签放在段首
+func MarkdownToLarkPost(md string, mentions []Mention) map[string]interface{} {
+	line := make([]map[string]interface{}, 0, len(mentions)+1)
+	for _, m := range mentions {
+		line = append(line, map[string]interface{}{
+			"tag":     "at",
+			"user_id": m.UserID,
+		})
+	}
+	line = append(line, map[string]interface{}{
+		"tag":  "md",
+		"text": md,
+	})
+
+	return map[string]interface{}{
+		"zh_cn": map[string]interface{}{
+			"title":   "",
+			"content": [][]map[string]interface{}{line},
+		},
+	}
+}
+
+// MarkdownToLarkCard 将 Markdown 转换为飞书交互卡片
+// 卡片的 markdown 组件不支持标题和表格：标题转为加粗文本，表格转为 table 组件，分隔线转为 hr 组件；
+// 卡片图片只能引用飞书的 image_key，Markdown 图片转为链接
+func MarkdownToLarkCard(md string) *LarkCard {
+	card := &LarkCard{
+		Config:   LarkCardConfig{WideScreenMode: true},
+		Elements: []map[string]interface{}{},
+	}
+
+	var para []string
+	flush := func() {
+		text := strings.Trim(strings.Join(para, "\n"), "\n")
+		para = para[:0]
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		card.Elements = append(card.Elements, markdownElement(text))
+	}
+
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// 代码块原样保留，飞书 markdown 组件支持代码块
+		if m := mdFenceRe.FindStringSubmatch(line); m != nil {
+			flush()
+			fence, lang := m[1], m[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == fence {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			// 未闭合的代码块（如流式输出中途）延续到文本末尾，去掉末尾空行
+			for i >= len(lines) && len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			card.Elements = append(card.Elements, markdownElement(
+				"```"+lang+"\n"+strings.Join(code, "\n")+"\n```",
+			))
+			continue
+		}
+
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			flush()
+			// 标题整体加粗，去掉标题内已有的加粗标记避免嵌套
+			if title := strings.TrimSpace(strings.ReplaceAll(m[2], "**", "")); title != "" {
+				card.Elements = append(card.Elements, markdownElement("**"+convertInline(title)+"**"))
+			}
+			continue
+		}
+
+		// 表格需要表头行和分隔行
+		if strings.Contains(line, "|") && i+1 < len(lines) && mdTableSepRe.MatchString(lines[i+1]) {
+			flush()
+			header := splitTableRow(line)
+			var rows [][]string
+			for i += 2; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == "" || !strings.Contains(lines[i], "|") {
+					break
+				}
+				rows = append(rows, splitTableRow(lines[i]))
+			}
+			i--
+			card.Elements = append(card.Elements, tableElement(header, rows))
+			continue
+		}
+
+		if mdHrRe.MatchString(line) {
+			flush()
+			card.Elements = append(card.Elements, map[string]interface{}{"tag": "hr"})
+			continue
+		}
+
+		// 飞书只识别 "-" 开头的无序列表
+		line = mdBulletRe.ReplaceAllString(line, "$1- ")
+		para = append(para, convertInline(line))
+	}
+	flush()
+
+	return card
+}
+
+// markdownElement 构建 markdown 组件
+func markdownElement(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"tag":     "markdown",
+		"content": content,
+	}
+}
+
+// tableElement 构建 table 组件，单元格按 lark_md 渲染以保留加粗、链接等格式
+func tableElement(header []string, rows [][]string) map[string]interface{} {
+	columns := make([]map[string]interface{}, 0, len(header))
+	for i, h := range header {
+		columns = append(columns, map[string]interface{}{
+			"name":         fmt.Sprintf("c%d", i),
+			"display_name": h,
+			"data_type":    "lark_md",
+		})
+	}
+
+	data := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		r := make(map[string]interface{}, len(header))
+		for i := range header {
+			cell := ""
+			if i < len(row) {
+				cell = convertInline(row[i])
+			}
+			r[fmt.Sprintf("c%d", i)] = cell
+		}
+		data = append(data, r)
+	}
+
+	pageSize := len(rows)
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	if pageSize > 10 {
+		pageSize = 10
+	}
+
+	return map[string]interface{}{
+		"tag":          "table",
+		"page_size":    pageSize,
+		"row_height":   "low",
+		"header_style": map[string]interface{}{"bold": true},
+		"columns":      columns,
+		"rows":         data,
+	}
+}
+
+// splitTableRow 拆分表格行，支持 \| 转义
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+
+	const placeholder = "\x00"
+	line = mdTableCellRe.ReplaceAllString(line, placeholder)
+	cells := strings.Split(line, "|")
+	for i, c := range cells {
+		cells[i] = strings.TrimSpace(strings.ReplaceAll(c, placeholder, "|"))
+	}
+	return cells
+}
+
+// convertInline 转换行内语法：图片转为链接，其余（加粗、斜体、删除线、行内代码、链接）飞书直接支持
+func convertInline(text string) string {
+	return mdImageRe.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdImageRe.FindStringSubmatch(s)
+		alt := m[1]
+		if alt == "" {
+			alt = "图片"
+		}
+		return fmt.Sprintf("[🖼 %s](%s)", alt, m[2])
+	})
+}
@@ -0,0 +1,109 @@
+package message
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestMarkdownToLarkCardGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "larkcard", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no golden inputs found")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".md")
+		t.Run(name, func(t *testing.T) {
+			md, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(MarkdownToLarkCard(string(md)), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".md") + ".golden.json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create): %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("card mismatch for %s\n--- got ---\n%s\n--- want ---\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestRenderLarkMarkdown(t *testing.T) {
+	mentions := []Mention{{UserID: "ou_123"}}
+
+	msgType, content, err := RenderLarkMarkdown("**hi**", mentions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != LarkMsgTypeInteractive {
+		t.Fatalf("msgType = %q, want %q", msgType, LarkMsgTypeInteractive)
+	}
+	var card LarkCard
+	if err := json.Unmarshal([]byte(content), &card); err != nil {
+		t.Fatal(err)
+	}
+	if len(card.Elements) != 2 || card.Elements[0]["content"] != "<at id=ou_123></at>" {
+		t.Errorf("card missing leading mention element: %s", content)
+	}
+
+	// 超出卡片大小限制时改用富文本
+	long := strings.Repeat("**word** ", MaxLarkCardSize/5)
+	msgType, content, err = RenderLarkMarkdown(long, mentions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != LarkMsgTypePost {
+		t.Fatalf("msgType = %q, want %q", msgType, LarkMsgTypePost)
+	}
+	if !strings.Contains(content, `"tag":"md"`) || !strings.Contains(content, `"user_id":"ou_123"`) {
+		t.Errorf("post content missing md or at tag: %.200s", content)
+	}
+}
+
+func TestLooksLikeMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"你好，今天天气不错。", false},
+		{"价格是 3*4=12", false},
+		{"# 标题", true},
+		{"- 第一项\n- 第二项", true},
+		{"1. 第一步", true},
+		{"这是 **重点**", true},
+		{"见 [文档](https://example.com)", true},
+		{"```\ncode\n```", true},
+		{"| a | b |\n|---|---|", true},
+		{"运行 `go test`", true},
+	}
+	for _, tt := range tests {
+		if got := LooksLikeMarkdown(tt.text); got != tt.want {
+			t.Errorf("LooksLikeMarkdown(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
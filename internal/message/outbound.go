@@ -0,0 +1,116 @@
+package message
+
+import "encoding/json"
+
+// PartType 出站消息片段类型
+type PartType string
+
+const (
+	PartText     PartType = "text"     // 纯文本
+	PartMarkdown PartType = "markdown" // Markdown 文本
+	PartImage    PartType = "image"    // 图片（Data 或 URL）
+	PartFile     PartType = "file"     // 文件（Data 或 URL）
+	PartCard     PartType = "card"     // 平台原生卡片（JSON）
+)
+
+// Part 出站消息片段，各平台适配器按类型以原生消息格式渲染
+type Part struct {
+	Type PartType        `json:"type"`
+	Text string          `json:"text,omitempty"` // text、markdown 的内容
+	Data []byte          `json:"data,omitempty"` // image、file 的内容，为空时从 URL 下载
+	URL  string          `json:"url,omitempty"`  // image、file 的下载地址
+	Name string          `json:"name,omitempty"` // file 的文件名
+	Card json.RawMessage `json:"card,omitempty"` // card 的平台卡片 JSON
+}
+
+// MetadataReplyFormat 出站消息元数据中指定回复格式的键，由路由规则设置，覆盖平台配置
+const MetadataReplyFormat = "reply_format"
+
+// 出站消息元数据中的会话信息，由管道按入站消息设置，平台据此确定接收者类型及回复时 @ 的用户
+const (
+	MetadataChatID   = "chat_id"
+	MetadataChatType = "chat_type"
+	MetadataSenderID = "sender_id"
+)
+
+// 回复格式
+const (
+	ReplyFormatAuto     = "auto"     // 按内容选择
+	ReplyFormatText     = "text"     // 纯文本
+	ReplyFormatMarkdown = "markdown" // 平台 Markdown
+	ReplyFormatCard     = "card"     // 平台卡片
+)
+
+// Mention 需要 @ 的用户
+type Mention struct {
+	UserID string `json:"user_id"`        // 平台用户 ID（飞书 open_id、企微 userid）
+	Name   string `json:"name,omitempty"` // 展示名称
+}
+
+// OutboundMessage 发送到平台的消息，由若干片段组成
+type OutboundMessage struct {
+	SessionID string    `json:"session_id"`
+	Parts     []Part    `json:"parts"`
+	Mentions  []Mention `json:"mentions,omitempty"` // 在第一个文本片段前 @ 的用户
+	ReplyTo   string    `json:"reply_to,omitempty"` // 回复的平台消息 ID，为空时直接发送到会话
+	// Suggestions 推荐的后续问题，支持的平台以按钮等形式展示
+	Suggestions []string          `json:"suggestions,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	// IdempotencyKey 幂等键，由入站消息 ID 派生，平台以"幂等键+片段序号"去重，重试时不会重复发送已送达的片段
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Attempt 第几次投递（从 1 开始），由投递层设置
+	Attempt int `json:"attempt,omitempty"`
+}
+
+// NewOutboundMessage 创建出站消息
+func NewOutboundMessage(sessionID string, parts ...Part) *OutboundMessage {
+	return &OutboundMessage{
+		SessionID: sessionID,
+		Parts:     parts,
+		Metadata:  make(map[string]string),
+	}
+}
+
+// TextPart 创建文本片段
+func TextPart(text string) Part {
+	return Part{Type: PartText, Text: text}
+}
+
+// MarkdownPart 创建 Markdown 片段
+func MarkdownPart(text string) Part {
+	return Part{Type: PartMarkdown, Text: text}
+}
+
+// ImagePart 创建图片片段
+func ImagePart(data []byte) Part {
+	return Part{Type: PartImage, Data: data}
+}
+
+// ImageURLPart 创建以 URL 引用的图片片段
+func ImageURLPart(url string) Part {
+	return Part{Type: PartImage, URL: url}
+}
+
+// FilePart 创建文件片段
+func FilePart(name string, data []byte) Part {
+	return Part{Type: PartFile, Name: name, Data: data}
+}
+
+// FileURLPart 创建以 URL 引用的文件片段
+func FileURLPart(name, url string) Part {
+	return Part{Type: PartFile, Name: name, URL: url}
+}
+
+// CardPart 创建卡片片段
+func CardPart(card json.RawMessage) Part {
+	return Part{Type: PartCard, Card: card}
+}
+
+// AttachmentPart 将附件转换为图片或文件片段
+func AttachmentPart(att Attachment) Part {
+	if att.Type == MessageTypeImage {
+		return ImageURLPart(att.URL)
+	}
+	return FileURLPart(att.Name, att.URL)
+}
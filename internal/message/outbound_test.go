@@ -0,0 +1,103 @@
+package message
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPartConstructors(t *testing.T) {
+	card := json.RawMessage(`{"elements":[]}`)
+	tests := []struct {
+		name string
+		got  Part
+		want Part
+	}{
+		{"text", TextPart("hi"), Part{Type: PartText, Text: "hi"}},
+		{"markdown", MarkdownPart("**hi**"), Part{Type: PartMarkdown, Text: "**hi**"}},
+		{"image", ImagePart([]byte("png")), Part{Type: PartImage, Data: []byte("png")}},
+		{"image url", ImageURLPart("http://x/a.png"), Part{Type: PartImage, URL: "http://x/a.png"}},
+		{"file", FilePart("a.pdf", []byte("pdf")), Part{Type: PartFile, Name: "a.pdf", Data: []byte("pdf")}},
+		{"file url", FileURLPart("a.pdf", "http://x/a"), Part{Type: PartFile, Name: "a.pdf", URL: "http://x/a"}},
+		{"card", CardPart(card), Part{Type: PartCard, Card: card}},
+		{"image attachment", AttachmentPart(Attachment{Type: MessageTypeImage, URL: "http://x/a.png", Name: "a.png"}),
+			Part{Type: PartImage, URL: "http://x/a.png"}},
+		{"file attachment", AttachmentPart(Attachment{Type: MessageTypeFile, URL: "http://x/a", Name: "a.pdf"}),
+			Part{Type: PartFile, Name: "a.pdf", URL: "http://x/a"}},
+		// 未知类型的附件按文件发送
+		{"unknown attachment", AttachmentPart(Attachment{Type: "video", URL: "http://x/v"}), Part{Type: PartFile, URL: "http://x/v"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestOutboundMessageJSON(t *testing.T) {
+	out := NewOutboundMessage("s1", TextPart("hi"), ImagePart([]byte{0xff}))
+	if out.Metadata == nil {
+		t.Fatal("metadata not initialized")
+	}
+	out.Metadata[MetadataChatID] = "oc_1"
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got OutboundMessage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, out) {
+		t.Errorf("round trip = %+v, want %+v", got, *out)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		maxLen int
+		want   []string
+	}{
+		{"short", "hello", 10, []string{"hello"}},
+		{"exact", "hello", 5, []string{"hello"}},
+		{"newline", "aaaa\nbbbb\ncc", 8, []string{"aaaa\n", "bbbb\ncc"}},
+		{"sentence", "一二三。四五六", 12, []string{"一二三。", "四五六"}},
+		// 分割点太靠前时按长度截断，避免产生过多小片段
+		{"early break", "a\nbcdefghij", 8, []string{"a\nbcdefg", "hij"}},
+		{"no break", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	}
+	for _, tt := range tests {
+		if got := SplitText(tt.text, tt.maxLen); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: SplitText = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTextKeepsRunesAndContent(t *testing.T) {
+	text := strings.Repeat("中文内容没有标点", 100)
+	chunks := SplitText(text, 100)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 100 {
+			t.Errorf("chunk %d has %d bytes, want <= 100", i, len(chunk))
+		}
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %d splits a rune: %q", i, chunk)
+		}
+	}
+	if strings.Join(chunks, "") != text {
+		t.Error("chunks do not join back to the original text")
+	}
+
+	// maxLen 不大于 0 时使用默认长度
+	if got := SplitText(strings.Repeat("a", 3000), 0); len(got) != 2 || len(got[0]) != 2048 {
+		t.Errorf("default split = %d chunks", len(got))
+	}
+}
@@ -2,57 +2,286 @@ package message
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xia_adpter/internal/config"
 )
 
 // Message 统一消息结构
 type Message struct {
-	Platform    string            `json:"platform"`     // lark, wecom
-	SessionID   string            `json:"session_id"`   // 会话ID
-	UserID      string            `json:"user_id"`     // 用户ID
-	Content     string            `json:"content"`      // 消息内容（文本或 base64 图片）
-	MessageType string            `json:"message_type"` // text, image, voice, file
-	Metadata    map[string]string `json:"metadata"`    // 平台特定元数据
-	Timestamp   int64             `json:"timestamp,omitempty"` // 时间戳
+	ID          string            `json:"id,omitempty"`          // 队列内部 ID，由队列后端分配
+	Platform    string            `json:"platform"`              // lark, wecom
+	SessionID   string            `json:"session_id"`            // 会话ID
+	UserID      string            `json:"user_id"`               // 用户ID
+	Content     string            `json:"content"`               // 消息内容（文本或 base64 图片）
+	MessageType string            `json:"message_type"`          // text, image, voice, file
+	Metadata    map[string]string `json:"metadata"`              // 平台特定元数据
+	Timestamp   int64             `json:"timestamp,omitempty"`   // 时间戳
+	Attachments []Attachment      `json:"attachments,omitempty"` // 附件（Agent 生成的图片、文件）
+	Suggestions []string          `json:"suggestions,omitempty"` // Agent 推荐的后续问题
+	Images      []string          `json:"images,omitempty"`      // 文本消息中嵌入的图片（base64），如飞书富文本中的图片
+}
+
+// ErrQueueFull 队列已满且等待超时
+var ErrQueueFull = errors.New("message queue is full")
+
+// ErrQueueClosed 队列已关闭
+var ErrQueueClosed = errors.New("message queue is closed")
+
+const (
+	// defaultPushTimeout 队列满时 Push 默认等待时间
+	defaultPushTimeout = 3 * time.Second
+	// defaultDeadLetterSize 默认保留的死信数量
+	defaultDeadLetterSize = 100
+)
+
+// QueueBackend 队列存储后端
+type QueueBackend interface {
+	// Enqueue 入队，队列满时阻塞直到有空间或 ctx 结束
+	Enqueue(ctx context.Context, msg *Message) error
+	// Dequeue 出队，队列为空时阻塞直到有消息或 ctx 结束
+	Dequeue(ctx context.Context) (*Message, error)
+	// TryDequeue 非阻塞出队
+	TryDequeue() (*Message, bool)
+	// Ack 确认消息已处理完成，持久化后端据此删除消息
+	Ack(msg *Message) error
+	// Len 队列中等待处理的消息数
+	Len() int
+	// Close 关闭后端
+	Close() error
 }
 
-// Queue 消息队列
+// DeadLetter 无法入队或无法处理的消息
+type DeadLetter struct {
+	Message *Message  `json:"message"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+}
+
+// QueueStats 队列统计信息
+type QueueStats struct {
+	Length      int    `json:"length"`       // 等待处理的消息数
+	Pushed      uint64 `json:"pushed"`       // 成功入队的消息数
+	Popped      uint64 `json:"popped"`       // 已出队的消息数
+	Acked       uint64 `json:"acked"`        // 已确认处理完成的消息数
+	Overflow    uint64 `json:"overflow"`     // 因队列满被拒绝的消息数
+	DeadLetters int    `json:"dead_letters"` // 当前保留的死信数
+}
+
+// Queue 消息队列，封装存储后端并提供背压、溢出统计和死信列表
 type Queue struct {
-	ch chan *Message
+	backend     QueueBackend
+	pushTimeout time.Duration
+
+	pushed   atomic.Uint64
+	popped   atomic.Uint64
+	acked    atomic.Uint64
+	overflow atomic.Uint64
+
+	deadLetters    []DeadLetter
+	deadLetterSize int
+	mu             sync.Mutex
 }
 
-// NewQueue 创建新的消息队列
+// NewQueue 创建新的内存消息队列
 func NewQueue(size int) *Queue {
+	return NewQueueWithBackend(NewMemoryBackend(size), defaultPushTimeout, defaultDeadLetterSize)
+}
+
+// NewQueueWithBackend 使用指定后端创建消息队列
+// pushTimeout 为队列满时 Push 的最长等待时间，不大于 0 时不等待
+func NewQueueWithBackend(backend QueueBackend, pushTimeout time.Duration, deadLetterSize int) *Queue {
+	if deadLetterSize <= 0 {
+		deadLetterSize = defaultDeadLetterSize
+	}
 	return &Queue{
-		ch: make(chan *Message, size),
+		backend:        backend,
+		pushTimeout:    pushTimeout,
+		deadLetterSize: deadLetterSize,
 	}
 }
 
-// Push 推送消息到队列
-func (q *Queue) Push(msg *Message) {
-	select {
-	case q.ch <- msg:
+// NewQueueFromConfig 根据配置创建消息队列
+func NewQueueFromConfig(cfg config.QueueConfig) (*Queue, error) {
+	var backend QueueBackend
+	switch cfg.Backend {
+	case "", "memory":
+		backend = NewMemoryBackend(cfg.Size)
+	case "wal":
+		wal, err := NewWALBackend(cfg.WALPath, cfg.Size, cfg.WALSync)
+		if err != nil {
+			return nil, err
+		}
+		backend = wal
 	default:
-		// 队列满了，丢弃消息或记录日志
+		return nil, fmt.Errorf("unknown queue backend %q", cfg.Backend)
+	}
+
+	return NewQueueWithBackend(backend, time.Duration(cfg.PushTimeoutMs)*time.Millisecond, cfg.DeadLetterSize), nil
+}
+
+// Push 推送消息到队列
+// 队列满时最多等待 pushTimeout，仍无空间则记入死信列表并返回 ErrQueueFull
+func (q *Queue) Push(msg *Message) error {
+	// pushTimeout 不大于 0 时上下文立即过期，后端只做一次非阻塞尝试
+	ctx, cancel := context.WithTimeout(context.Background(), q.pushTimeout)
+	defer cancel()
+
+	if err := q.backend.Enqueue(ctx, msg); err != nil {
+		reason := err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			q.overflow.Add(1)
+			reason = ErrQueueFull.Error()
+			err = ErrQueueFull
+		}
+		q.addDeadLetter(msg, reason)
+		return err
 	}
+
+	q.pushed.Add(1)
+	return nil
 }
 
 // Pop 从队列弹出消息（阻塞）
 func (q *Queue) Pop(ctx context.Context) (*Message, error) {
+	msg, err := q.backend.Dequeue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q.popped.Add(1)
+	return msg, nil
+}
+
+// TryPop 尝试从队列弹出消息（非阻塞）
+func (q *Queue) TryPop() (*Message, bool) {
+	msg, ok := q.backend.TryDequeue()
+	if ok {
+		q.popped.Add(1)
+	}
+	return msg, ok
+}
+
+// Ack 确认消息已处理完成
+func (q *Queue) Ack(msg *Message) error {
+	if err := q.backend.Ack(msg); err != nil {
+		return err
+	}
+	q.acked.Add(1)
+	return nil
+}
+
+// Stats 返回队列统计信息
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	deadLetters := len(q.deadLetters)
+	q.mu.Unlock()
+
+	return QueueStats{
+		Length:      q.backend.Len(),
+		Pushed:      q.pushed.Load(),
+		Popped:      q.popped.Load(),
+		Acked:       q.acked.Load(),
+		Overflow:    q.overflow.Load(),
+		DeadLetters: deadLetters,
+	}
+}
+
+// Reject 将已出队但无法处理的消息记入死信列表
+// 消息不会被确认，持久化后端在重启后会重新处理
+func (q *Queue) Reject(msg *Message, reason string) {
+	q.addDeadLetter(msg, reason)
+}
+
+// DeadLetters 返回死信列表（按时间先后）
+func (q *Queue) DeadLetters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter(nil), q.deadLetters...)
+}
+
+// Close 关闭队列
+func (q *Queue) Close() error {
+	return q.backend.Close()
+}
+
+// addDeadLetter 记录死信，超出容量时丢弃最早的记录
+func (q *Queue) addDeadLetter(msg *Message, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.deadLetters = append(q.deadLetters, DeadLetter{
+		Message: msg,
+		Reason:  reason,
+		Time:    time.Now(),
+	})
+	if len(q.deadLetters) > q.deadLetterSize {
+		q.deadLetters = q.deadLetters[len(q.deadLetters)-q.deadLetterSize:]
+	}
+}
+
+// MemoryBackend 基于 channel 的内存队列后端，重启后消息丢失
+type MemoryBackend struct {
+	ch chan *Message
+}
+
+// NewMemoryBackend 创建内存队列后端
+func NewMemoryBackend(size int) *MemoryBackend {
+	return &MemoryBackend{
+		ch: make(chan *Message, size),
+	}
+}
+
+// Enqueue 入队
+func (b *MemoryBackend) Enqueue(ctx context.Context, msg *Message) error {
+	select {
+	case b.ch <- msg:
+		return nil
+	default:
+	}
+
+	select {
+	case b.ch <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dequeue 出队（阻塞）
+func (b *MemoryBackend) Dequeue(ctx context.Context) (*Message, error) {
 	select {
-	case msg := <-q.ch:
+	case msg := <-b.ch:
 		return msg, nil
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 }
 
-// TryPop 尝试从队列弹出消息（非阻塞）
-func (q *Queue) TryPop() (*Message, bool) {
+// TryDequeue 非阻塞出队
+func (b *MemoryBackend) TryDequeue() (*Message, bool) {
 	select {
-	case msg := <-q.ch:
+	case msg := <-b.ch:
 		return msg, true
 	default:
 		return nil, false
 	}
 }
 
+// Ack 内存后端无需确认
+func (b *MemoryBackend) Ack(msg *Message) error {
+	return nil
+}
+
+// Len 队列中等待处理的消息数
+func (b *MemoryBackend) Len() int {
+	return len(b.ch)
+}
+
+// Close 关闭后端
+func (b *MemoryBackend) Close() error {
+	return nil
+}
@@ -15,6 +15,22 @@ const (
 	PlatformWeCom = "wecom"
 )
 
+// Attachment 消息附件，Type 为 MessageTypeImage 或 MessageTypeFile
+type Attachment struct {
+	Type string `json:"type"`           // image, file
+	URL  string `json:"url"`            // 下载地址
+	Name string `json:"name,omitempty"` // 文件名
+}
+
+// MediaFile 从平台下载的消息媒体文件，只在处理过程中使用，不写入队列
+type MediaFile struct {
+	Type     string `json:"type"`             // image, voice, video, file
+	Name     string `json:"name,omitempty"`   // 文件名
+	MimeType string `json:"mime_type"`        // 按内容检测的类型
+	Format   string `json:"format,omitempty"` // 平台声明的格式（如语音的 amr、opus）
+	Data     []byte `json:"-"`
+}
+
 // NewTextMessage 创建文本消息
 func NewTextMessage(platform, sessionID, userID, content string) *Message {
 	return &Message{
@@ -56,7 +72,7 @@ func (m *Message) IsVoice() bool {
 
 // HasImage 检查是否包含图片
 func (m *Message) HasImage() bool {
-	return m.IsImage() || m.Metadata["image_key"] != "" || m.Metadata["media_id"] != ""
+	return m.IsImage() || len(m.Images) > 0 || m.Metadata["image_key"] != "" || m.Metadata["media_id"] != ""
 }
 
 // GetImageData 获取图片数据（base64 或 URL）
@@ -72,4 +88,3 @@ func (m *Message) GetImageData() string {
 	}
 	return ""
 }
-
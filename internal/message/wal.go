@@ -0,0 +1,376 @@
+package message
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// defaultWALPath WAL 文件默认路径
+	defaultWALPath = "data/queue.wal"
+	// walCompactThreshold 日志记录数超过该值且多数已确认时重写日志
+	walCompactThreshold = 1000
+)
+
+// walMaxRecordSize 单条记录的大小上限，超过上限的消息（通常带有很大的内嵌图片）不写入日志，
+// 重放时也跳过超过上限的行，避免一条记录导致服务无法启动
+var walMaxRecordSize = 64 * 1024 * 1024
+
+// walOp WAL 记录类型
+const (
+	walOpPush = "push"
+	walOpAck  = "ack"
+)
+
+// walRecord WAL 中的一条记录，每行一个 JSON
+type walRecord struct {
+	Op      string   `json:"op"`
+	ID      string   `json:"id"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// WALBackend 基于预写日志的持久化队列后端
+// 入队时追加 push 记录，Ack 时追加 ack 记录；重启后重放日志，
+// 未确认的消息（包括已出队但未处理完成的）重新进入队列
+type WALBackend struct {
+	path string
+	sync bool
+
+	file    *os.File
+	records int    // 日志中的记录数
+	seq     uint64 // 最近分配的消息 ID
+
+	pending  []*Message          // 等待出队的消息
+	inflight map[string]*Message // 已出队但未确认的消息
+	closed   bool
+	mu       sync.Mutex
+
+	// slots 空闲容量，items 可出队的消息数，用于阻塞入队/出队
+	slots chan struct{}
+	items chan struct{}
+}
+
+// NewWALBackend 创建 WAL 队列后端，并恢复日志中未确认的消息
+// syncWrites 为 true 时每次写入后执行 fsync
+func NewWALBackend(path string, size int, syncWrites bool) (*WALBackend, error) {
+	if path == "" {
+		path = defaultWALPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	b := &WALBackend{
+		path:     path,
+		sync:     syncWrites,
+		inflight: make(map[string]*Message),
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	// 恢复的消息超过容量时临时扩容，保证重放的消息都能入队
+	if size < len(b.pending) {
+		size = len(b.pending)
+	}
+	if size <= 0 {
+		size = 1
+	}
+	b.slots = make(chan struct{}, size)
+	b.items = make(chan struct{}, size)
+	for range b.pending {
+		b.slots <- struct{}{}
+		b.items <- struct{}{}
+	}
+
+	// 重写日志，去掉已确认的记录
+	if err := b.rewrite(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Enqueue 写入日志后入队，有空闲容量时即使 ctx 已过期也能入队
+func (b *WALBackend) Enqueue(ctx context.Context, msg *Message) error {
+	if err := b.acquireSlot(ctx); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		<-b.slots
+		return ErrQueueClosed
+	}
+
+	b.seq++
+	msg.ID = strconv.FormatUint(b.seq, 10)
+	if err := b.append(walRecord{Op: walOpPush, ID: msg.ID, Message: msg}); err != nil {
+		<-b.slots
+		return err
+	}
+
+	b.pending = append(b.pending, msg)
+	b.items <- struct{}{}
+	return nil
+}
+
+// acquireSlot 占用一个空闲容量，先做一次非阻塞尝试，再等待到 ctx 结束
+func (b *WALBackend) acquireSlot(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dequeue 出队（阻塞），消息在 Ack 之前仍保留在日志中
+func (b *WALBackend) Dequeue(ctx context.Context) (*Message, error) {
+	select {
+	case <-b.items:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return b.take(), nil
+}
+
+// TryDequeue 非阻塞出队
+func (b *WALBackend) TryDequeue() (*Message, bool) {
+	select {
+	case <-b.items:
+		return b.take(), true
+	default:
+		return nil, false
+	}
+}
+
+// take 取出队首消息并标记为处理中，调用方需已获取 items
+func (b *WALBackend) take() *Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg := b.pending[0]
+	b.pending[0] = nil
+	b.pending = b.pending[1:]
+	b.inflight[msg.ID] = msg
+	<-b.slots
+	return msg
+}
+
+// Ack 写入确认记录，消息不会在重启后再次处理
+func (b *WALBackend) Ack(msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if msg == nil || b.closed {
+		return nil
+	}
+	if _, ok := b.inflight[msg.ID]; !ok {
+		return nil
+	}
+	delete(b.inflight, msg.ID)
+
+	if err := b.append(walRecord{Op: walOpAck, ID: msg.ID}); err != nil {
+		return err
+	}
+
+	// 大部分记录已确认时重写日志，避免文件无限增长
+	live := len(b.pending) + len(b.inflight)
+	if b.records > walCompactThreshold && b.records > 2*live {
+		return b.rewrite()
+	}
+	return nil
+}
+
+// Len 队列中等待处理的消息数
+func (b *WALBackend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Close 关闭日志文件，未确认的消息在下次启动时恢复
+func (b *WALBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
+
+// load 重放日志，恢复未确认的消息
+func (b *WALBackend) load() error {
+	f, err := os.Open(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open queue wal: %w", err)
+	}
+	defer f.Close()
+
+	messages := make(map[string]*Message)
+	var order []string
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := readWALRecord(r)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read queue wal: %w", err)
+		}
+		var rec walRecord
+		// 忽略超过上限或无法解析的行（最后一条记录可能因进程中断写入不完整）
+		if len(line) > 0 && json.Unmarshal(line, &rec) == nil {
+			if id, err := strconv.ParseUint(rec.ID, 10, 64); err == nil && id > b.seq {
+				b.seq = id
+			}
+
+			switch rec.Op {
+			case walOpPush:
+				if rec.Message != nil {
+					rec.Message.ID = rec.ID
+					messages[rec.ID] = rec.Message
+					order = append(order, rec.ID)
+				}
+			case walOpAck:
+				delete(messages, rec.ID)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for _, id := range order {
+		if msg, ok := messages[id]; ok {
+			b.pending = append(b.pending, msg)
+		}
+	}
+	return nil
+}
+
+// readWALRecord 读取一行记录，超过 walMaxRecordSize 的行被丢弃并返回空记录
+func readWALRecord(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if len(line)+len(chunk) > walMaxRecordSize {
+				tooLarge = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return line, err
+	}
+}
+
+// append 追加一条记录，调用方需持有锁
+func (b *WALBackend) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wal record: %w", err)
+	}
+	if len(data) >= walMaxRecordSize {
+		return fmt.Errorf("wal record too large: %d bytes", len(data))
+	}
+	data = append(data, '\n')
+
+	if _, err := b.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write queue wal: %w", err)
+	}
+	if b.sync {
+		if err := b.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync queue wal: %w", err)
+		}
+	}
+	b.records++
+	return nil
+}
+
+// walSeq 返回消息 ID 对应的入队序号
+func walSeq(id string) uint64 {
+	seq, _ := strconv.ParseUint(id, 10, 64)
+	return seq
+}
+
+// rewrite 只保留未确认的消息重写日志（先写临时文件再重命名），调用方需持有锁
+func (b *WALBackend) rewrite() error {
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create queue wal: %w", err)
+	}
+
+	// 处理中的消息按入队顺序排在前面，重启后优先恢复，同一会话的消息保持原有顺序
+	live := make([]*Message, 0, len(b.inflight)+len(b.pending))
+	for _, msg := range b.inflight {
+		live = append(live, msg)
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return walSeq(live[i].ID) < walSeq(live[j].ID)
+	})
+	live = append(live, b.pending...)
+
+	w := bufio.NewWriter(f)
+	for _, msg := range live {
+		data, err := json.Marshal(walRecord{Op: walOpPush, ID: msg.ID, Message: msg})
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to marshal wal record: %w", err)
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write queue wal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync queue wal: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to replace queue wal: %w", err)
+	}
+
+	if b.file != nil {
+		b.file.Close()
+	}
+	// 继续使用同一文件句柄追加
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek queue wal: %w", err)
+	}
+	b.file = f
+	b.records = len(live)
+	return nil
+}
@@ -0,0 +1,177 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWALPushWithoutTimeoutUsesFreeSpace(t *testing.T) {
+	backend, err := NewWALBackend(filepath.Join(t.TempDir(), "queue.wal"), 50, false)
+	if err != nil {
+		t.Fatalf("NewWALBackend: %v", err)
+	}
+	defer backend.Close()
+
+	// push_timeout_ms 为 0 时上下文立即过期，有空闲容量的入队仍必须成功
+	q := NewQueueWithBackend(backend, 0, 10)
+	for i := 0; i < 50; i++ {
+		if err := q.Push(NewTextMessage("lark", "s1", "u1", "hi")); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if err := q.Push(NewTextMessage("lark", "s1", "u1", "hi")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("push to full queue: err = %v, want ErrQueueFull", err)
+	}
+	if stats := q.Stats(); stats.Overflow != 1 || stats.DeadLetters != 1 {
+		t.Errorf("stats = %+v, want 1 overflow and 1 dead letter", stats)
+	}
+}
+
+func TestWALReplaysUnackedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	backend, err := NewWALBackend(path, 10, true)
+	if err != nil {
+		t.Fatalf("NewWALBackend: %v", err)
+	}
+	q := NewQueueWithBackend(backend, 0, 10)
+	for _, content := range []string{"a", "b", "c"} {
+		if err := q.Push(NewTextMessage("lark", "s1", "u1", content)); err != nil {
+			t.Fatalf("push %s: %v", content, err)
+		}
+	}
+
+	// a 已确认，b 已出队未确认，c 未出队
+	ctx := context.Background()
+	first, _ := q.Pop(ctx)
+	if _, err := q.Pop(ctx); err != nil {
+		t.Fatalf("pop: %v", err)
+	}
+	if err := q.Ack(first); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	backend, err = NewWALBackend(path, 10, true)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer backend.Close()
+	if n := backend.Len(); n != 2 {
+		t.Fatalf("recovered %d messages, want 2", n)
+	}
+	var got []string
+	for {
+		msg, ok := backend.TryDequeue()
+		if !ok {
+			break
+		}
+		got = append(got, msg.Content)
+	}
+	if len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("recovered %v, want [b c]", got)
+	}
+}
+
+func TestWALRewriteKeepsInflightOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	backend, err := NewWALBackend(path, 20, false)
+	if err != nil {
+		t.Fatalf("NewWALBackend: %v", err)
+	}
+	ctx := context.Background()
+	var want []string
+	for i := 0; i < 12; i++ {
+		content := string(rune('a' + i))
+		want = append(want, content)
+		if err := backend.Enqueue(ctx, NewTextMessage("lark", "s1", "u1", content)); err != nil {
+			t.Fatalf("enqueue %s: %v", content, err)
+		}
+	}
+	// 前 8 条已出队未确认，重写后仍应按入队顺序排在未出队的消息之前
+	for i := 0; i < 8; i++ {
+		if _, ok := backend.TryDequeue(); !ok {
+			t.Fatalf("dequeue %d failed", i)
+		}
+	}
+	backend.mu.Lock()
+	err = backend.rewrite()
+	backend.mu.Unlock()
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	backend.Close()
+
+	backend, err = NewWALBackend(path, 20, false)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer backend.Close()
+	var got []string
+	for {
+		msg, ok := backend.TryDequeue()
+		if !ok {
+			break
+		}
+		got = append(got, msg.Content)
+	}
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("recovered %v, want %v", got, want)
+	}
+}
+
+func TestWALSkipsOversizedAndCorruptRecords(t *testing.T) {
+	defer func(size int) { walMaxRecordSize = size }(walMaxRecordSize)
+	walMaxRecordSize = 1024
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	backend, err := NewWALBackend(path, 10, false)
+	if err != nil {
+		t.Fatalf("NewWALBackend: %v", err)
+	}
+	q := NewQueueWithBackend(backend, 0, 10)
+	if err := q.Push(NewTextMessage("lark", "s1", "u1", "a")); err != nil {
+		t.Fatalf("push a: %v", err)
+	}
+	// 超过上限的消息不写入日志，记入死信
+	if err := q.Push(NewTextMessage("lark", "s1", "u1", strings.Repeat("x", 2048))); err == nil {
+		t.Fatal("oversized message enqueued")
+	}
+	if stats := q.Stats(); stats.DeadLetters != 1 {
+		t.Errorf("dead letters = %d, want 1", stats.DeadLetters)
+	}
+	q.Close()
+
+	// 旧版本写入的超长行和被截断的行在重放时跳过
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	fmt.Fprintf(f, `{"op":"push","id":"7","message":{"content":%q}}`+"\n", strings.Repeat("y", 8192))
+	fmt.Fprintf(f, `{"op":"push","id":"8","message":{"content":"c"}}`+"\n")
+	fmt.Fprint(f, `{"op":"push","id":"9","mess`)
+	f.Close()
+
+	backend, err = NewWALBackend(path, 10, false)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer backend.Close()
+	var got []string
+	for {
+		msg, ok := backend.TryDequeue()
+		if !ok {
+			break
+		}
+		got = append(got, msg.ID+":"+msg.Content)
+	}
+	if strings.Join(got, " ") != "1:a 8:c" {
+		t.Errorf("recovered %v, want [1:a 8:c]", got)
+	}
+}
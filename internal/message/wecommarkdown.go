@@ -0,0 +1,216 @@
+package message
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 企微消息长度限制
+const (
+	maxWeComCardTitleBytes = 128 // 文本卡片标题
+	maxWeComCardDescBytes  = 512 // 文本卡片描述
+	maxWeComButtons        = 6   // 模板卡片按钮数量
+	maxWeComButtonRunes    = 10  // 按钮文案长度（超出时客户端截断，这里主动截断并加省略号）
+	maxWeComButtonKeyBytes = 1024
+)
+
+var (
+	mdLinkRe        = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	mdBareURLRe     = regexp.MustCompile(`https?://[^\s)<>"]+`)
+	mdStrikeRe      = regexp.MustCompile(`~~([^~]+)~~`)
+	mdUnderBoldRe   = regexp.MustCompile(`__([^_]+)__`)
+	mdStarItalicRe  = regexp.MustCompile(`(^|[^*])\*([^*\s][^*]*?)\*([^*]|$)`)
+	mdUnderItalRe   = regexp.MustCompile(`(^|\W)_([^_\s][^_]*?)_(\W|$)`)
+	mdBoldRe        = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdHeadingMarkRe = regexp.MustCompile(`^#{1,6}\s+`)
+	mdQuoteMarkRe   = regexp.MustCompile(`^>\s?`)
+)
+
+// MarkdownToWeCom 将 Markdown 转换为企微应用消息支持的 Markdown 子集
+// 企微支持标题、加粗、链接、行内代码和引用：代码块转为引用，表格逐行转为"列名：值"，
+// 图片转为链接，斜体、删除线去掉标记，分隔线转为空行
+func MarkdownToWeCom(md string) string {
+	var out []string
+
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := mdFenceRe.FindStringSubmatch(line); m != nil {
+			fence := m[1]
+			for i++; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == fence {
+					break
+				}
+				out = append(out, strings.TrimRight("> "+lines[i], " "))
+			}
+			continue
+		}
+
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			if title := strings.TrimSpace(m[2]); title != "" {
+				out = append(out, m[1]+" "+convertWeComInline(title))
+			}
+			continue
+		}
+
+		if strings.Contains(line, "|") && i+1 < len(lines) && mdTableSepRe.MatchString(lines[i+1]) {
+			header := splitTableRow(line)
+			for i += 2; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == "" || !strings.Contains(lines[i], "|") {
+					break
+				}
+				row := splitTableRow(lines[i])
+				fields := make([]string, 0, len(header))
+				for j, h := range header {
+					cell := ""
+					if j < len(row) {
+						cell = row[j]
+					}
+					fields = append(fields, fmt.Sprintf("**%s**：%s", h, cell))
+				}
+				out = append(out, convertWeComInline("- "+strings.Join(fields, "，")))
+			}
+			i--
+			continue
+		}
+
+		if mdHrRe.MatchString(line) {
+			out = append(out, "")
+			continue
+		}
+
+		line = mdBulletRe.ReplaceAllString(line, "$1- ")
+		out = append(out, convertWeComInline(line))
+	}
+
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
+
+// convertWeComInline 转换企微不支持的行内语法，行内代码中的内容保持不变
+func convertWeComInline(text string) string {
+	segments := strings.Split(text, "`")
+	for i := 0; i < len(segments); i += 2 {
+		s := segments[i]
+		s = mdImageRe.ReplaceAllStringFunc(s, func(img string) string {
+			m := mdImageRe.FindStringSubmatch(img)
+			alt := m[1]
+			if alt == "" {
+				alt = "图片"
+			}
+			return fmt.Sprintf("[%s](%s)", alt, m[2])
+		})
+		s = mdStrikeRe.ReplaceAllString(s, "$1")
+		s = mdUnderBoldRe.ReplaceAllString(s, "**$1**")
+		s = mdStarItalicRe.ReplaceAllString(s, "$1$2$3")
+		s = mdUnderItalRe.ReplaceAllString(s, "$1$2$3")
+		segments[i] = s
+	}
+	return strings.Join(segments, "`")
+}
+
+// WeComTextCard 将包含链接的 Markdown 转换为企微文本卡片（textcard）
+// 第一行作为标题，其余内容转为纯文本作为描述，卡片跳转到第一个链接；
+// 没有链接或内容超出卡片长度限制时返回 false，由调用方改用其他格式
+func WeComTextCard(md string) (map[string]string, bool) {
+	url := ""
+	if m := mdLinkRe.FindStringSubmatch(md); m != nil {
+		url = m[2]
+	} else if m := mdBareURLRe.FindString(md); m != "" {
+		url = m
+	}
+	if url == "" {
+		return nil, false
+	}
+
+	var lines []string
+	for _, line := range strings.Split(markdownToPlain(md), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, false
+	}
+
+	title := lines[0]
+	desc := strings.Join(lines[1:], "\n")
+	if desc == "" {
+		desc = title
+	}
+	desc = html.EscapeString(desc)
+	if len(title) > maxWeComCardTitleBytes || len(desc) > maxWeComCardDescBytes {
+		return nil, false
+	}
+
+	return map[string]string{
+		"title":       title,
+		"description": desc,
+		"url":         url,
+		"btntxt":      "详情",
+	}, true
+}
+
+// WeComSuggestionCard 将推荐问题转换为按钮交互型模板卡片
+// 按钮的 key 为完整问题，用户点击后企微以 template_card_event 事件回调
+func WeComSuggestionCard(suggestions []string) map[string]interface{} {
+	buttons := make([]map[string]interface{}, 0, maxWeComButtons)
+	for _, s := range suggestions {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if len(buttons) == maxWeComButtons {
+			break
+		}
+		buttons = append(buttons, map[string]interface{}{
+			"text":  truncateRunes(s, maxWeComButtonRunes),
+			"style": 1,
+			"key":   truncateBytes(s, maxWeComButtonKeyBytes),
+		})
+	}
+
+	return map[string]interface{}{
+		"card_type":   "button_interaction",
+		"main_title":  map[string]string{"title": "猜你想问"},
+		"task_id":     fmt.Sprintf("suggest_%d", time.Now().UnixNano()),
+		"button_list": buttons,
+	}
+}
+
+// markdownToPlain 去掉 Markdown 标记，链接保留文字
+func markdownToPlain(md string) string {
+	lines := strings.Split(MarkdownToWeCom(md), "\n")
+	for i, line := range lines {
+		line = mdHeadingMarkRe.ReplaceAllString(line, "")
+		line = mdQuoteMarkRe.ReplaceAllString(line, "")
+		line = mdLinkRe.ReplaceAllString(line, "$1")
+		line = mdBoldRe.ReplaceAllString(line, "$1")
+		lines[i] = strings.ReplaceAll(line, "`", "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncateRunes 截断到 n 个字符，超出时以省略号结尾
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// truncateBytes 截断到不超过 n 字节，不拆分 UTF-8 字符
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
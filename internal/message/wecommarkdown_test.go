@@ -0,0 +1,62 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToWeCom(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{"heading", "## 标题 ##", "## 标题"},
+		{"bullets", "* 一\n+ 二", "- 一\n- 二"},
+		{"italic and strike", "*斜体* ~~删除~~ **加粗** __粗__", "斜体 删除 **加粗** **粗**"},
+		{"inline code kept", "运行 `a_b_*c*` 命令", "运行 `a_b_*c*` 命令"},
+		{"snake case kept", "字段 user_id_name", "字段 user_id_name"},
+		{"image", "![架构](https://example.com/a.png)", "[架构](https://example.com/a.png)"},
+		{"code block", "示例：\n```go\nfmt.Println(1)\n\n```", "示例：\n> fmt.Println(1)\n>"},
+		{"table", "| 名称 | 价格 |\n|---|---|\n| A | 1 |\n\n后文", "- **名称**：A，**价格**：1\n\n后文"},
+		{"hr", "上\n---\n下", "上\n\n下"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MarkdownToWeCom(tt.md); got != tt.want {
+				t.Errorf("MarkdownToWeCom(%q)\ngot:  %q\nwant: %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeComTextCard(t *testing.T) {
+	card, ok := WeComTextCard("# 发布通知\n新版本已发布，详见 [更新日志](https://example.com/changelog)")
+	if !ok {
+		t.Fatal("expected text card")
+	}
+	if card["title"] != "发布通知" || card["url"] != "https://example.com/changelog" {
+		t.Errorf("unexpected card: %v", card)
+	}
+	if card["description"] != "新版本已发布，详见 更新日志" {
+		t.Errorf("description = %q", card["description"])
+	}
+
+	if _, ok := WeComTextCard("没有链接的内容"); ok {
+		t.Error("expected no card without link")
+	}
+	if _, ok := WeComTextCard("标题\n" + strings.Repeat("很长的描述", 100) + " https://example.com"); ok {
+		t.Error("expected no card when description is too long")
+	}
+}
+
+func TestWeComSuggestionCard(t *testing.T) {
+	card := WeComSuggestionCard([]string{"如何申请年假？", "", "报销流程需要哪些材料和审批步骤？", "3", "4", "5", "6", "7"})
+	buttons := card["button_list"].([]map[string]interface{})
+	if len(buttons) != maxWeComButtons {
+		t.Fatalf("got %d buttons, want %d", len(buttons), maxWeComButtons)
+	}
+	if buttons[1]["text"] != "报销流程需要哪些材…" || buttons[1]["key"] != "报销流程需要哪些材料和审批步骤？" {
+		t.Errorf("unexpected button: %v", buttons[1])
+	}
+}
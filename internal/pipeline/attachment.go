@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// sendAttachments 将 Agent 生成的图片和文件逐个发送到平台，由适配器下载并以原生消息发送
+// 平台不支持对应消息类型或重试后仍失败时，改为发送链接；msg 为触发回复的入站消息
+func (p *Pipeline) sendAttachments(ctx context.Context, sender PlatformSender, msg *message.Message, attachments []message.Attachment) {
+	for i, att := range attachments {
+		out := withReplyContext(message.NewOutboundMessage(msg.SessionID, message.AttachmentPart(att)), msg)
+		out.IdempotencyKey = idempotencyKey(msg, fmt.Sprintf("att%d", i))
+		if _, err := p.deliver(ctx, sender, msg.Platform, out, 0); err != nil {
+			p.logger.Warn("Failed to send attachment, sending link instead",
+				zap.String("platform", msg.Platform),
+				zap.String("session_id", msg.SessionID),
+				zap.String("url", att.URL),
+				zap.Error(err),
+			)
+			link := withReplyContext(message.NewOutboundMessage(msg.SessionID, message.TextPart(att.URL)), msg)
+			link.IdempotencyKey = idempotencyKey(msg, fmt.Sprintf("link%d", i))
+			if err := p.send(ctx, sender, msg.Platform, link); err != nil {
+				p.logger.Error("Failed to send attachment link",
+					zap.String("platform", msg.Platform),
+					zap.String("session_id", msg.SessionID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultDeliveryAttempts 默认最多投递次数
+	defaultDeliveryAttempts = 4
+	// defaultDeliveryBackoff 默认第一次重试前的等待时间
+	defaultDeliveryBackoff = 500 * time.Millisecond
+	// defaultDeliveryMaxBackoff 默认重试等待时间上限
+	defaultDeliveryMaxBackoff = 10 * time.Second
+	// defaultDeliveryDeadLetters 默认保留的未送达回复数量
+	defaultDeliveryDeadLetters = 100
+)
+
+// ErrDeadLetterNotFound 死信不存在（已重发成功、被删除或因容量限制被丢弃）
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeliveryDeadLetter 重试后仍无法送达的回复
+type DeliveryDeadLetter struct {
+	ID       string                   `json:"id"`
+	Platform string                   `json:"platform"`
+	Message  *message.OutboundMessage `json:"message"`
+	Reason   string                   `json:"reason"`
+	Attempts int                      `json:"attempts"` // 已投递的次数
+	Time     time.Time                `json:"time"`     // 最后一次失败的时间
+}
+
+// deadLetterStore 未送达回复的内存存储，超出容量时丢弃最早的记录
+type deadLetterStore struct {
+	items []DeliveryDeadLetter
+	size  int
+	seq   uint64
+	mu    sync.Mutex
+}
+
+func newDeadLetterStore(size int) *deadLetterStore {
+	if size <= 0 {
+		size = defaultDeliveryDeadLetters
+	}
+	return &deadLetterStore{size: size}
+}
+
+// add 记录未送达回复的副本（调用方和重发时都会修改消息的 Attempt），返回分配的 ID
+func (s *deadLetterStore) add(platformName string, out *message.OutboundMessage, attempts int, err error) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	id := strconv.FormatUint(s.seq, 10)
+	s.items = append(s.items, DeliveryDeadLetter{
+		ID:       id,
+		Platform: platformName,
+		Message:  cloneOutbound(out),
+		Reason:   err.Error(),
+		Attempts: attempts,
+		Time:     time.Now(),
+	})
+	if len(s.items) > s.size {
+		s.items = s.items[len(s.items)-s.size:]
+	}
+	return id
+}
+
+// cloneOutbound 复制出站消息，元数据等引用类型的字段也复制一份
+func cloneOutbound(out *message.OutboundMessage) *message.OutboundMessage {
+	c := *out
+	c.Parts = append([]message.Part(nil), out.Parts...)
+	c.Mentions = append([]message.Mention(nil), out.Mentions...)
+	c.Suggestions = append([]string(nil), out.Suggestions...)
+	if out.Metadata != nil {
+		c.Metadata = make(map[string]string, len(out.Metadata))
+		for k, v := range out.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
+
+// list 按时间先后返回所有记录
+func (s *deadLetterStore) list() []DeliveryDeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DeliveryDeadLetter(nil), s.items...)
+}
+
+// get 按 ID 获取记录
+func (s *deadLetterStore) get(id string) (DeliveryDeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return DeliveryDeadLetter{}, false
+}
+
+// update 重发失败后更新投递次数和原因
+func (s *deadLetterStore) update(id string, attempts int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.items {
+		if s.items[i].ID == id {
+			s.items[i].Attempts = attempts
+			s.items[i].Reason = err.Error()
+			s.items[i].Time = time.Now()
+			return
+		}
+	}
+}
+
+// remove 删除记录，不存在时返回 false
+func (s *deadLetterStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, item := range s.items {
+		if item.ID == id {
+			s.items = append(s.items[:i], s.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// send 投递出站消息，失败时记入死信
+func (p *Pipeline) send(ctx context.Context, sender PlatformSender, platformName string, out *message.OutboundMessage) error {
+	attempts, err := p.deliver(ctx, sender, platformName, out, 0)
+	if err == nil {
+		return nil
+	}
+
+	id := p.deadLetters.add(platformName, out, attempts, err)
+	p.logger.Error("Failed to deliver message, moved to dead letters",
+		zap.String("platform", platformName),
+		zap.String("session_id", out.SessionID),
+		zap.String("dead_letter_id", id),
+		zap.Int("attempts", attempts),
+		zap.Error(err),
+	)
+	return err
+}
+
+// deliver 投递出站消息，可重试的错误按指数退避加随机抖动重试
+// previous 为之前已投递的次数（重发死信时），返回累计投递次数
+func (p *Pipeline) deliver(ctx context.Context, sender PlatformSender, platformName string, out *message.OutboundMessage, previous int) (int, error) {
+	return p.retry(ctx, platformName, out.SessionID, previous, func(attempt int) error {
+		out.Attempt = attempt
+		return sender.Send(ctx, out)
+	})
+}
+
+// retry 调用 fn 直到成功、遇到不可重试的错误或达到投递次数上限，重试前按指数退避加随机抖动等待
+// previous 为之前已尝试的次数，fn 的参数为本次是第几次尝试，返回累计尝试次数
+func (p *Pipeline) retry(ctx context.Context, platformName, sessionID string, previous int, fn func(attempt int) error) (int, error) {
+	cfg := p.cfg.Pipeline.Delivery
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultDeliveryAttempts
+	}
+	backoff := time.Duration(cfg.InitialBackoffMs) * time.Millisecond
+	if backoff <= 0 {
+		backoff = defaultDeliveryBackoff
+	}
+	maxBackoff := time.Duration(cfg.MaxBackoffMs) * time.Millisecond
+	if maxBackoff <= 0 {
+		maxBackoff = defaultDeliveryMaxBackoff
+	}
+
+	var err error
+	attempt := previous
+	for i := 0; i < maxAttempts; i++ {
+		attempt = previous + i + 1
+		if err = fn(attempt); err == nil {
+			return attempt, nil
+		}
+		if !platform.IsRetryable(err) || i == maxAttempts-1 {
+			break
+		}
+
+		wait := jitter(backoff)
+		p.logger.Warn("Failed to deliver message, retrying",
+			zap.String("platform", platformName),
+			zap.String("session_id", sessionID),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return attempt, err
+}
+
+// jitter 在 [d/2, d) 之间取随机等待时间，避免多个会话同时重试
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// DeadLetters 返回重试后仍无法送达的回复（按时间先后）
+func (p *Pipeline) DeadLetters() []DeliveryDeadLetter {
+	return p.deadLetters.list()
+}
+
+// ResendDeadLetter 重新投递指定的未送达回复，成功后从死信中删除
+func (p *Pipeline) ResendDeadLetter(ctx context.Context, id string) error {
+	item, ok := p.deadLetters.get(id)
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+	sender, ok := p.getSender(item.Platform)
+	if !ok {
+		return fmt.Errorf("no sender registered for platform %q", item.Platform)
+	}
+
+	// 在副本上投递，不修改死信中的消息
+	out := cloneOutbound(item.Message)
+	attempts, err := p.deliver(ctx, sender, item.Platform, out, item.Attempts)
+	if err != nil {
+		p.deadLetters.update(id, attempts, err)
+		return err
+	}
+	p.deadLetters.remove(id)
+	p.logger.Info("Dead letter resent",
+		zap.String("platform", item.Platform),
+		zap.String("session_id", item.Message.SessionID),
+		zap.String("dead_letter_id", id),
+	)
+	return nil
+}
+
+// DiscardDeadLetter 删除指定的未送达回复
+func (p *Pipeline) DiscardDeadLetter(id string) error {
+	if !p.deadLetters.remove(id) {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// idempotencyKey 由入站消息 ID 派生出站消息的幂等键，suffix 区分同一条入站消息的多条回复
+func idempotencyKey(msg *message.Message, suffix string) string {
+	id := msg.Metadata["message_id"]
+	if id == "" {
+		id = msg.Metadata["msg_id"]
+	}
+	if id == "" {
+		return ""
+	}
+	if suffix != "" {
+		id += "-" + suffix
+	}
+	return id
+}
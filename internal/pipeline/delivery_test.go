@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// flakySender 按顺序返回预设的错误，之后发送成功
+type flakySender struct {
+	errs     []error
+	attempts []int
+}
+
+func (s *flakySender) Send(ctx context.Context, msg *message.OutboundMessage) error {
+	s.attempts = append(s.attempts, msg.Attempt)
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func newTestPipeline() *Pipeline {
+	cfg := &config.Config{}
+	cfg.Pipeline.Delivery = config.DeliveryConfig{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 2}
+	return New(cfg, zap.NewNop())
+}
+
+func TestSendRetriesRetryableErrors(t *testing.T) {
+	p := newTestPipeline()
+	retryable := &platform.SendError{Code: 45009, Retryable: true}
+	sender := &flakySender{errs: []error{retryable, retryable}}
+
+	out := message.NewOutboundMessage("s1", message.TextPart("hi"))
+	if err := p.send(context.Background(), sender, "wecom", out); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(sender.attempts) != 3 || sender.attempts[2] != 3 {
+		t.Errorf("attempts = %v, want [1 2 3]", sender.attempts)
+	}
+	if len(p.DeadLetters()) != 0 {
+		t.Error("unexpected dead letter")
+	}
+}
+
+func TestSendParksFatalErrors(t *testing.T) {
+	p := newTestPipeline()
+	fatal := &platform.SendError{Code: 40003, Retryable: false}
+	sender := &flakySender{errs: []error{fatal}}
+	p.RegisterSender("wecom", sender)
+
+	out := message.NewOutboundMessage("s1", message.TextPart("hi"))
+	if err := p.send(context.Background(), sender, "wecom", out); !errors.Is(err, fatal) {
+		t.Fatalf("send error = %v, want %v", err, fatal)
+	}
+	if len(sender.attempts) != 1 {
+		t.Errorf("fatal error retried: attempts = %v", sender.attempts)
+	}
+
+	deadLetters := p.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 1 {
+		t.Fatalf("dead letters = %+v", deadLetters)
+	}
+
+	if err := p.ResendDeadLetter(context.Background(), deadLetters[0].ID); err != nil {
+		t.Fatalf("resend: %v", err)
+	}
+	if got := sender.attempts[len(sender.attempts)-1]; got != 2 {
+		t.Errorf("resend attempt = %d, want 2", got)
+	}
+	if len(p.DeadLetters()) != 0 {
+		t.Error("dead letter not removed after resend")
+	}
+	if err := p.ResendDeadLetter(context.Background(), deadLetters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("resend removed dead letter: err = %v", err)
+	}
+}
+
+func TestDeadLetterKeepsOwnCopy(t *testing.T) {
+	p := newTestPipeline()
+	fatal := &platform.SendError{Code: 40003, Retryable: false}
+	sender := &flakySender{errs: []error{fatal, fatal}}
+	p.RegisterSender("wecom", sender)
+
+	out := message.NewOutboundMessage("s1", message.TextPart("hi"))
+	out.Metadata["chat_id"] = "oc_1"
+	p.send(context.Background(), sender, "wecom", out)
+
+	// 调用方之后修改消息不影响死信
+	out.Attempt = 9
+	out.Metadata["chat_id"] = "changed"
+	item := p.DeadLetters()[0]
+	if item.Message.Attempt != 1 || item.Message.Metadata["chat_id"] != "oc_1" {
+		t.Errorf("dead letter changed with caller: %+v", item.Message)
+	}
+
+	// 读取死信的同时重发，重发在副本上修改投递次数（go test -race 检查）
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			for _, d := range p.DeadLetters() {
+				_ = d.Message.Attempt
+			}
+		}
+	}()
+	if err := p.ResendDeadLetter(context.Background(), item.ID); !errors.Is(err, fatal) {
+		t.Fatalf("resend error = %v, want %v", err, fatal)
+	}
+	<-done
+
+	item = p.DeadLetters()[0]
+	if item.Attempts != 2 || item.Message.Attempt != 1 {
+		t.Errorf("after resend: attempts = %d, message attempt = %d, want 2, 1", item.Attempts, item.Message.Attempt)
+	}
+}
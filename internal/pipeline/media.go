@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// defaultMaxMediaSize 默认传给 Agent 的媒体文件大小上限
+const defaultMaxMediaSize = 20 << 20
+
+// downloadMedia 下载图片、视频、文件消息中平台尚未提供内容的媒体文件
+// 图片以 data URI 写入消息内容（与飞书适配器直接下载图片的结果一致），其余文件返回后随请求交给 Agent；
+// 下载失败或超出大小限制时记录日志并跳过，Agent 仍会收到文本占位
+func (p *Pipeline) downloadMedia(ctx context.Context, msg *message.Message) []message.MediaFile {
+	switch msg.MessageType {
+	case message.MessageTypeImage, message.MessageTypeVideo, message.MessageTypeFile:
+	default:
+		return nil
+	}
+	if msg.Content != "" {
+		return nil
+	}
+
+	sender, ok := p.getSender(msg.Platform)
+	if !ok {
+		return nil
+	}
+	downloader, ok := sender.(platform.MediaDownloader)
+	if !ok {
+		return nil
+	}
+
+	media, err := downloader.DownloadMedia(ctx, msg)
+	if err != nil {
+		p.logger.Warn("Failed to download media",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+			zap.String("type", msg.MessageType),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	maxSize := p.cfg.Pipeline.MaxMediaSizeMB << 20
+	if maxSize <= 0 {
+		maxSize = defaultMaxMediaSize
+	}
+	if len(media.Data) > maxSize {
+		p.logger.Warn("Media too large, skipped",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+			zap.Int("size", len(media.Data)),
+			zap.Int("max_size", maxSize),
+		)
+		return nil
+	}
+
+	p.logger.Debug("Downloaded media",
+		zap.String("platform", msg.Platform),
+		zap.String("type", media.Type),
+		zap.String("mime_type", media.MimeType),
+		zap.Int("size", len(media.Data)),
+	)
+
+	if msg.MessageType == message.MessageTypeImage {
+		if !strings.HasPrefix(media.MimeType, "image/") {
+			p.logger.Warn("Downloaded image has unexpected content type",
+				zap.String("mime_type", media.MimeType),
+			)
+			return nil
+		}
+		msg.Content = fmt.Sprintf("data:%s;base64,%s", media.MimeType, base64.StdEncoding.EncodeToString(media.Data))
+		return nil
+	}
+	return []message.MediaFile{*media}
+}
+
+// mediaQuery 没有文本内容的视频、文件消息以占位文本作为查询
+func mediaQuery(msg *message.Message, media []message.MediaFile) string {
+	label := "[文件]"
+	if msg.MessageType == message.MessageTypeVideo {
+		label = "[视频]"
+	}
+	for _, m := range media {
+		if m.Name != "" {
+			return label + " " + m.Name
+		}
+	}
+	if name := msg.Metadata["file_name"]; name != "" {
+		return label + " " + name
+	}
+	return label
+}
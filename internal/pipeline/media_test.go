@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"xia_adpter/internal/message"
+)
+
+// mediaSender 返回预设媒体文件的发送器
+type mediaSender struct {
+	flakySender
+	media *message.MediaFile
+	err   error
+	calls int
+}
+
+func (s *mediaSender) DownloadMedia(ctx context.Context, msg *message.Message) (*message.MediaFile, error) {
+	s.calls++
+	return s.media, s.err
+}
+
+func TestDownloadMedia(t *testing.T) {
+	png := &message.MediaFile{Type: message.MessageTypeImage, MimeType: "image/png", Data: []byte("png")}
+	pdf := &message.MediaFile{Type: message.MessageTypeFile, Name: "a.pdf", MimeType: "application/pdf", Data: []byte("pdf")}
+	large := &message.MediaFile{Type: message.MessageTypeFile, Data: make([]byte, 1<<20+1)}
+
+	tests := []struct {
+		name      string
+		msgType   string
+		content   string
+		media     *message.MediaFile
+		err       error
+		wantCalls int
+		wantFiles int
+		wantText  string
+	}{
+		{name: "image", msgType: message.MessageTypeImage, media: png, wantCalls: 1, wantText: "data:image/png;base64,cG5n"},
+		{name: "image with text content type", msgType: message.MessageTypeImage,
+			media: &message.MediaFile{MimeType: "text/plain", Data: []byte("oops")}, wantCalls: 1},
+		{name: "file", msgType: message.MessageTypeFile, media: pdf, wantCalls: 1, wantFiles: 1},
+		{name: "too large", msgType: message.MessageTypeFile, media: large, wantCalls: 1},
+		{name: "download error", msgType: message.MessageTypeVideo, err: errors.New("boom"), wantCalls: 1},
+		{name: "already downloaded", msgType: message.MessageTypeImage, content: "data:image/png;base64,eA==", wantText: "data:image/png;base64,eA=="},
+		{name: "text", msgType: message.MessageTypeText, content: "hi", wantText: "hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPipeline()
+			p.cfg.Pipeline.MaxMediaSizeMB = 1
+			sender := &mediaSender{media: tt.media, err: tt.err}
+			p.RegisterSender("wecom", sender)
+
+			msg := &message.Message{Platform: "wecom", MessageType: tt.msgType, Content: tt.content, Metadata: map[string]string{}}
+			files := p.downloadMedia(context.Background(), msg)
+			if sender.calls != tt.wantCalls || len(files) != tt.wantFiles {
+				t.Errorf("calls = %d, files = %d, want %d, %d", sender.calls, len(files), tt.wantCalls, tt.wantFiles)
+			}
+			if msg.Content != tt.wantText {
+				t.Errorf("content = %.60q, want %q", msg.Content, tt.wantText)
+			}
+		})
+	}
+}
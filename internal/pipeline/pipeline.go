@@ -2,59 +2,87 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 	"sync"
+	"time"
 
+	"xia_adpter/internal/agent"
 	"xia_adpter/internal/agent/coze"
 	"xia_adpter/internal/agent/dify"
 	"xia_adpter/internal/config"
 	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+	"xia_adpter/internal/session"
+	"xia_adpter/internal/voice"
 
 	"go.uber.org/zap"
 )
 
 // PlatformSender 平台消息发送接口
 type PlatformSender interface {
-	SendMessage(sessionID string, content string) error
+	// Send 发送消息，适配器按片段类型渲染为平台原生消息
+	Send(ctx context.Context, msg *message.OutboundMessage) error
 }
 
 // Pipeline 消息处理管道
 type Pipeline struct {
 	cfg       *config.Config
 	logger    *zap.Logger
-	difyAgent *dify.Agent
-	cozeAgent *coze.Agent
+	agents    *agent.Registry
+	router    *Router
 	converter *message.Converter
-	
+
 	// 平台发送器映射
-	senders map[string]PlatformSender
-	mu      sync.RWMutex
+	senders   map[string]PlatformSender
+	platforms *platform.Registry
+	mu        sync.RWMutex
+
+	// 按会话串行处理消息的工作池，Start 时创建
+	pool *workerPool
+
+	// 语音识别器，为 nil 时不识别语音消息
+	transcriber *voice.Transcriber
+
+	// 重试后仍无法送达的回复
+	deadLetters *deadLetterStore
 }
 
 // New 创建新的消息处理管道
 func New(cfg *config.Config, logger *zap.Logger) *Pipeline {
 	p := &Pipeline{
-		cfg:       cfg,
-		logger:    logger,
-		senders:   make(map[string]PlatformSender),
-		converter: message.NewConverter(),
+		cfg:         cfg,
+		logger:      logger,
+		agents:      agent.NewRegistry(logger),
+		router:      NewRouter(cfg.Routes, logger),
+		senders:     make(map[string]PlatformSender),
+		converter:   message.NewConverter(),
+		deadLetters: newDeadLetterStore(cfg.Pipeline.Delivery.DeadLetterSize),
 	}
 
-	// 初始化 Dify Agent
-	if cfg.Agent.Dify.Enabled {
-		p.difyAgent = dify.NewAgent(cfg.Agent.Dify, logger)
-	}
+	// 注册内置 Agent 类型，并按配置创建实例
+	p.agents.RegisterFactory(dify.Type, dify.Factory)
+	p.agents.RegisterFactory(coze.Type, coze.Factory)
+	p.agents.Load(cfg.Agent)
 
-	// 初始化 Coze Agent
-	if cfg.Agent.Coze.Enabled {
-		p.cozeAgent = coze.NewAgent(cfg.Agent.Coze, logger)
-	}
+	// 默认使用内存会话存储，可通过 SetSessionStore 替换
+	p.SetSessionStore(session.NewMemoryStore(time.Duration(cfg.Session.TTL) * time.Second))
 
 	return p
 }
 
+// SetSessionStore 设置会话存储，Agent 的 conversation_id 保存在其中以延续多轮对话
+func (p *Pipeline) SetSessionStore(store session.Store) {
+	p.converter.SetSessionStore(store)
+	p.agents.SetSessionStore(store)
+}
+
+// Agents 返回 Agent 注册表
+func (p *Pipeline) Agents() *agent.Registry {
+	return p.agents
+}
+
 // RegisterSender 注册平台发送器
 func (p *Pipeline) RegisterSender(platform string, sender PlatformSender) {
 	p.mu.Lock()
@@ -62,8 +90,44 @@ func (p *Pipeline) RegisterSender(platform string, sender PlatformSender) {
 	p.senders[platform] = sender
 }
 
+// SetPlatforms 设置平台注册表，未单独注册发送器的平台从注册表中查找
+func (p *Pipeline) SetPlatforms(platforms *platform.Registry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.platforms = platforms
+}
+
+// getSender 获取平台发送器
+func (p *Pipeline) getSender(name string) (PlatformSender, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if sender, ok := p.senders[name]; ok && sender != nil {
+		return sender, true
+	}
+	if p.platforms != nil {
+		if plat, ok := p.platforms.Get(name); ok {
+			return plat, true
+		}
+	}
+	return nil, false
+}
+
 // Start 启动消息处理管道
+// 同一会话的消息按顺序逐条处理，不同会话并行处理，全局并发数由 pipeline.workers 限制。
+// ctx 取消后停止从队列取消息，已取出的消息继续处理完成，可通过 Wait 等待
 func (p *Pipeline) Start(ctx context.Context, queue *message.Queue) error {
+	// 处理消息使用独立的上下文，避免停止接收时中断正在进行的 Agent 请求
+	processCtx := context.WithoutCancel(ctx)
+
+	pool := newWorkerPool(p.cfg.Pipeline.Workers, p.cfg.Pipeline.SessionQueueSize, p.cfg.Pipeline.MaxPending, func(ctx context.Context, msg *message.Message) {
+		p.handleMessage(ctx, queue, msg)
+	})
+	p.mu.Lock()
+	p.pool = pool
+	p.mu.Unlock()
+	pool.start(processCtx)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -73,19 +137,113 @@ func (p *Pipeline) Start(ctx context.Context, queue *message.Queue) error {
 			if err != nil {
 				return err
 			}
+			if err := p.dispatch(ctx, pool, queue, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dispatch 将消息提交到工作池，排队的消息总数达到上限时阻塞，使管道停止从队列取消息
+// 会话积压过多的消息记入队列死信且不确认；ctx 取消时返回错误，消息同样不确认，持久化队列重启后重新处理
+func (p *Pipeline) dispatch(ctx context.Context, pool *workerPool, queue *message.Queue, msg *message.Message) error {
+	err := pool.submit(ctx, msg)
+	if errors.Is(err, ErrSessionQueueFull) {
+		p.logger.Warn("Session backlog full, message moved to dead letters",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+		)
+		queue.Reject(msg, err.Error())
+		return nil
+	}
+	return err
+}
 
-			// 处理消息
-			go p.processMessage(ctx, msg)
+// handleMessage 处理一条消息并确认
+// 处理过程中 panic 时记录日志并将消息记入队列死信（与 dispatch 一致不确认），工作协程继续处理后续消息
+func (p *Pipeline) handleMessage(ctx context.Context, queue *message.Queue, msg *message.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic while processing message, moved to dead letters",
+				zap.String("platform", msg.Platform),
+				zap.String("session_id", msg.SessionID),
+				zap.Any("panic", r),
+				zap.Stack("stack"),
+			)
+			queue.Reject(msg, fmt.Sprintf("panic: %v", r))
 		}
+	}()
+
+	p.processMessage(ctx, msg)
+	p.ack(queue, msg)
+}
+
+// ack 确认消息已处理，持久化队列不再在重启后重放该消息
+func (p *Pipeline) ack(queue *message.Queue, msg *message.Message) {
+	if err := queue.Ack(msg); err != nil {
+		p.logger.Warn("Failed to ack message",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+			zap.Error(err),
+		)
+	}
+}
+
+// Wait 等待已取出的消息处理完成，需在 Start 返回后调用
+func (p *Pipeline) Wait(ctx context.Context) error {
+	p.mu.RLock()
+	pool := p.pool
+	p.mu.RUnlock()
+
+	if pool == nil {
+		return nil
 	}
+	pool.stop()
+	return pool.wait(ctx)
+}
+
+// Stats 返回工作池统计信息
+func (p *Pipeline) Stats() WorkerStats {
+	p.mu.RLock()
+	pool := p.pool
+	p.mu.RUnlock()
+
+	if pool == nil {
+		workers := p.cfg.Pipeline.Workers
+		if workers <= 0 {
+			workers = defaultWorkers
+		}
+		return WorkerStats{Workers: workers}
+	}
+	return pool.stats()
 }
 
 // processMessage 处理单个消息
 func (p *Pipeline) processMessage(ctx context.Context, msg *message.Message) {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]string)
+	}
+
+	// 语音消息先识别为文本，路由规则可以匹配识别结果
+	if msg.IsVoice() && !p.transcribeVoice(ctx, msg) {
+		return
+	}
+
+	// 规范化消息内容，路由规则匹配规范化后的文本
+	p.converter.NormalizeContent(msg)
+
+	// 按路由规则选择 Agent，并记录到元数据中便于日志排查
+	route := p.router.Match(msg)
+	if route != nil {
+		msg.Metadata["route"] = route.Name
+		msg.Metadata["agent"] = route.Agent
+	}
+
 	p.logger.Info("Processing message",
 		zap.String("platform", msg.Platform),
 		zap.String("session_id", msg.SessionID),
 		zap.String("type", msg.MessageType),
+		zap.String("route", msg.Metadata["route"]),
 		zap.String("content", func() string {
 			if len(msg.Content) > 100 {
 				return msg.Content[:100] + "..."
@@ -94,27 +252,56 @@ func (p *Pipeline) processMessage(ctx context.Context, msg *message.Message) {
 		}()),
 	)
 
-	// 规范化消息内容
-	p.converter.NormalizeContent(msg)
+	// 下载平台未直接提供内容的图片、视频、文件
+	media := p.downloadMedia(ctx, msg)
 
 	// 转换为 Agent 请求格式
 	agentReq := p.converter.ToAgentRequest(msg)
+	if msg.MessageType == message.MessageTypeVideo || msg.MessageType == message.MessageTypeFile {
+		agentReq.Media = media
+		if agentReq.Query == "" {
+			agentReq.Query = mediaQuery(msg, media)
+		}
+	}
+
+	// 没有文本、图片和文件的消息（如内容为空的事件）不发送给 Agent
+	if strings.TrimSpace(agentReq.Query) == "" && len(agentReq.ImageURLs) == 0 && len(agentReq.Media) == 0 {
+		p.logger.Info("Ignored empty message",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+			zap.String("type", msg.MessageType),
+		)
+		return
+	}
 
-	// 根据配置选择 Agent
+	// 平台支持时使用流式回复
+	sender, hasSender := p.getSender(msg.Platform)
+	var stream *replyStream
+	if hasSender {
+		stream = newReplyStream(ctx, sender, msg, p.logger)
+	}
+
+	// 按顺序尝试 Agent，失败时回退到下一个
 	var agentResp *message.AgentResponse
-	var err error
-
-	if p.cfg.Agent.Dify.Enabled {
-		agentResp, err = p.difyAgent.Chat(ctx, agentReq)
-		if err != nil {
-			p.logger.Error("Dify agent error", zap.Error(err))
-			// 如果 Dify 失败，尝试 Coze
-			if p.cfg.Agent.Coze.Enabled {
-				agentResp, err = p.cozeAgent.Chat(ctx, agentReq)
-			}
+	err := fmt.Errorf("no agent available")
+
+	for _, ag := range p.candidateAgents(route) {
+		// 每个 Agent 使用各自的会话
+		agentReq.Agent = ag.Name()
+		delete(agentReq.Metadata, "conversation_id")
+		if cid := p.converter.ConversationID(agentReq); cid != "" {
+			agentReq.Metadata["conversation_id"] = cid
+		}
+
+		agentResp, err = p.chat(ctx, ag, agentReq, stream)
+		if err == nil {
+			msg.Metadata["agent"] = ag.Name()
+			break
 		}
-	} else if p.cfg.Agent.Coze.Enabled {
-		agentResp, err = p.cozeAgent.Chat(ctx, agentReq)
+		p.logger.Error("Agent error",
+			zap.String("agent", ag.Name()),
+			zap.Error(err),
+		)
 	}
 
 	if err != nil {
@@ -127,44 +314,41 @@ func (p *Pipeline) processMessage(ctx context.Context, msg *message.Message) {
 
 	// 将 Agent 响应转换为统一消息格式
 	responseMsg := p.converter.FromAgentResponse(agentResp, msg)
-	
-	// 如果 Agent 返回了 conversation_id，保存到原始消息的 Metadata 中
-	// 这样下次请求时可以使用相同的 conversation_id
-	// 注意：只保存有效的 UUID 格式的 conversation_id
-	if agentResp != nil && agentResp.Metadata != nil {
-		if cid, ok := agentResp.Metadata["conversation_id"]; ok && cid != "" {
-			// 验证 conversation_id 是否是有效的 UUID
-			uuidRegex := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
-			if uuidRegex.MatchString(strings.ToLower(cid)) {
-				if msg.Metadata == nil {
-					msg.Metadata = make(map[string]string)
-				}
-				msg.Metadata["conversation_id"] = cid
-				// 同时保存到响应消息的 Metadata 中
-				if responseMsg.Metadata == nil {
-					responseMsg.Metadata = make(map[string]string)
-				}
-				responseMsg.Metadata["conversation_id"] = cid
-			} else {
-				// 如果不是有效的 UUID，清除之前可能错误保存的 conversation_id
-				if msg.Metadata != nil {
-					delete(msg.Metadata, "conversation_id")
-				}
-				if responseMsg.Metadata != nil {
-					delete(responseMsg.Metadata, "conversation_id")
-				}
+
+	// 保存 Agent 返回的 conversation_id，同一会话的下一条消息继续使用
+	if err == nil && agentResp.Metadata != nil {
+		if cid := agentResp.Metadata["conversation_id"]; cid != "" {
+			if err := p.converter.SaveConversationID(agentReq, cid); err != nil {
+				p.logger.Warn("Failed to save conversation id",
+					zap.String("agent", agentReq.Agent),
+					zap.Error(err),
+				)
 			}
 		}
 	}
 
-	// 发送回复到平台
-	p.mu.RLock()
-	sender, ok := p.senders[msg.Platform]
-	p.mu.RUnlock()
+	// 文本已通过流式回复送达时不再重复发送，只发送附件
+	if p.finishStream(ctx, sender, stream, agentResp.Content) {
+		p.logger.Info("Message streamed successfully",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+		)
+		p.sendAttachments(ctx, sender, msg, responseMsg.Attachments)
+		return
+	}
 
-	if ok && sender != nil {
-		// 根据平台格式化消息
-		if err := p.sendToPlatform(sender, msg.Platform, responseMsg); err != nil {
+	// 发送回复到平台
+	if hasSender && responseMsg.Content == "" {
+		// 只有图片/文件的回复
+		p.sendAttachments(ctx, sender, msg, responseMsg.Attachments)
+	} else if hasSender {
+		// 根据平台格式化消息，路由可以指定回复格式
+		out := withReplyContext(p.converter.ToOutboundMessage(responseMsg), msg)
+		out.IdempotencyKey = idempotencyKey(msg, "")
+		if route != nil && route.ReplyFormat != "" {
+			out.Metadata[message.MetadataReplyFormat] = route.ReplyFormat
+		}
+		if err := p.send(ctx, sender, msg.Platform, out); err != nil {
 			p.logger.Error("Failed to send message to platform",
 				zap.String("platform", msg.Platform),
 				zap.String("session_id", msg.SessionID),
@@ -176,6 +360,7 @@ func (p *Pipeline) processMessage(ctx context.Context, msg *message.Message) {
 				zap.String("session_id", msg.SessionID),
 			)
 		}
+		p.sendAttachments(ctx, sender, msg, responseMsg.Attachments)
 	} else {
 		p.logger.Warn("No sender registered for platform",
 			zap.String("platform", msg.Platform),
@@ -183,27 +368,47 @@ func (p *Pipeline) processMessage(ctx context.Context, msg *message.Message) {
 	}
 }
 
-// sendToPlatform 发送消息到平台
-func (p *Pipeline) sendToPlatform(sender PlatformSender, platform string, msg *message.Message) error {
-	// 根据平台类型格式化消息
-	switch platform {
-	case message.PlatformLark:
-		// 飞书需要特殊格式，由适配器处理
-		return sender.SendMessage(msg.SessionID, msg.Content)
-	case message.PlatformWeCom:
-		// 企微需要分割长文本
-		if msg.IsText() {
-			chunks := p.converter.SplitLongText(msg.Content, 2048)
-			for _, chunk := range chunks {
-				if err := sender.SendMessage(msg.SessionID, chunk); err != nil {
-					return err
-				}
+// chat 调用 Agent，Agent 支持流式输出且平台启用流式回复时边生成边更新
+func (p *Pipeline) chat(ctx context.Context, ag agent.Agent, req *message.AgentRequest, stream *replyStream) (*message.AgentResponse, error) {
+	if sa, ok := ag.(agent.StreamingAgent); ok && stream != nil {
+		stream.reset()
+		return sa.ChatStream(ctx, req, stream.onDelta)
+	}
+	return ag.Chat(ctx, req)
+}
+
+// candidateAgents 返回候选 Agent 列表
+// 命中路由时使用路由指定的 Agent（允许回退时其余 Agent 排在其后），
+// 否则默认 Agent 排在最前，其余按注册顺序作为回退
+func (p *Pipeline) candidateAgents(route *Route) []agent.Agent {
+	preferred := p.cfg.Agent.Default
+	if route != nil {
+		preferred = route.Agent
+		if !route.Fallback {
+			if a, ok := p.agents.Get(preferred); ok {
+				return []agent.Agent{a}
 			}
+			p.logger.Warn("Route agent not found",
+				zap.String("route", route.Name),
+				zap.String("agent", route.Agent),
+			)
 			return nil
 		}
-		return sender.SendMessage(msg.SessionID, msg.Content)
-	default:
-		return sender.SendMessage(msg.SessionID, msg.Content)
 	}
-}
 
+	agents := p.agents.List()
+	if preferred == "" {
+		return agents
+	}
+
+	candidates := make([]agent.Agent, 0, len(agents))
+	if a, ok := p.agents.Get(preferred); ok {
+		candidates = append(candidates, a)
+	}
+	for _, a := range agents {
+		if a.Name() != preferred {
+			candidates = append(candidates, a)
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,31 @@
+package pipeline
+
+import "xia_adpter/internal/message"
+
+// withReplyContext 为出站消息设置所回复的入站消息及会话信息
+// 平台按自身配置决定是否引用回复、@ 提问者，不支持的平台忽略这些字段
+func withReplyContext(out *message.OutboundMessage, msg *message.Message) *message.OutboundMessage {
+	if msg.Metadata == nil {
+		return out
+	}
+	if out.Metadata == nil {
+		out.Metadata = make(map[string]string)
+	}
+
+	if out.ReplyTo == "" {
+		out.ReplyTo = msg.Metadata["message_id"]
+	}
+	if out.ReplyTo == "" {
+		out.ReplyTo = msg.Metadata["msg_id"]
+	}
+	if chatID := msg.Metadata["chat_id"]; chatID != "" {
+		out.Metadata[message.MetadataChatID] = chatID
+	}
+	if chatType := msg.Metadata["chat_type"]; chatType != "" {
+		out.Metadata[message.MetadataChatType] = chatType
+	}
+	if msg.UserID != "" {
+		out.Metadata[message.MetadataSenderID] = msg.UserID
+	}
+	return out
+}
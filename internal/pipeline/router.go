@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// Route 编译后的路由规则
+type Route struct {
+	config.RouteConfig
+	content *regexp.Regexp
+}
+
+// Router 按规则为消息选择 Agent
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter 根据配置创建路由器，无效的规则会被跳过并记录日志
+func NewRouter(routes []config.RouteConfig, logger *zap.Logger) *Router {
+	r := &Router{}
+	for i, rc := range routes {
+		if rc.Name == "" {
+			rc.Name = fmt.Sprintf("route_%d", i)
+		}
+		if rc.Agent == "" {
+			logger.Error("Route has no agent, skipped", zap.String("route", rc.Name))
+			continue
+		}
+
+		route := &Route{RouteConfig: rc}
+		if rc.Content != "" {
+			re, err := regexp.Compile(rc.Content)
+			if err != nil {
+				logger.Error("Invalid route content pattern, skipped",
+					zap.String("route", rc.Name),
+					zap.String("pattern", rc.Content),
+					zap.Error(err),
+				)
+				continue
+			}
+			route.content = re
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r
+}
+
+// Match 返回第一条命中的路由，没有命中时返回 nil
+func (r *Router) Match(msg *message.Message) *Route {
+	for _, route := range r.routes {
+		if route.matches(msg) {
+			return route
+		}
+	}
+	return nil
+}
+
+// matches 检查消息是否满足路由的所有条件
+func (r *Route) matches(msg *message.Message) bool {
+	if r.Platform != "" && r.Platform != msg.Platform {
+		return false
+	}
+	if r.ChatType != "" && !matchChatType(r.ChatType, msg.Metadata["chat_type"]) {
+		return false
+	}
+	if r.ChatID != "" && r.ChatID != msg.Metadata["chat_id"] && r.ChatID != msg.SessionID {
+		return false
+	}
+	if r.UserID != "" && r.UserID != msg.UserID {
+		return false
+	}
+	if r.MessageType != "" && r.MessageType != msg.MessageType {
+		return false
+	}
+	if r.content != nil && !r.content.MatchString(routeText(msg)) {
+		return false
+	}
+	return true
+}
+
+// routeText 返回内容正则匹配的文本：规范化后的文本消息、语音识别结果及其他消息的文本描述，
+// 图片消息的内容是 base64 数据或 URL，不参与匹配
+func routeText(msg *message.Message) string {
+	if msg.MessageType == message.MessageTypeImage {
+		return ""
+	}
+	return msg.Content
+}
+
+// matchChatType 匹配会话类型，group 同时匹配飞书的话题群 topic_group
+func matchChatType(want, got string) bool {
+	if want == got {
+		return true
+	}
+	return want == "group" && got == "topic_group"
+}
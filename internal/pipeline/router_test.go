@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"xia_adpter/internal/agent"
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func routeMessage(platform, chatType, content string) *message.Message {
+	msg := message.NewTextMessage(platform, "oc_1", "ou_1", content)
+	msg.Metadata["chat_type"] = chatType
+	msg.Metadata["chat_id"] = "oc_1"
+	return msg
+}
+
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter([]config.RouteConfig{
+		{Name: "translate", Content: `^/translate\s`, Agent: "translator"},
+		{Name: "lark_group", Platform: "lark", ChatType: "group", Agent: "support"},
+		{Name: "lark_dm", Platform: "lark", ChatType: "p2p", Agent: "dify"},
+		{Name: "vip", UserID: "ou_vip", Agent: "vip"},
+		{Name: "images", MessageType: "image", Agent: "vision"},
+		{Name: "everything", Agent: "default"},
+	}, zap.NewNop())
+
+	image := message.NewTextMessage("wecom", "s1", "u1", "/translate aGVsbG8=")
+	image.MessageType = message.MessageTypeImage
+
+	vip := routeMessage("wecom", "p2p", "hi")
+	vip.UserID = "ou_vip"
+
+	tests := []struct {
+		name string
+		msg  *message.Message
+		want string
+	}{
+		{name: "content regex wins over later rules", msg: routeMessage("lark", "group", "/translate hello"), want: "translate"},
+		{name: "content regex needs the trailing space", msg: routeMessage("lark", "p2p", "/translated"), want: "lark_dm"},
+		{name: "group", msg: routeMessage("lark", "group", "hi"), want: "lark_group"},
+		{name: "topic group counts as group", msg: routeMessage("lark", "topic_group", "hi"), want: "lark_group"},
+		{name: "p2p", msg: routeMessage("lark", "p2p", "hi"), want: "lark_dm"},
+		{name: "other platform skips lark rules", msg: routeMessage("wecom", "group", "hi"), want: "everything"},
+		{name: "user", msg: vip, want: "vip"},
+		{name: "image content is not matched as text", msg: image, want: "images"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := router.Match(tt.msg)
+			if route == nil || route.Name != tt.want {
+				t.Errorf("Match = %+v, want %s", route, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterSkipsInvalidRules(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	router := NewRouter([]config.RouteConfig{
+		{Name: "broken", Content: `([`, Agent: "broken"},
+		{Name: "no_agent", Platform: "lark"},
+		{Platform: "wecom", Agent: "wecom_bot"},
+	}, zap.New(core))
+
+	if logs.FilterMessage("Invalid route content pattern, skipped").Len() != 1 {
+		t.Error("invalid pattern not logged")
+	}
+	if logs.FilterMessage("Route has no agent, skipped").Len() != 1 {
+		t.Error("route without agent not logged")
+	}
+
+	// 无效的规则被跳过，而不是匹配所有消息
+	if route := router.Match(routeMessage("lark", "p2p", "hi")); route != nil {
+		t.Errorf("Match = %s, want no route", route.Name)
+	}
+	route := router.Match(routeMessage("wecom", "p2p", "hi"))
+	if route == nil || route.Name != "route_2" || route.Agent != "wecom_bot" {
+		t.Errorf("Match = %+v, want route_2", route)
+	}
+}
+
+func TestProcessMessageRoutesNormalizedText(t *testing.T) {
+	p := newTestPipeline()
+	p.router = NewRouter([]config.RouteConfig{{Name: "translate", Content: `^/translate\s`, Agent: "translator"}}, zap.NewNop())
+
+	// 飞书去掉 @机器人 后文本前后可能留有空白，规范化后再匹配
+	msg := routeMessage("lark", "group", "  /translate hello\r\n")
+	p.processMessage(context.Background(), msg)
+	if msg.Metadata["route"] != "translate" {
+		t.Errorf("route = %q, want translate", msg.Metadata["route"])
+	}
+}
+
+// namedAgent 只有名称的 Agent
+type namedAgent string
+
+func (a namedAgent) Name() string                     { return string(a) }
+func (a namedAgent) Type() string                     { return "fake" }
+func (a namedAgent) Capabilities() agent.Capabilities { return agent.Capabilities{} }
+func (a namedAgent) Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error) {
+	return &message.AgentResponse{Content: string(a)}, nil
+}
+
+func TestCandidateAgents(t *testing.T) {
+	p := newTestPipeline()
+	for _, name := range []string{"dify", "coze", "support"} {
+		if err := p.agents.Register(namedAgent(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	route := func(agent string, fallback bool) *Route {
+		return &Route{RouteConfig: config.RouteConfig{Name: "r", Agent: agent, Fallback: fallback}}
+	}
+	tests := []struct {
+		name         string
+		defaultAgent string
+		route        *Route
+		want         string
+	}{
+		{name: "registration order", want: "dify,coze,support"},
+		{name: "default first", defaultAgent: "support", want: "support,dify,coze"},
+		{name: "unknown default", defaultAgent: "gone", want: "dify,coze,support"},
+		{name: "route only", defaultAgent: "support", route: route("coze", false), want: "coze"},
+		{name: "route with fallback", defaultAgent: "support", route: route("coze", true), want: "coze,dify,support"},
+		{name: "unknown route agent", route: route("gone", false), want: ""},
+	}
+	for _, tt := range tests {
+		p.cfg.Agent.Default = tt.defaultAgent
+		var got []string
+		for _, a := range p.candidateAgents(tt.route) {
+			got = append(got, a.Name())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: candidates = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// replyStream 把 Agent 的增量输出转发到平台的流式回复
+// 收到第一段输出时才开始流式回复，避免 Agent 直接失败时留下空消息
+type replyStream struct {
+	ctx    context.Context
+	sender platform.StreamingSender
+	msg    *message.Message
+	logger *zap.Logger
+
+	stream platform.Stream
+	buf    strings.Builder
+	failed bool
+}
+
+// newReplyStream 平台支持并启用流式回复时返回 replyStream，否则返回 nil
+func newReplyStream(ctx context.Context, sender PlatformSender, msg *message.Message, logger *zap.Logger) *replyStream {
+	ss, ok := sender.(platform.StreamingSender)
+	if !ok || !ss.StreamingEnabled() {
+		return nil
+	}
+	return &replyStream{
+		ctx:    ctx,
+		sender: ss,
+		msg:    msg,
+		logger: logger,
+	}
+}
+
+// onDelta 接收增量输出并更新流式回复
+func (s *replyStream) onDelta(delta string) {
+	if s.failed {
+		return
+	}
+	s.buf.WriteString(delta)
+
+	if s.stream == nil {
+		stream, err := s.sender.BeginStream(s.ctx, s.msg.SessionID, s.msg.Metadata)
+		if err != nil {
+			s.logger.Warn("Failed to begin stream reply, falling back to normal reply",
+				zap.String("platform", s.msg.Platform),
+				zap.String("session_id", s.msg.SessionID),
+				zap.Error(err),
+			)
+			s.failed = true
+			return
+		}
+		s.stream = stream
+	}
+
+	if err := s.stream.Update(s.buf.String()); err != nil {
+		s.logger.Warn("Failed to update stream reply", zap.Error(err))
+	}
+}
+
+// reset 清空已累积的输出，切换到回退 Agent 时使用
+func (s *replyStream) reset() {
+	s.buf.Reset()
+}
+
+// finishStream 写入流式回复的最终内容，可重试的错误按投递的退避策略重试，
+// 超出卡片大小的剩余内容作为普通回复继续发送，返回内容是否已通过流式回复送达
+func (p *Pipeline) finishStream(ctx context.Context, sender PlatformSender, s *replyStream, content string) bool {
+	if s == nil || s.stream == nil {
+		return false
+	}
+
+	var rest string
+	_, err := p.retry(ctx, s.msg.Platform, s.msg.SessionID, 0, func(int) error {
+		var err error
+		rest, err = s.stream.Finish(content)
+		return err
+	})
+	if err != nil {
+		s.logger.Warn("Failed to finish stream reply, falling back to normal reply", zap.Error(err))
+		return false
+	}
+	if strings.TrimSpace(rest) == "" {
+		return true
+	}
+
+	out := withReplyContext(message.NewOutboundMessage(s.msg.SessionID, message.MarkdownPart(rest)), s.msg)
+	out.IdempotencyKey = idempotencyKey(s.msg, "stream")
+	if err := p.send(ctx, sender, s.msg.Platform, out); err != nil {
+		p.logger.Error("Failed to send rest of stream reply",
+			zap.String("platform", s.msg.Platform),
+			zap.String("session_id", s.msg.SessionID),
+			zap.Error(err),
+		)
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// fakeStream 按顺序返回预设的错误，之后返回 rest
+type fakeStream struct {
+	errs     []error
+	rest     string
+	finishes int
+}
+
+func (s *fakeStream) Update(content string) error { return nil }
+
+func (s *fakeStream) Finish(content string) (string, error) {
+	s.finishes++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return "", err
+	}
+	return s.rest, nil
+}
+
+// recordingSender 记录发送的出站消息
+type recordingSender struct {
+	sent []*message.OutboundMessage
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg *message.OutboundMessage) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestFinishStreamRetriesAndSendsRest(t *testing.T) {
+	p := newTestPipeline()
+	msg := message.NewTextMessage("lark", "oc_1", "ou_1", "hi")
+	msg.Metadata["message_id"] = "om_1"
+
+	fs := &fakeStream{
+		errs: []error{&platform.SendError{Code: 99991400, Retryable: true}},
+		rest: "剩余内容",
+	}
+	rs := &replyStream{msg: msg, logger: zap.NewNop(), stream: fs}
+	sender := &recordingSender{}
+
+	if !p.finishStream(context.Background(), sender, rs, "完整内容") {
+		t.Fatal("stream reply not delivered")
+	}
+	if fs.finishes != 2 {
+		t.Errorf("Finish called %d times, want 2", fs.finishes)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d follow-up messages, want 1", len(sender.sent))
+	}
+	out := sender.sent[0]
+	if out.Parts[0].Text != "剩余内容" || out.ReplyTo != "om_1" || out.IdempotencyKey != "om_1-stream" {
+		t.Errorf("follow-up = %+v", out)
+	}
+}
+
+func TestFinishStreamFallsBackOnFatalError(t *testing.T) {
+	p := newTestPipeline()
+	msg := message.NewTextMessage("lark", "oc_1", "ou_1", "hi")
+	fs := &fakeStream{errs: []error{&platform.SendError{Code: 230001}}}
+	rs := &replyStream{msg: msg, logger: zap.NewNop(), stream: fs}
+
+	if p.finishStream(context.Background(), &recordingSender{}, rs, "完整内容") {
+		t.Error("failed stream reported as delivered")
+	}
+	if fs.finishes != 1 {
+		t.Errorf("fatal error retried: %d calls", fs.finishes)
+	}
+	if p.finishStream(context.Background(), &recordingSender{}, nil, "完整内容") {
+		t.Error("nil stream reported as delivered")
+	}
+}
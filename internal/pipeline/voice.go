@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+	"xia_adpter/internal/voice"
+
+	"go.uber.org/zap"
+)
+
+// SetTranscriber 设置语音识别器，为 nil 时不处理语音消息
+func (p *Pipeline) SetTranscriber(t *voice.Transcriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transcriber = t
+}
+
+// transcribeVoice 将语音消息识别为文本作为查询内容，原始内容保存在元数据中
+// 无法识别时回复提示并返回 false，该消息不再交给 Agent
+func (p *Pipeline) transcribeVoice(ctx context.Context, msg *message.Message) bool {
+	p.mu.RLock()
+	transcriber := p.transcriber
+	p.mu.RUnlock()
+
+	text, err := p.recognize(ctx, transcriber, msg)
+	if err != nil {
+		p.logger.Warn("Failed to transcribe voice message",
+			zap.String("platform", msg.Platform),
+			zap.String("session_id", msg.SessionID),
+			zap.Error(err),
+		)
+
+		reply := "语音识别失败，请重试或发送文字"
+		if transcriber == nil {
+			reply = "暂不支持语音消息，请发送文字"
+		} else if errors.Is(err, voice.ErrEmptyTranscript) {
+			reply = "没有听清，请再说一遍或发送文字"
+		}
+		if sender, ok := p.getSender(msg.Platform); ok {
+			out := withReplyContext(message.NewOutboundMessage(msg.SessionID, message.TextPart(reply)), msg)
+			out.IdempotencyKey = idempotencyKey(msg, "voice")
+			if err := p.send(ctx, sender, msg.Platform, out); err != nil {
+				p.logger.Error("Failed to send voice error reply",
+					zap.String("platform", msg.Platform),
+					zap.String("session_id", msg.SessionID),
+					zap.Error(err),
+				)
+			}
+		}
+		return false
+	}
+
+	if msg.Content != "" {
+		msg.Metadata["original_content"] = msg.Content
+	}
+	msg.Metadata["transcript"] = text
+	msg.Content = text
+
+	p.logger.Info("Voice message transcribed",
+		zap.String("platform", msg.Platform),
+		zap.String("session_id", msg.SessionID),
+		zap.Int("length", len(text)),
+	)
+	return true
+}
+
+// recognize 从平台下载语音并识别
+func (p *Pipeline) recognize(ctx context.Context, transcriber *voice.Transcriber, msg *message.Message) (string, error) {
+	if transcriber == nil {
+		return "", errors.New("voice transcription is not enabled")
+	}
+
+	sender, ok := p.getSender(msg.Platform)
+	if !ok {
+		return "", errors.New("no sender registered for platform")
+	}
+	downloader, ok := sender.(platform.MediaDownloader)
+	if !ok {
+		return "", errors.New("platform does not support downloading media")
+	}
+
+	media, err := downloader.DownloadMedia(ctx, msg)
+	if err != nil {
+		return "", err
+	}
+	return transcriber.Transcribe(ctx, media.Data, media.Format)
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"xia_adpter/internal/message"
+)
+
+const (
+	// defaultWorkers 默认全局并发数
+	defaultWorkers = 16
+	// defaultSessionQueueSize 默认每个会话最多排队的消息数
+	defaultSessionQueueSize = 20
+	// defaultMaxPending 默认所有会话合计最多排队的消息数
+	defaultMaxPending = 200
+)
+
+// ErrSessionQueueFull 会话排队的消息数达到上限
+var ErrSessionQueueFull = errors.New("session queue is full")
+
+// WorkerStats 工作池统计信息
+type WorkerStats struct {
+	Workers   int    `json:"workers"`   // 工作协程数
+	Busy      int    `json:"busy"`      // 正在处理消息的工作协程数
+	Sessions  int    `json:"sessions"`  // 有待处理消息的会话数
+	Queued    int    `json:"queued"`    // 等待处理的消息数（不含处理中的）
+	Processed uint64 `json:"processed"` // 已处理完成的消息数
+	Rejected  uint64 `json:"rejected"`  // 因会话排队已满被拒绝的消息数
+}
+
+// sessionQueue 单个会话的待处理消息
+type sessionQueue struct {
+	msgs      []*message.Message
+	scheduled bool // 已在就绪队列中或正在处理，保证同一会话同时只有一个工作协程
+}
+
+// workerPool 按会话串行、会话之间并行的工作池
+// 同一会话的消息按到达顺序逐条处理，全局并发数由工作协程数限制；
+// 排队的消息总数达到上限时 submit 阻塞，调用方随之停止从队列取消息
+type workerPool struct {
+	workers      int
+	sessionLimit int
+	handle       func(ctx context.Context, msg *message.Message)
+	slots        chan struct{} // 排队的空位，消息开始处理时释放
+
+	sessions map[string]*sessionQueue
+	ready    []string // 等待工作协程处理的会话
+	busy     int
+	queued   int
+	closed   bool
+
+	processed uint64
+	rejected  uint64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	wg   sync.WaitGroup
+}
+
+// newWorkerPool 创建工作池，workers、sessionLimit、maxPending 不大于 0 时使用默认值
+func newWorkerPool(workers, sessionLimit, maxPending int, handle func(ctx context.Context, msg *message.Message)) *workerPool {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if sessionLimit <= 0 {
+		sessionLimit = defaultSessionQueueSize
+	}
+	if maxPending <= 0 {
+		maxPending = defaultMaxPending
+	}
+	wp := &workerPool{
+		workers:      workers,
+		sessionLimit: sessionLimit,
+		handle:       handle,
+		slots:        make(chan struct{}, maxPending),
+		sessions:     make(map[string]*sessionQueue),
+	}
+	wp.cond = sync.NewCond(&wp.mu)
+	return wp
+}
+
+// start 启动工作协程
+func (wp *workerPool) start(ctx context.Context) {
+	for i := 0; i < wp.workers; i++ {
+		wp.wg.Add(1)
+		go wp.run(ctx)
+	}
+}
+
+// submit 提交消息到所属会话的队列
+// 排队的消息总数达到上限时阻塞直到有空位或 ctx 结束；会话排队已满时返回 ErrSessionQueueFull
+func (wp *workerPool) submit(ctx context.Context, msg *message.Message) error {
+	select {
+	case wp.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	key := sessionKey(msg)
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	sq := wp.sessions[key]
+	if sq == nil {
+		sq = &sessionQueue{}
+		wp.sessions[key] = sq
+	}
+	if len(sq.msgs) >= wp.sessionLimit {
+		<-wp.slots
+		wp.rejected++
+		return ErrSessionQueueFull
+	}
+
+	sq.msgs = append(sq.msgs, msg)
+	wp.queued++
+	if !sq.scheduled {
+		sq.scheduled = true
+		wp.ready = append(wp.ready, key)
+		wp.cond.Signal()
+	}
+	return nil
+}
+
+// stop 停止接收新的会话调度，工作协程处理完已提交的消息后退出
+func (wp *workerPool) stop() {
+	wp.mu.Lock()
+	wp.closed = true
+	wp.mu.Unlock()
+	wp.cond.Broadcast()
+}
+
+// wait 等待工作协程退出
+func (wp *workerPool) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats 返回工作池统计信息
+func (wp *workerPool) stats() WorkerStats {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return WorkerStats{
+		Workers:   wp.workers,
+		Busy:      wp.busy,
+		Sessions:  len(wp.sessions),
+		Queued:    wp.queued,
+		Processed: wp.processed,
+		Rejected:  wp.rejected,
+	}
+}
+
+// run 工作协程：每次从就绪队列取一个会话，处理其队首消息
+// 处理完成后若该会话仍有消息则重新排到就绪队列末尾，避免单个会话占用工作协程
+func (wp *workerPool) run(ctx context.Context) {
+	defer wp.wg.Done()
+
+	for {
+		wp.mu.Lock()
+		for len(wp.ready) == 0 && !wp.closed {
+			wp.cond.Wait()
+		}
+		if len(wp.ready) == 0 {
+			wp.mu.Unlock()
+			return
+		}
+
+		key := wp.ready[0]
+		wp.ready = wp.ready[1:]
+		sq := wp.sessions[key]
+		msg := sq.msgs[0]
+		sq.msgs[0] = nil
+		sq.msgs = sq.msgs[1:]
+		wp.queued--
+		wp.busy++
+		wp.mu.Unlock()
+		<-wp.slots
+
+		wp.handle(ctx, msg)
+
+		wp.mu.Lock()
+		wp.busy--
+		wp.processed++
+		if len(sq.msgs) > 0 {
+			wp.ready = append(wp.ready, key)
+			wp.cond.Signal()
+		} else {
+			delete(wp.sessions, key)
+		}
+		wp.mu.Unlock()
+	}
+}
+
+// sessionKey 工作池中串行处理的会话键
+func sessionKey(msg *message.Message) string {
+	return msg.Platform + ":" + msg.SessionID
+}
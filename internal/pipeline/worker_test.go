@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xia_adpter/internal/agent"
+	"xia_adpter/internal/message"
+)
+
+func TestWorkerPoolKeepsSessionOrder(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	pool := newWorkerPool(4, 100, 100, func(ctx context.Context, msg *message.Message) {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		got[msg.SessionID] = append(got[msg.SessionID], msg.Content)
+		mu.Unlock()
+	})
+	pool.start(context.Background())
+
+	for i := 0; i < 20; i++ {
+		for _, session := range []string{"a", "b", "c"} {
+			msg := message.NewTextMessage("lark", session, "u1", fmt.Sprint(i))
+			if err := pool.submit(context.Background(), msg); err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+		}
+	}
+	pool.stop()
+	if err := pool.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range []string{"a", "b", "c"} {
+		if len(got[session]) != 20 {
+			t.Fatalf("session %s processed %d messages, want 20", session, len(got[session]))
+		}
+		for i, content := range got[session] {
+			if content != fmt.Sprint(i) {
+				t.Fatalf("session %s order = %v", session, got[session])
+			}
+		}
+	}
+}
+
+// blockingPool 返回单工作协程的工作池，处理消息时阻塞直到 release 关闭
+func blockingPool(sessionLimit, maxPending int) (*workerPool, chan struct{}, chan struct{}) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	pool := newWorkerPool(1, sessionLimit, maxPending, func(ctx context.Context, msg *message.Message) {
+		started <- struct{}{}
+		<-release
+	})
+	pool.start(context.Background())
+	return pool, started, release
+}
+
+func TestWorkerPoolBlocksWhenPendingLimitReached(t *testing.T) {
+	pool, started, release := blockingPool(10, 1)
+	defer close(release)
+
+	ctx := context.Background()
+	pool.submit(ctx, message.NewTextMessage("lark", "a", "u1", "1"))
+	<-started // 第一条开始处理，释放空位
+	if err := pool.submit(ctx, message.NewTextMessage("lark", "b", "u1", "2")); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := pool.submit(timeout, message.NewTextMessage("lark", "c", "u1", "3")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("submit over pending limit: err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestDispatchDeadLettersSessionOverflow(t *testing.T) {
+	pool, started, release := blockingPool(1, 10)
+	defer close(release)
+
+	p := newTestPipeline()
+	queue := message.NewQueue(10)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := p.dispatch(ctx, pool, queue, message.NewTextMessage("lark", "a", "u1", fmt.Sprint(i))); err != nil {
+			t.Fatalf("dispatch %d: %v", i, err)
+		}
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// 第一条处理中，第二条排队，第三条超出会话上限
+	deadLetters := queue.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Message.Content != "2" {
+		t.Fatalf("dead letters = %+v", deadLetters)
+	}
+	if stats := queue.Stats(); stats.Acked != 0 {
+		t.Errorf("rejected message acked: %+v", stats)
+	}
+	if stats := pool.stats(); stats.Rejected != 1 || stats.Queued != 1 {
+		t.Errorf("pool stats = %+v", stats)
+	}
+}
+
+// panicAgent 收到 "boom" 时 panic 的 Agent
+type panicAgent struct{ namedAgent }
+
+func (a panicAgent) Chat(ctx context.Context, req *message.AgentRequest) (*message.AgentResponse, error) {
+	if req.Query == "boom" {
+		panic("agent exploded")
+	}
+	return a.namedAgent.Chat(ctx, req)
+}
+
+var _ agent.Agent = panicAgent{}
+
+func TestStartRecoversPanics(t *testing.T) {
+	p := newTestPipeline()
+	p.cfg.Pipeline.Workers = 1
+	if err := p.agents.Register(panicAgent{"dify"}); err != nil {
+		t.Fatal(err)
+	}
+	queue := message.NewQueue(10)
+	queue.Push(message.NewTextMessage("lark", "a", "u1", "boom"))
+	queue.Push(message.NewTextMessage("lark", "a", "u1", "hello"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Start(ctx, queue)
+	}()
+
+	// 唯一的工作协程 panic 后仍继续处理同一会话的下一条消息
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Stats().Acked < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if err := p.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters := queue.DeadLetters()
+	if len(deadLetters) != 1 || deadLetters[0].Message.Content != "boom" || !strings.HasPrefix(deadLetters[0].Reason, "panic: agent exploded") {
+		t.Fatalf("dead letters = %+v", deadLetters)
+	}
+	if stats := queue.Stats(); stats.Acked != 1 {
+		t.Errorf("acked = %d, want only the message after the panic", stats.Acked)
+	}
+}
@@ -0,0 +1,87 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"xia_adpter/internal/message"
+)
+
+// maxDownloadSize 下载图片、文件的大小上限（飞书文件上限 30MB）
+const maxDownloadSize = 30 * 1024 * 1024
+
+// downloadClient 下载以 URL 引用的图片、文件
+var downloadClient = &http.Client{
+	Timeout: 60 * time.Second,
+}
+
+// PartData 返回图片/文件片段的内容及文件名，片段只有 URL 时先下载
+func PartData(ctx context.Context, part message.Part) ([]byte, string, error) {
+	if len(part.Data) > 0 || part.URL == "" {
+		if len(part.Data) == 0 {
+			return nil, "", fmt.Errorf("%s part has no data", part.Type)
+		}
+		return part.Data, part.Name, nil
+	}
+
+	data, name, err := Download(ctx, part.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	if part.Name != "" {
+		name = part.Name
+	}
+	return data, name, nil
+}
+
+// Download 下载 URL 内容，返回内容及推断的文件名
+func Download(ctx context.Context, rawURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download: status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxDownloadSize {
+		return nil, "", fmt.Errorf("download too large: %d bytes", resp.ContentLength)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read download: %w", err)
+	}
+	if len(data) > maxDownloadSize {
+		return nil, "", fmt.Errorf("download too large: more than %d bytes", maxDownloadSize)
+	}
+
+	return data, downloadName(resp, rawURL), nil
+}
+
+// downloadName 优先使用 Content-Disposition 中的文件名，其次取 URL 路径的最后一段
+func downloadName(resp *http.Response, rawURL string) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := params["filename"]; name != "" {
+			return name
+		}
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "" && name != "/" && name != "." {
+			return name
+		}
+	}
+	return "file"
+}
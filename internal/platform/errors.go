@@ -0,0 +1,39 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// SendError 平台接口返回的发送错误
+type SendError struct {
+	Op        string // 出错的操作，如 failed to send message
+	Code      int    // 平台错误码，HTTP 错误时为状态码
+	Msg       string // 平台错误信息
+	Retryable bool   // 稍后重试可能成功，如限流、系统繁忙、服务端错误
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%s: code=%d, msg=%s", e.Op, e.Code, e.Msg)
+}
+
+// IsRetryable 判断发送失败后是否值得重试
+// 平台返回的错误按错误码分类，网络错误和超时可以重试，其余错误（如内容不合法、片段类型不支持）重试也不会成功
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.Retryable
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
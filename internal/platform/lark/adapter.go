@@ -1,38 +1,43 @@
 package lark
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
 	"xia_adpter/internal/config"
+	"xia_adpter/internal/dedup"
 	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
 
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkdispatcher "github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
-	larkdispatcher "github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
 	"go.uber.org/zap"
 )
 
 // Adapter 飞书适配器
 type Adapter struct {
-	cfg      config.LarkConfig
-	queue    *message.Queue
-	logger   *zap.Logger
-	client   *lark.Client
-	wsClient *larkws.Client
-	botName  string
-	mu       sync.RWMutex
-	running  bool
-	ctx      context.Context
-	cancel   context.CancelFunc
+	cfg       config.LarkConfig
+	queue     *message.Queue
+	logger    *zap.Logger
+	client    *lark.Client
+	wsClient  *larkws.Client
+	botName   string
+	botOpenID string // 机器人的 open_id，启动时获取，用于识别 @机器人
+	events    dedup.Store
+	mu        sync.RWMutex
+	running   bool
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 // NewAdapter 创建新的飞书适配器
@@ -61,9 +66,49 @@ func NewAdapter(cfg config.LarkConfig, queue *message.Queue, logger *zap.Logger)
 		logger:  logger,
 		client:  client,
 		botName: botName,
+		events:  dedup.NewMemoryStore(0),
+	}
+}
+
+// SetDedupStore 设置入站事件去重存储，默认使用内存存储
+func (a *Adapter) SetDedupStore(store dedup.Store) {
+	a.events = store
+}
+
+// eventKey 返回消息事件的去重键，优先使用消息 ID，其次使用事件 ID
+func eventKey(event *larkim.P2MessageReceiveV1) string {
+	if msg := event.Event.Message; msg != nil && msg.MessageId != nil && *msg.MessageId != "" {
+		return dedup.Key(message.PlatformLark, *msg.MessageId)
+	}
+	if event.EventV2Base != nil && event.EventV2Base.Header != nil && event.EventV2Base.Header.EventID != "" {
+		return dedup.Key(message.PlatformLark, event.EventV2Base.Header.EventID)
+	}
+	return ""
+}
+
+// Name 返回平台标识
+func (a *Adapter) Name() string {
+	return message.PlatformLark
+}
+
+// Capabilities 返回飞书适配器支持的能力
+func (a *Adapter) Capabilities() platform.Capabilities {
+	return platform.Capabilities{
+		Images:  true,
+		Files:   true,
+		Cards:   true,
+		Edits:   true,
+		Threads: true,
 	}
 }
 
+// Running 适配器是否在运行
+func (a *Adapter) Running() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.running
+}
+
 // Start 启动适配器
 func (a *Adapter) Start(ctx context.Context) error {
 	a.mu.Lock()
@@ -81,9 +126,12 @@ func (a *Adapter) Start(ctx context.Context) error {
 		zap.String("bot_name", a.botName),
 	)
 
+	// 获取机器人 open_id，用于群聊中识别 @机器人
+	a.loadBotInfo(a.ctx)
+
 	// 创建事件分发器（按照官方示例）
 	eventDispatcher := larkdispatcher.NewEventDispatcher("", "")
-	
+
 	// 注册消息接收事件处理器（使用 P2 版本）
 	// 注意：WebSocket 长连接使用 P2 版本事件，事件类型为 "im.message.receive_v1"
 	eventDispatcher.OnP2MessageReceiveV1(func(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
@@ -160,9 +208,20 @@ func (a *Adapter) handleMessageEvent(ctx context.Context, event *larkim.P2Messag
 		return nil
 	}
 
+	// 重连后重推的消息只处理一次
+	key := eventKey(event)
+	if dedup.Check(a.events, key, a.logger) {
+		a.logger.Info("Ignored duplicate Lark message", zap.String("key", key))
+		return nil
+	}
+
 	msg := data.Message
 	sender := data.Sender
 
+	// 下载资源、查询群名等接口调用不能无限等待，适配器停止时一并取消
+	ctx, cancel := a.inboundContext(ctx)
+	defer cancel()
+
 	// 获取会话 ID
 	sessionID := ""
 	if msg.ChatId != nil {
@@ -187,15 +246,43 @@ func (a *Adapter) handleMessageEvent(ctx context.Context, event *larkim.P2Messag
 	}
 
 	// 获取消息类型
-	messageType := "text"
+	larkType := "text"
 	if msg.MessageType != nil {
-		messageType = a.getMessageType(*msg.MessageType)
+		larkType = *msg.MessageType
 	}
+	messageType := a.getMessageType(larkType)
 
-	// 提取消息内容
+	// 提取消息内容，表情、名片、合并转发等转换为文本
 	content := ""
 	if msg.Content != nil {
-		content = a.extractTextContentFromP2Message(*msg.Content, messageType)
+		messageID := ""
+		if msg.MessageId != nil {
+			messageID = *msg.MessageId
+		}
+		content = a.describeContent(ctx, larkType, *msg.Content, messageID)
+	}
+
+	// 处理 @：移除 @机器人，其他用户替换为姓名
+	mentioned := false
+	if larkType == "text" || larkType == "post" {
+		content, mentioned = a.stripMentions(content, msg.Mentions)
+	}
+
+	// 群聊按触发方式过滤（默认仅响应 @机器人）
+	if msg.ChatType != nil && *msg.ChatType == "group" {
+		chatID := ""
+		if msg.ChatId != nil {
+			chatID = *msg.ChatId
+		}
+		accepted, ok := a.acceptGroupMessage(chatID, content, mentioned)
+		if !ok {
+			a.logger.Debug("Ignored Lark group message",
+				zap.String("chat_id", chatID),
+				zap.String("type", larkType),
+			)
+			return nil
+		}
+		content = accepted
 	}
 
 	// 构建统一消息格式
@@ -225,7 +312,7 @@ func (a *Adapter) handleMessageEvent(ctx context.Context, event *larkim.P2Messag
 		if imageKey := a.extractImageKeyFromContent(*msg.Content); imageKey != "" {
 			msgObj.Metadata["image_key"] = imageKey
 			// 下载图片并转换为 base64
-			if imageData, err := a.downloadImage(*msg.MessageId, imageKey); err == nil {
+			if imageData, err := a.downloadImage(ctx, *msg.MessageId, imageKey); err == nil {
 				msgObj.Content = base64.StdEncoding.EncodeToString(imageData)
 			} else {
 				a.logger.Warn("Failed to download image", zap.Error(err))
@@ -233,6 +320,20 @@ func (a *Adapter) handleMessageEvent(ctx context.Context, event *larkim.P2Messag
 		}
 	}
 
+	// 富文本消息下载嵌入的图片，记录 @ 的用户
+	if larkType == "post" && msg.Content != nil && msg.MessageId != nil {
+		post := parsePost(*msg.Content)
+		msgObj.Images = a.downloadPostImages(ctx, *msg.MessageId, post.ImageKeys)
+		if mentions := resolveMentions(post.Mentions, msg.Mentions); mentions != "" {
+			msgObj.Metadata["mentions"] = mentions
+		}
+	}
+
+	// 文件、语音、视频消息记录资源信息，由管道下载（语音识别为文本）
+	if msg.Content != nil {
+		resourceMetadata(larkType, *msg.Content, msgObj.Metadata)
+	}
+
 	a.logger.Info("Received Lark message",
 		zap.String("message_id", func() string {
 			if msg.MessageId != nil {
@@ -252,7 +353,17 @@ func (a *Adapter) handleMessageEvent(ctx context.Context, event *larkim.P2Messag
 	)
 
 	// 推送到消息队列
-	a.queue.Push(msgObj)
+	if err := a.queue.Push(msgObj); err != nil {
+		a.logger.Error("Failed to push Lark message to queue",
+			zap.String("session_id", msgObj.SessionID),
+			zap.Error(err),
+		)
+		// 入队失败时允许重推的消息重新处理
+		if key != "" {
+			a.events.Forget(key)
+		}
+		return err
+	}
 	return nil
 }
 
@@ -266,15 +377,15 @@ func (a *Adapter) extractTextContentFromP2Message(contentJSON string, messageTyp
 
 	switch messageType {
 	case "text":
+		// @ 占位符由调用方按 mentions 处理
 		if text, ok := contentMap["text"].(string); ok {
-			text = a.removeAtMentions(text)
 			return strings.TrimSpace(text)
 		}
 	case "post":
-		// 处理富文本消息
-		return a.extractTextContent(contentMap, "post")
-	case "image":
-		// 图片消息，返回空字符串，后续会下载图片
+		// 处理富文本消息，链接、代码块等保留为 Markdown
+		return parsePost(contentJSON).Text
+	case "image", "file", "audio", "media":
+		// 图片、文件等资源消息，返回空字符串，后续会下载资源
 		return ""
 	default:
 		// 尝试提取文本
@@ -300,48 +411,6 @@ func (a *Adapter) extractImageKeyFromContent(contentJSON string) string {
 	return ""
 }
 
-
-// extractTextContent 提取文本内容
-func (a *Adapter) extractTextContent(contentJSON map[string]interface{}, msgType string) string {
-	switch msgType {
-	case "text":
-		if text, ok := contentJSON["text"].(string); ok {
-			// 移除 @ 用户标记
-			text = a.removeAtMentions(text)
-			return strings.TrimSpace(text)
-		}
-	case "post":
-		// 处理富文本消息
-		if content, ok := contentJSON["content"].([]interface{}); ok {
-			var textParts []string
-			for _, item := range content {
-				if items, ok := item.([]interface{}); ok {
-					for _, subItem := range items {
-						if subItemMap, ok := subItem.(map[string]interface{}); ok {
-							if tag, ok := subItemMap["tag"].(string); ok {
-								if tag == "text" {
-									if text, ok := subItemMap["text"].(string); ok {
-										textParts = append(textParts, strings.TrimSpace(text))
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-			return strings.Join(textParts, "\n")
-		}
-	}
-	return ""
-}
-
-// removeAtMentions 移除 @ 用户标记
-func (a *Adapter) removeAtMentions(text string) string {
-	// 移除 @_user_xxx 格式的标记
-	re := strings.NewReplacer("@_user_", "")
-	return re.Replace(text)
-}
-
 // getMessageType 获取消息类型
 func (a *Adapter) getMessageType(larkType string) string {
 	switch larkType {
@@ -349,181 +418,67 @@ func (a *Adapter) getMessageType(larkType string) string {
 		return "text"
 	case "image":
 		return "image"
+	case "audio":
+		return "voice"
+	case "media":
+		return "video"
+	case "file":
+		return "file"
 	default:
+		// 表情、名片、合并转发等已转换为文本
 		return "text"
 	}
 }
 
 // downloadImage 下载图片
-func (a *Adapter) downloadImage(messageID, imageKey string) ([]byte, error) {
-	// 调用飞书 API 下载图片
-	req := larkim.NewGetMessageResourceReqBuilder().
-		MessageId(messageID).
-		FileKey(imageKey).
-		Type("image").
-		Build()
-
-	resp, err := a.client.Im.V1.MessageResource.Get(context.Background(), req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get image resource: %w", err)
-	}
-
-	if !resp.Success() {
-		return nil, fmt.Errorf("failed to get image resource: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-
-	// 读取图片数据
-	imageData, err := io.ReadAll(resp.File)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image data: %w", err)
-	}
-
-	return imageData, nil
+func (a *Adapter) downloadImage(ctx context.Context, messageID, imageKey string) ([]byte, error) {
+	return a.downloadResource(ctx, messageID, imageKey, "image")
 }
 
-// SendMessage 发送消息
-func (a *Adapter) SendMessage(sessionID string, content string) error {
-	return a.sendTextMessage(sessionID, content)
-}
+// inboundContext 返回处理入站消息时调用飞书接口（下载图片、展开合并转发等）的上下文，
+// 超过 inboundFetchTimeout 或适配器停止时取消
+func (a *Adapter) inboundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, inboundFetchTimeout)
 
-// sendTextMessage 发送文本消息
-func (a *Adapter) sendTextMessage(sessionID string, content string) error {
-	// 判断是群聊还是私聊
-	receiveIDType := larkim.ReceiveIdTypeOpenId
-	if strings.Contains(sessionID, "%") {
-		// 群聊：sessionID 格式可能是 "user_id%chat_id"
-		parts := strings.Split(sessionID, "%")
-		if len(parts) > 1 {
-			sessionID = parts[1]
-			receiveIDType = larkim.ReceiveIdTypeChatId
-		}
-	} else {
-		// 检查是否是 chat_id（通常 chat_id 更长）
-		if len(sessionID) > 20 {
-			receiveIDType = larkim.ReceiveIdTypeChatId
-		}
+	a.mu.RLock()
+	base := a.ctx
+	a.mu.RUnlock()
+	if base == nil {
+		return ctx, cancel
 	}
-
-	// 构建消息内容（使用富文本格式）
-	messageContent := map[string]interface{}{
-		"zh_cn": map[string]interface{}{
-			"title": "",
-			"content": [][]map[string]interface{}{
-				{
-					{
-						"tag":  "text",
-						"text": content,
-					},
-				},
-			},
-		},
+	stop := context.AfterFunc(base, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
 	}
-
-	contentJSON, err := json.Marshal(messageContent)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message content: %w", err)
-	}
-
-	// 创建消息请求
-	req := larkim.NewCreateMessageReqBuilder().
-		ReceiveIdType(receiveIDType).
-		Body(larkim.NewCreateMessageReqBodyBuilder().
-			ReceiveId(sessionID).
-			Content(string(contentJSON)).
-			MsgType("post").
-			Uuid(fmt.Sprintf("%d", time.Now().UnixNano())).
-			Build()).
-		Build()
-
-	// 发送消息
-	resp, err := a.client.Im.V1.Message.Create(context.Background(), req)
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
-	}
-
-	if !resp.Success() {
-		return fmt.Errorf("failed to send message: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-
-	messageID := ""
-	if resp.Data.MessageId != nil {
-		messageID = *resp.Data.MessageId
-	}
-	a.logger.Debug("Sent message to Lark",
-		zap.String("session_id", sessionID),
-		zap.String("receive_id_type", string(receiveIDType)),
-		zap.String("message_id", messageID),
-	)
-
-	return nil
 }
 
-// SendImageMessage 发送图片消息
-func (a *Adapter) SendImageMessage(sessionID string, imageData []byte) error {
-	// 先上传图片
-	imageKey, err := a.uploadImage(imageData)
-	if err != nil {
-		return fmt.Errorf("failed to upload image: %w", err)
+// receiveTarget 确定接收者 ID 及其类型
+// 优先使用入站消息记录的 chat_id（群聊和单聊都有），其次是提问者的 open_id（入站消息优先记录 open_id），
+// 都没有时（如通过 API 主动发送）才按 sessionID 的格式和前缀判断
+func receiveTarget(sessionID string, metadata map[string]string) (string, string) {
+	if chatID := metadata[message.MetadataChatID]; chatID != "" {
+		return chatID, larkim.ReceiveIdTypeChatId
 	}
-
-	// 判断是群聊还是私聊
-	receiveIDType := larkim.ReceiveIdTypeOpenId
-	if strings.Contains(sessionID, "%") {
-		parts := strings.Split(sessionID, "%")
-		if len(parts) > 1 {
-			sessionID = parts[1]
-			receiveIDType = larkim.ReceiveIdTypeChatId
-		}
-	} else if len(sessionID) > 20 {
-		receiveIDType = larkim.ReceiveIdTypeChatId
-	}
-
-	// 构建消息内容
-	messageContent := map[string]interface{}{
-		"zh_cn": map[string]interface{}{
-			"title": "",
-			"content": [][]map[string]interface{}{
-				{
-					{
-						"tag":       "img",
-						"image_key": imageKey,
-					},
-				},
-			},
-		},
-	}
-
-	contentJSON, err := json.Marshal(messageContent)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message content: %w", err)
+	if senderID := metadata[message.MetadataSenderID]; senderID != "" {
+		return senderID, larkim.ReceiveIdTypeOpenId
 	}
 
-	// 创建消息请求
-	req := larkim.NewCreateMessageReqBuilder().
-		ReceiveIdType(receiveIDType).
-		Body(larkim.NewCreateMessageReqBodyBuilder().
-			ReceiveId(sessionID).
-			Content(string(contentJSON)).
-			MsgType("post").
-			Uuid(fmt.Sprintf("%d", time.Now().UnixNano())).
-			Build()).
-		Build()
-
-	// 发送消息
-	resp, err := a.client.Im.V1.Message.Create(context.Background(), req)
-	if err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+	// 兼容 "user_id%chat_id" 格式
+	if i := strings.LastIndex(sessionID, "%"); i >= 0 {
+		return sessionID[i+1:], larkim.ReceiveIdTypeChatId
 	}
-
-	if !resp.Success() {
-		return fmt.Errorf("failed to send message: code=%d, msg=%s", resp.Code, resp.Msg)
+	switch {
+	case strings.HasPrefix(sessionID, "oc_"):
+		return sessionID, larkim.ReceiveIdTypeChatId
+	case strings.HasPrefix(sessionID, "on_"):
+		return sessionID, larkim.ReceiveIdTypeUnionId
 	}
-
-	return nil
+	return sessionID, larkim.ReceiveIdTypeOpenId
 }
 
 // uploadImage 上传图片
-func (a *Adapter) uploadImage(imageData []byte) (string, error) {
+func (a *Adapter) uploadImage(ctx context.Context, imageData []byte) (string, error) {
 	// 调用飞书 API 上传图片
 	req := larkim.NewCreateImageReqBuilder().
 		Body(larkim.NewCreateImageReqBodyBuilder().
@@ -532,7 +487,7 @@ func (a *Adapter) uploadImage(imageData []byte) (string, error) {
 			Build()).
 		Build()
 
-	resp, err := a.client.Im.V1.Image.Create(context.Background(), req)
+	resp, err := a.client.Im.V1.Image.Create(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image: %w", err)
 	}
@@ -547,3 +502,45 @@ func (a *Adapter) uploadImage(imageData []byte) (string, error) {
 
 	return *resp.Data.ImageKey, nil
 }
+
+// uploadFile 上传文件，按扩展名选择飞书的文件类型
+func (a *Adapter) uploadFile(ctx context.Context, fileName string, fileData []byte) (string, error) {
+	fileType := larkim.FileTypeStream
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".pdf":
+		fileType = larkim.FileTypePdf
+	case ".doc", ".docx":
+		fileType = larkim.FileTypeDoc
+	case ".xls", ".xlsx":
+		fileType = larkim.FileTypeXls
+	case ".ppt", ".pptx":
+		fileType = larkim.FileTypePpt
+	case ".mp4":
+		fileType = larkim.FileTypeMp4
+	case ".opus":
+		fileType = larkim.FileTypeOpus
+	}
+
+	req := larkim.NewCreateFileReqBuilder().
+		Body(larkim.NewCreateFileReqBodyBuilder().
+			FileType(fileType).
+			FileName(fileName).
+			File(bytes.NewReader(fileData)).
+			Build()).
+		Build()
+
+	resp, err := a.client.Im.V1.File.Create(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	if !resp.Success() {
+		return "", fmt.Errorf("failed to upload file: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+
+	if resp.Data.FileKey == nil {
+		return "", fmt.Errorf("file key is nil")
+	}
+
+	return *resp.Data.FileKey, nil
+}
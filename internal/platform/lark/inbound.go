@@ -0,0 +1,230 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+// inboundFetchTimeout 处理一条入站消息时调用飞书接口的总时长上限
+const inboundFetchTimeout = 30 * time.Second
+
+// resourceContent 文件、音频、视频消息的 content
+type resourceContent struct {
+	FileKey  string `json:"file_key"`
+	FileName string `json:"file_name"`
+	ImageKey string `json:"image_key"` // 视频封面
+	Duration int    `json:"duration"`  // 音视频时长（毫秒）
+}
+
+// resourceMetadata 将文件、音频、视频消息的资源信息写入元数据，由管道通过 DownloadMedia 下载
+func resourceMetadata(larkType, contentJSON string, metadata map[string]string) {
+	switch larkType {
+	case "file", "audio", "media":
+	default:
+		return
+	}
+
+	var res resourceContent
+	if err := json.Unmarshal([]byte(contentJSON), &res); err != nil || res.FileKey == "" {
+		return
+	}
+	metadata["file_key"] = res.FileKey
+	if res.FileName != "" {
+		metadata["file_name"] = res.FileName
+	}
+	if res.Duration > 0 {
+		metadata["duration"] = fmt.Sprintf("%d", res.Duration)
+	}
+	if res.ImageKey != "" {
+		metadata["cover_image_key"] = res.ImageKey
+	}
+	if larkType == "audio" {
+		metadata["format"] = "opus"
+	}
+}
+
+// describeContent 将表情、群名片、个人名片、合并转发等没有文本的消息转换为 Agent 可理解的文本
+// 其余类型按 extractTextContentFromP2Message 提取
+func (a *Adapter) describeContent(ctx context.Context, larkType, contentJSON, messageID string) string {
+	var content map[string]interface{}
+	_ = json.Unmarshal([]byte(contentJSON), &content)
+
+	switch larkType {
+	case "sticker":
+		return "[表情]"
+	case "share_chat":
+		chatID, _ := content["chat_id"].(string)
+		if name := a.chatName(ctx, chatID); name != "" {
+			return fmt.Sprintf("[群名片] %s", name)
+		}
+		return "[群名片]"
+	case "share_user":
+		userID, _ := content["user_id"].(string)
+		if name := a.userName(ctx, userID); name != "" {
+			return fmt.Sprintf("[个人名片] %s", name)
+		}
+		return "[个人名片]"
+	case "merge_forward":
+		text, err := a.expandMergeForward(ctx, messageID)
+		if err != nil {
+			a.logger.Warn("Failed to expand merge_forward message",
+				zap.String("message_id", messageID),
+				zap.Error(err),
+			)
+			return "[合并转发的聊天记录]"
+		}
+		return text
+	}
+	return a.extractTextContentFromP2Message(contentJSON, larkType)
+}
+
+// expandMergeForward 获取合并转发消息中的子消息，展开为按发送者标注的文本
+// 子消息可以再次包含合并转发，按 upper_message_id 逐层缩进展开
+func (a *Adapter) expandMergeForward(ctx context.Context, messageID string) (string, error) {
+	if messageID == "" {
+		return "", fmt.Errorf("message id is empty")
+	}
+
+	req := larkim.NewGetMessageReqBuilder().MessageId(messageID).Build()
+	resp, err := a.client.Im.V1.Message.Get(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get message: %w", err)
+	}
+	if !resp.Success() {
+		return "", fmt.Errorf("failed to get message: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	if resp.Data == nil {
+		return "", fmt.Errorf("message not found")
+	}
+
+	children := make(map[string][]*larkim.Message)
+	for _, item := range resp.Data.Items {
+		if item.UpperMessageId != nil && *item.UpperMessageId != "" {
+			children[*item.UpperMessageId] = append(children[*item.UpperMessageId], item)
+		}
+	}
+	for _, items := range children {
+		sort.SliceStable(items, func(i, j int) bool {
+			return strValue(items[i].CreateTime) < strValue(items[j].CreateTime)
+		})
+	}
+
+	// 用户以"用户1"、"用户2"区分，避免为取姓名申请通讯录权限
+	labels := make(map[string]string)
+	label := func(sender *larkim.Sender) string {
+		if sender == nil || sender.Id == nil {
+			return "未知"
+		}
+		if strValue(sender.SenderType) == "app" {
+			return "机器人"
+		}
+		if l, ok := labels[*sender.Id]; ok {
+			return l
+		}
+		l := fmt.Sprintf("用户%d", len(labels)+1)
+		labels[*sender.Id] = l
+		return l
+	}
+
+	if len(children[messageID]) == 0 {
+		return "", fmt.Errorf("merge_forward message has no items")
+	}
+
+	var b strings.Builder
+	b.WriteString("[合并转发的聊天记录]")
+	var walk func(parentID string, depth int)
+	walk = func(parentID string, depth int) {
+		for _, item := range children[parentID] {
+			msgType := strValue(item.MsgType)
+			body := ""
+			if item.Body != nil {
+				body = strValue(item.Body.Content)
+			}
+
+			text := replaceMentionKeys(a.summarizeContent(msgType, body), item.Mentions)
+			fmt.Fprintf(&b, "\n%s%s：%s", strings.Repeat("  ", depth), label(item.Sender), text)
+			if msgType == "merge_forward" {
+				walk(strValue(item.MessageId), depth+1)
+			}
+		}
+	}
+	walk(messageID, 0)
+	return b.String(), nil
+}
+
+// summarizeContent 将合并转发中的子消息转换为一行文本，资源类消息以占位文本表示
+func (a *Adapter) summarizeContent(larkType, contentJSON string) string {
+	var res resourceContent
+	_ = json.Unmarshal([]byte(contentJSON), &res)
+
+	switch larkType {
+	case "text", "post":
+		text := a.extractTextContentFromP2Message(contentJSON, larkType)
+		return strings.ReplaceAll(text, "\n", " ")
+	case "image":
+		return "[图片]"
+	case "file":
+		return strings.TrimSpace("[文件] " + res.FileName)
+	case "media":
+		return strings.TrimSpace("[视频] " + res.FileName)
+	case "audio":
+		return "[语音]"
+	case "sticker":
+		return "[表情]"
+	case "share_chat":
+		return "[群名片]"
+	case "share_user":
+		return "[个人名片]"
+	case "interactive":
+		return "[卡片]"
+	case "merge_forward":
+		return "[合并转发的聊天记录]"
+	}
+	return fmt.Sprintf("[%s]", larkType)
+}
+
+// chatName 获取群名称，失败时返回空字符串
+func (a *Adapter) chatName(ctx context.Context, chatID string) string {
+	if chatID == "" {
+		return ""
+	}
+	resp, err := a.client.Im.V1.Chat.Get(ctx, larkim.NewGetChatReqBuilder().ChatId(chatID).Build())
+	if err != nil || !resp.Success() || resp.Data == nil {
+		a.logger.Debug("Failed to get chat info", zap.String("chat_id", chatID), zap.Error(err))
+		return ""
+	}
+	return strValue(resp.Data.Name)
+}
+
+// userName 获取用户姓名（需要通讯录权限），失败时返回空字符串
+func (a *Adapter) userName(ctx context.Context, openID string) string {
+	if openID == "" {
+		return ""
+	}
+	req := larkcontact.NewGetUserReqBuilder().
+		UserId(openID).
+		UserIdType("open_id").
+		Build()
+	resp, err := a.client.Contact.V3.User.Get(ctx, req)
+	if err != nil || !resp.Success() || resp.Data == nil || resp.Data.User == nil {
+		a.logger.Debug("Failed to get user info", zap.String("user_id", openID), zap.Error(err))
+		return ""
+	}
+	return strValue(resp.Data.User.Name)
+}
+
+// strValue 返回字符串指针的值，nil 时返回空字符串
+func strValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
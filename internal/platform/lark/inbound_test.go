@@ -0,0 +1,229 @@
+package lark
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// mergeForwardItems 合并转发消息 om_mf 的子消息，c3 是嵌套的合并转发
+const mergeForwardItems = `{"code":0,"msg":"success","data":{"items":[
+	{"message_id":"om_mf","msg_type":"merge_forward","body":{"content":"Merged and Forwarded Message"}},
+	{"message_id":"c1","upper_message_id":"om_mf","msg_type":"text","create_time":"2","sender":{"id":"ou_a","sender_type":"user"},"body":{"content":"{\"text\":\"第二\\n行\"}"}},
+	{"message_id":"c0","upper_message_id":"om_mf","msg_type":"text","create_time":"1","sender":{"id":"ou_b","sender_type":"user"},"body":{"content":"{\"text\":\"第一\"}"}},
+	{"message_id":"c2","upper_message_id":"om_mf","msg_type":"file","create_time":"3","sender":{"id":"ou_a","sender_type":"user"},"body":{"content":"{\"file_key\":\"f1\",\"file_name\":\"a.pdf\"}"}},
+	{"message_id":"c3","upper_message_id":"om_mf","msg_type":"merge_forward","create_time":"4","sender":{"id":"cli_bot","sender_type":"app"},"body":{"content":"Merged and Forwarded Message"}},
+	{"message_id":"c4","upper_message_id":"c3","msg_type":"text","create_time":"5","sender":{"id":"ou_b","sender_type":"user"},"body":{"content":"{\"text\":\"嵌套\"}"}}
+]}}`
+
+// inboundLark 按路径返回群信息、用户信息和合并转发的子消息，其余查询返回权限错误
+func inboundLark(req larkRequest) (int, string) {
+	switch {
+	case strings.HasSuffix(req.Path, "/im/v1/chats/oc_named"):
+		return http.StatusOK, `{"code":0,"data":{"name":"产品群"}}`
+	case strings.HasSuffix(req.Path, "/contact/v3/users/ou_named"):
+		return http.StatusOK, `{"code":0,"data":{"user":{"name":"张三"}}}`
+	case strings.HasSuffix(req.Path, "/im/v1/messages/om_mf"):
+		return http.StatusOK, mergeForwardItems
+	case strings.Contains(req.Path, "/resources/"):
+		return http.StatusOK, "image-bytes"
+	}
+	return http.StatusBadRequest, `{"code":230027,"msg":"Lack of necessary permissions"}`
+}
+
+func TestDescribeContent(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	f.reply = inboundLark
+
+	tests := []struct {
+		larkType  string
+		content   string
+		messageID string
+		want      string
+	}{
+		{larkType: "text", content: `{"text":" 你好 "}`, want: "你好"},
+		{larkType: "post", content: `{"title":"","content":[[{"tag":"text","text":"富文本"}]]}`, want: "富文本"},
+		{larkType: "image", content: `{"image_key":"img_1"}`, want: ""},
+		{larkType: "file", content: `{"file_key":"f1","file_name":"a.pdf"}`, want: ""},
+		{larkType: "audio", content: `{"file_key":"f1","duration":3000}`, want: ""},
+		{larkType: "media", content: `{"file_key":"f1","image_key":"img_c"}`, want: ""},
+		{larkType: "sticker", content: `{"file_key":"s1"}`, want: "[表情]"},
+		{larkType: "share_chat", content: `{"chat_id":"oc_named"}`, want: "[群名片] 产品群"},
+		{larkType: "share_chat", content: `{"chat_id":"oc_secret"}`, want: "[群名片]"},
+		{larkType: "share_user", content: `{"user_id":"ou_named"}`, want: "[个人名片] 张三"},
+		{larkType: "share_user", content: `{"user_id":"ou_secret"}`, want: "[个人名片]"},
+		{larkType: "merge_forward", content: `{}`, messageID: "om_mf", want: "[合并转发的聊天记录]\n" +
+			"用户1：第一\n" +
+			"用户2：第二 行\n" +
+			"用户2：[文件] a.pdf\n" +
+			"机器人：[合并转发的聊天记录]\n" +
+			"  用户1：嵌套"},
+		{larkType: "merge_forward", content: `{}`, messageID: "om_gone", want: "[合并转发的聊天记录]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.larkType, func(t *testing.T) {
+			if got := a.describeContent(context.Background(), tt.larkType, tt.content, tt.messageID); got != tt.want {
+				t.Errorf("describeContent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeContent(t *testing.T) {
+	a, _ := newFakeLark(t, config.LarkConfig{})
+
+	tests := []struct {
+		larkType string
+		content  string
+		want     string
+	}{
+		{"text", `{"text":"多\n行"}`, "多 行"},
+		{"post", `{"title":"标题","content":[[{"tag":"text","text":"正文"}]]}`, "**标题** 正文"},
+		{"image", `{"image_key":"img_1"}`, "[图片]"},
+		{"file", `{"file_key":"f1","file_name":"a.pdf"}`, "[文件] a.pdf"},
+		{"file", `{"file_key":"f1"}`, "[文件]"},
+		{"media", `{"file_key":"f1","file_name":"v.mp4"}`, "[视频] v.mp4"},
+		{"audio", `{"file_key":"f1"}`, "[语音]"},
+		{"sticker", `{}`, "[表情]"},
+		{"share_chat", `{"chat_id":"oc_1"}`, "[群名片]"},
+		{"share_user", `{"user_id":"ou_1"}`, "[个人名片]"},
+		{"interactive", `{}`, "[卡片]"},
+		{"merge_forward", `{}`, "[合并转发的聊天记录]"},
+		{"todo", `{}`, "[todo]"},
+	}
+	for _, tt := range tests {
+		if got := a.summarizeContent(tt.larkType, tt.content); got != tt.want {
+			t.Errorf("summarizeContent(%s) = %q, want %q", tt.larkType, got, tt.want)
+		}
+	}
+}
+
+func TestResourceMetadata(t *testing.T) {
+	tests := []struct {
+		larkType string
+		content  string
+		want     map[string]string
+	}{
+		{"file", `{"file_key":"f1","file_name":"a.pdf"}`, map[string]string{"file_key": "f1", "file_name": "a.pdf"}},
+		{"audio", `{"file_key":"f2","duration":3000}`, map[string]string{"file_key": "f2", "duration": "3000", "format": "opus"}},
+		{"media", `{"file_key":"f3","file_name":"v.mp4","image_key":"img_c","duration":1200}`,
+			map[string]string{"file_key": "f3", "file_name": "v.mp4", "duration": "1200", "cover_image_key": "img_c"}},
+		{"image", `{"image_key":"img_1"}`, map[string]string{}},
+		{"sticker", `{"file_key":"s1"}`, map[string]string{}},
+		{"file", `{"file_name":"no-key.pdf"}`, map[string]string{}},
+		{"file", `not json`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		metadata := make(map[string]string)
+		resourceMetadata(tt.larkType, tt.content, metadata)
+		if !reflect.DeepEqual(metadata, tt.want) {
+			t.Errorf("resourceMetadata(%s, %s) = %v, want %v", tt.larkType, tt.content, metadata, tt.want)
+		}
+	}
+}
+
+// receiveEvent 构建单聊消息事件
+func receiveEvent(messageID, larkType, content string) *larkim.P2MessageReceiveV1 {
+	str := func(s string) *string { return &s }
+	return &larkim.P2MessageReceiveV1{Event: &larkim.P2MessageReceiveV1Data{
+		Sender: &larkim.EventSender{SenderId: &larkim.UserId{OpenId: str("ou_1")}},
+		Message: &larkim.EventMessage{
+			MessageId:   str(messageID),
+			ChatId:      str("oc_p2p"),
+			ChatType:    str("p2p"),
+			MessageType: str(larkType),
+			Content:     str(content),
+		},
+	}}
+}
+
+func TestHandleMessageEventTypes(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	f.reply = inboundLark
+	a.queue = message.NewQueue(20)
+
+	tests := []struct {
+		larkType string
+		content  string
+		msgType  string
+		want     string
+		metadata map[string]string
+	}{
+		{larkType: "image", content: `{"image_key":"img_1"}`, msgType: "image",
+			want: base64.StdEncoding.EncodeToString([]byte("image-bytes")), metadata: map[string]string{"image_key": "img_1"}},
+		{larkType: "file", content: `{"file_key":"f1","file_name":"a.pdf"}`, msgType: "file",
+			metadata: map[string]string{"file_key": "f1", "file_name": "a.pdf"}},
+		{larkType: "audio", content: `{"file_key":"f2","duration":3000}`, msgType: "voice",
+			metadata: map[string]string{"file_key": "f2", "format": "opus"}},
+		{larkType: "media", content: `{"file_key":"f3","image_key":"img_c"}`, msgType: "video",
+			metadata: map[string]string{"file_key": "f3", "cover_image_key": "img_c"}},
+		{larkType: "sticker", content: `{"file_key":"s1"}`, msgType: "text", want: "[表情]"},
+		{larkType: "share_chat", content: `{"chat_id":"oc_named"}`, msgType: "text", want: "[群名片] 产品群"},
+		{larkType: "share_user", content: `{"user_id":"ou_named"}`, msgType: "text", want: "[个人名片] 张三"},
+		{larkType: "merge_forward", content: `{}`, msgType: "text", want: "[合并转发的聊天记录]\n用户1：第一"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.larkType, func(t *testing.T) {
+			messageID := "om_" + tt.larkType
+			if tt.larkType == "merge_forward" {
+				messageID = "om_mf"
+			}
+			if err := a.handleMessageEvent(context.Background(), receiveEvent(messageID, tt.larkType, tt.content)); err != nil {
+				t.Fatalf("handleMessageEvent: %v", err)
+			}
+			msg, ok := a.queue.TryPop()
+			if !ok {
+				t.Fatalf("message %d not queued", i)
+			}
+			if msg.MessageType != tt.msgType || !strings.HasPrefix(msg.Content, tt.want) || (tt.want == "" && msg.Content != "") {
+				t.Errorf("message = %s %q, want %s %q", msg.MessageType, msg.Content, tt.msgType, tt.want)
+			}
+			for k, v := range tt.metadata {
+				if msg.Metadata[k] != v {
+					t.Errorf("metadata[%s] = %q, want %q", k, msg.Metadata[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessageEventStopsDownloadsOnShutdown(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	release := make(chan struct{})
+	defer close(release)
+	f.reply = func(req larkRequest) (int, string) {
+		<-release // 模拟没有响应的下载
+		return http.StatusOK, "late"
+	}
+	a.queue = message.NewQueue(1)
+
+	// 适配器已停止时，图片下载立即取消，不会阻塞事件处理
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.ctx = ctx
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.handleMessageEvent(context.Background(), receiveEvent("om_img", "image", `{"image_key":"img_1"}`))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handleMessageEvent: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("image download not cancelled on shutdown")
+	}
+	if msg, ok := a.queue.TryPop(); !ok || msg.Content != "" {
+		t.Errorf("queued message = %+v, %v", msg, ok)
+	}
+}
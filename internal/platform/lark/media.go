@@ -0,0 +1,56 @@
+package lark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"xia_adpter/internal/message"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// DownloadMedia 下载消息中的语音、视频、文件，语音消息的格式为 opus
+func (a *Adapter) DownloadMedia(ctx context.Context, msg *message.Message) (*message.MediaFile, error) {
+	messageID := msg.Metadata["message_id"]
+	fileKey := msg.Metadata["file_key"]
+	if messageID == "" || fileKey == "" {
+		return nil, fmt.Errorf("message has no message_id or file_key")
+	}
+
+	data, err := a.downloadResource(ctx, messageID, fileKey, "file")
+	if err != nil {
+		return nil, err
+	}
+	return &message.MediaFile{
+		Type:     msg.MessageType,
+		Name:     msg.Metadata["file_name"],
+		MimeType: http.DetectContentType(data),
+		Format:   msg.Metadata["format"],
+		Data:     data,
+	}, nil
+}
+
+// downloadResource 下载消息中的资源，resourceType 为 image 或 file（语音、视频、文件）
+func (a *Adapter) downloadResource(ctx context.Context, messageID, fileKey, resourceType string) ([]byte, error) {
+	req := larkim.NewGetMessageResourceReqBuilder().
+		MessageId(messageID).
+		FileKey(fileKey).
+		Type(resourceType).
+		Build()
+
+	resp, err := a.client.Im.V1.MessageResource.Get(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s resource: %w", resourceType, err)
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("failed to get %s resource: code=%d, msg=%s", resourceType, resp.Code, resp.Msg)
+	}
+
+	data, err := io.ReadAll(resp.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s data: %w", resourceType, err)
+	}
+	return data, nil
+}
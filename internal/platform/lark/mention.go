@@ -0,0 +1,160 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+// 群聊触发方式
+const (
+	groupModeAlways  = "always"
+	groupModeMention = "mention"
+	groupModeKeyword = "keyword"
+)
+
+// loadBotInfo 获取机器人的 open_id，用于判断消息是否 @ 了机器人
+// 配置了 bot_open_id 时直接使用；获取失败时按 bot_name 匹配
+func (a *Adapter) loadBotInfo(ctx context.Context) {
+	if a.cfg.BotOpenID != "" {
+		a.mu.Lock()
+		a.botOpenID = a.cfg.BotOpenID
+		a.mu.Unlock()
+		return
+	}
+
+	resp, err := a.client.Get(ctx, "/open-apis/bot/v3/info", nil, larkcore.AccessTokenTypeTenant)
+	if err != nil {
+		a.logger.Warn("Failed to get bot info, mentions are matched by bot name", zap.Error(err))
+		return
+	}
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Bot  struct {
+			OpenID  string `json:"open_id"`
+			AppName string `json:"app_name"`
+		} `json:"bot"`
+	}
+	if err := json.Unmarshal(resp.RawBody, &result); err != nil || resp.StatusCode != http.StatusOK || result.Code != 0 {
+		if err == nil {
+			err = fmt.Errorf("code=%d, msg=%s", result.Code, result.Msg)
+		}
+		a.logger.Warn("Failed to get bot info, mentions are matched by bot name", zap.Error(err))
+		return
+	}
+
+	a.mu.Lock()
+	a.botOpenID = result.Bot.OpenID
+	a.mu.Unlock()
+	a.logger.Info("Loaded Lark bot info",
+		zap.String("open_id", result.Bot.OpenID),
+		zap.String("app_name", result.Bot.AppName),
+	)
+}
+
+// isBotMention 判断 @ 的是否为本机器人，已知 open_id 时按 open_id 判断，否则按名称判断
+func (a *Adapter) isBotMention(m *larkim.MentionEvent) bool {
+	a.mu.RLock()
+	botOpenID := a.botOpenID
+	a.mu.RUnlock()
+
+	if botOpenID != "" {
+		return m.Id != nil && strValue(m.Id.OpenId) == botOpenID
+	}
+	return strValue(m.Name) == a.botName
+}
+
+// stripMentions 处理消息中的 @：@机器人的占位符被移除，其他用户的占位符（如 @_user_1）替换为 @姓名
+// 返回处理后的文本及是否 @ 了机器人
+func (a *Adapter) stripMentions(text string, mentions []*larkim.MentionEvent) (string, bool) {
+	// 按占位符长度从长到短替换，避免 @_user_1 误替换 @_user_10 的前缀
+	sorted := make([]*larkim.MentionEvent, 0, len(mentions))
+	for _, m := range mentions {
+		if m != nil && m.Key != nil {
+			sorted = append(sorted, m)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(*sorted[i].Key) > len(*sorted[j].Key)
+	})
+
+	mentioned := false
+	for _, m := range sorted {
+		name := strValue(m.Name)
+		if a.isBotMention(m) {
+			mentioned = true
+			text = strings.ReplaceAll(text, *m.Key, "")
+			// 富文本中的 @ 已转换为 @姓名
+			if name != "" {
+				text = strings.ReplaceAll(text, "@"+name, "")
+			}
+			continue
+		}
+		text = strings.ReplaceAll(text, *m.Key, "@"+name)
+	}
+	text = strings.ReplaceAll(text, "@_all", "@所有人")
+	return strings.TrimSpace(text), mentioned
+}
+
+// replaceMentionKeys 将获取消息接口返回的消息中的 @ 占位符替换为 @姓名
+func replaceMentionKeys(text string, mentions []*larkim.Mention) string {
+	for i := len(mentions) - 1; i >= 0; i-- {
+		m := mentions[i]
+		if m == nil || m.Key == nil {
+			continue
+		}
+		text = strings.ReplaceAll(text, *m.Key, "@"+strValue(m.Name))
+	}
+	return strings.ReplaceAll(text, "@_all", "@所有人")
+}
+
+// groupPolicy 返回群聊的触发方式及关键词，按群配置优先
+func (a *Adapter) groupPolicy(chatID string) (string, []string) {
+	mode, keywords := a.cfg.GroupMode, a.cfg.Keywords
+	for _, chat := range a.cfg.Chats {
+		if chat.ChatID != chatID {
+			continue
+		}
+		if chat.GroupMode != "" {
+			mode = chat.GroupMode
+		}
+		if len(chat.Keywords) > 0 {
+			keywords = chat.Keywords
+		}
+		break
+	}
+	if mode == "" {
+		mode = groupModeMention
+	}
+	return mode, keywords
+}
+
+// acceptGroupMessage 按群聊触发方式判断是否处理消息，keyword 模式匹配时返回去除前缀后的内容
+func (a *Adapter) acceptGroupMessage(chatID, content string, mentioned bool) (string, bool) {
+	mode, keywords := a.groupPolicy(chatID)
+	switch mode {
+	case groupModeAlways:
+		return content, true
+	case groupModeKeyword:
+		if mentioned {
+			return content, true
+		}
+		for _, keyword := range keywords {
+			if keyword != "" && strings.HasPrefix(content, keyword) {
+				return strings.TrimSpace(strings.TrimPrefix(content, keyword)), true
+			}
+		}
+		return content, false
+	default:
+		return content, mentioned
+	}
+}
@@ -0,0 +1,70 @@
+package lark
+
+import (
+	"testing"
+
+	"xia_adpter/internal/config"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+func mention(key, openID, name string) *larkim.MentionEvent {
+	return &larkim.MentionEvent{
+		Key:  &key,
+		Id:   &larkim.UserId{OpenId: &openID},
+		Name: &name,
+	}
+}
+
+func TestStripMentions(t *testing.T) {
+	a := NewAdapter(config.LarkConfig{}, nil, zap.NewNop())
+	a.botOpenID = "ou_bot"
+
+	mentions := []*larkim.MentionEvent{
+		mention("@_user_1", "ou_bot", "AgentBot"),
+		mention("@_user_10", "ou_10", "李四"),
+	}
+	text, mentioned := a.stripMentions("@_user_1 请 @_user_10 看一下", mentions)
+	if !mentioned {
+		t.Error("expected bot mention")
+	}
+	if want := "请 @李四 看一下"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	if _, mentioned := a.stripMentions("@_user_10 你好", mentions[1:]); mentioned {
+		t.Error("unexpected bot mention")
+	}
+}
+
+func TestAcceptGroupMessage(t *testing.T) {
+	a := NewAdapter(config.LarkConfig{
+		Keywords: []string{"/ask"},
+		Chats: []config.LarkChatConfig{
+			{ChatID: "oc_keyword", GroupMode: groupModeKeyword},
+			{ChatID: "oc_always", GroupMode: groupModeAlways},
+		},
+	}, nil, zap.NewNop())
+
+	tests := []struct {
+		chatID    string
+		content   string
+		mentioned bool
+		want      string
+		ok        bool
+	}{
+		{"oc_default", "你好", false, "你好", false},
+		{"oc_default", "你好", true, "你好", true},
+		{"oc_keyword", "/ask 天气", false, "天气", true},
+		{"oc_keyword", "天气", false, "天气", false},
+		{"oc_always", "天气", false, "天气", true},
+	}
+	for _, tt := range tests {
+		got, ok := a.acceptGroupMessage(tt.chatID, tt.content, tt.mentioned)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("acceptGroupMessage(%q, %q, %v) = %q, %v; want %q, %v",
+				tt.chatID, tt.content, tt.mentioned, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package lark
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+// postLocales 多语言富文本中优先选用的语言
+var postLocales = []string{"zh_cn", "en_us", "ja_jp"}
+
+// postBody 富文本消息的一种语言版本
+type postBody struct {
+	Title   string       `json:"title"`
+	Content [][]postNode `json:"content"`
+}
+
+// postNode 富文本中的元素，字段按 tag 取用
+type postNode struct {
+	Tag       string   `json:"tag"`
+	Text      string   `json:"text"`
+	Href      string   `json:"href"`
+	UserID    string   `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	ImageKey  string   `json:"image_key"`
+	FileKey   string   `json:"file_key"`
+	EmojiType string   `json:"emoji_type"`
+	Language  string   `json:"language"`
+	Style     []string `json:"style"`
+}
+
+// postMention 富文本中 @ 的用户，Key 为事件中的占位符（如 @_user_1）
+type postMention struct {
+	Key    string `json:"key"`
+	OpenID string `json:"open_id,omitempty"`
+	Name   string `json:"name"`
+}
+
+// parsedPost 解析后的富文本消息
+type parsedPost struct {
+	Text      string        // Markdown 文本
+	ImageKeys []string      // 嵌入的图片，按出现顺序去重
+	Mentions  []postMention // @ 的用户
+}
+
+// parsePost 解析富文本消息 content
+// 接收事件中的 content 为 {"title":...,"content":[[...]]}，
+// 获取消息接口返回的可能按语言包装为 {"zh_cn":{...},"en_us":{...}}，两者都支持
+func parsePost(contentJSON string) parsedPost {
+	body, ok := selectPostBody(contentJSON)
+	if !ok {
+		return parsedPost{}
+	}
+
+	var result parsedPost
+	seenImages := make(map[string]bool)
+	var lines []string
+	if title := strings.TrimSpace(body.Title); title != "" {
+		lines = append(lines, "**"+title+"**")
+	}
+
+	for _, paragraph := range body.Content {
+		var b strings.Builder
+		for _, node := range paragraph {
+			switch node.Tag {
+			case "text":
+				b.WriteString(styleText(node.Text, node.Style))
+			case "a":
+				text := node.Text
+				if text == "" {
+					text = node.Href
+				}
+				if node.Href == "" {
+					b.WriteString(text)
+				} else {
+					b.WriteString("[" + text + "](" + node.Href + ")")
+				}
+			case "at":
+				name := node.UserName
+				if node.UserID == "all" || node.UserID == "@_all" {
+					name = "所有人"
+				}
+				b.WriteString("@" + name)
+				result.Mentions = append(result.Mentions, postMention{Key: node.UserID, Name: node.UserName})
+			case "img":
+				if node.ImageKey != "" && !seenImages[node.ImageKey] {
+					seenImages[node.ImageKey] = true
+					result.ImageKeys = append(result.ImageKeys, node.ImageKey)
+				}
+				b.WriteString("[图片]")
+			case "media":
+				b.WriteString("[视频]")
+			case "emotion":
+				b.WriteString("[" + node.EmojiType + "]")
+			case "code_block":
+				code := strings.TrimRight(node.Text, "\n")
+				b.WriteString("```" + node.Language + "\n" + code + "\n```")
+			case "hr":
+				b.WriteString("---")
+			case "md":
+				b.WriteString(node.Text)
+			default:
+				b.WriteString(node.Text)
+			}
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+
+	result.Text = strings.TrimSpace(strings.Join(lines, "\n"))
+	return result
+}
+
+// selectPostBody 选择富文本的语言版本，依次尝试 postLocales 和其余语言
+func selectPostBody(contentJSON string) (postBody, bool) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(contentJSON), &raw); err != nil {
+		return postBody{}, false
+	}
+
+	if _, ok := raw["content"]; ok {
+		var body postBody
+		if err := json.Unmarshal([]byte(contentJSON), &body); err != nil {
+			return postBody{}, false
+		}
+		return body, true
+	}
+
+	locales := append([]string{}, postLocales...)
+	var others []string
+	for locale := range raw {
+		others = append(others, locale)
+	}
+	sort.Strings(others)
+	locales = append(locales, others...)
+
+	for _, locale := range locales {
+		data, ok := raw[locale]
+		if !ok {
+			continue
+		}
+		var body postBody
+		if err := json.Unmarshal(data, &body); err != nil {
+			continue
+		}
+		if len(body.Content) > 0 || body.Title != "" {
+			return body, true
+		}
+	}
+	return postBody{}, false
+}
+
+// styleText 将加粗、斜体、删除线样式转换为 Markdown，下划线没有对应语法，忽略
+func styleText(text string, style []string) string {
+	if strings.TrimSpace(text) == "" {
+		return text
+	}
+	for _, s := range style {
+		switch s {
+		case "bold":
+			text = "**" + text + "**"
+		case "italic":
+			text = "*" + text + "*"
+		case "lineThrough":
+			text = "~~" + text + "~~"
+		}
+	}
+	return text
+}
+
+// downloadPostImages 下载富文本中嵌入的图片，返回 base64 编码的图片数据，下载失败的图片记录日志后跳过
+func (a *Adapter) downloadPostImages(ctx context.Context, messageID string, imageKeys []string) []string {
+	var images []string
+	for _, imageKey := range imageKeys {
+		data, err := a.downloadResource(ctx, messageID, imageKey, "image")
+		if err != nil {
+			a.logger.Warn("Failed to download post image",
+				zap.String("message_id", messageID),
+				zap.String("image_key", imageKey),
+				zap.Error(err),
+			)
+			continue
+		}
+		images = append(images, base64.StdEncoding.EncodeToString(data))
+	}
+	return images
+}
+
+// resolveMentions 根据事件中的 mentions 补全 @ 用户的 open_id，返回 JSON 编码的列表，没有 @ 时返回空字符串
+func resolveMentions(mentions []postMention, events []*larkim.MentionEvent) string {
+	if len(mentions) == 0 {
+		return ""
+	}
+
+	openIDs := make(map[string]string)
+	for _, m := range events {
+		if m == nil || m.Key == nil || m.Id == nil {
+			continue
+		}
+		openIDs[*m.Key] = strValue(m.Id.OpenId)
+	}
+	for i := range mentions {
+		mentions[i].OpenID = openIDs[mentions[i].Key]
+	}
+
+	data, err := json.Marshal(mentions)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,43 @@
+package lark
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePost(t *testing.T) {
+	content := `{"zh_cn":{"title":"周报","content":[
+		[{"tag":"at","user_id":"@_user_1","user_name":"张三"},{"tag":"text","text":" 请看 "},{"tag":"a","text":"文档","href":"https://example.com/doc"}],
+		[{"tag":"text","text":"重点","style":["bold"]},{"tag":"emotion","emoji_type":"SMILE"}],
+		[{"tag":"img","image_key":"img_1"}],
+		[{"tag":"code_block","language":"go","text":"fmt.Println(1)\n"}],
+		[{"tag":"hr"}],
+		[{"tag":"img","image_key":"img_2"},{"tag":"img","image_key":"img_1"}]
+	]},"en_us":{"title":"Weekly","content":[[{"tag":"text","text":"ignored"}]]}}`
+
+	post := parsePost(content)
+
+	want := "**周报**\n" +
+		"@张三 请看 [文档](https://example.com/doc)\n" +
+		"**重点**[SMILE]\n" +
+		"[图片]\n" +
+		"```go\nfmt.Println(1)\n```\n" +
+		"---\n" +
+		"[图片][图片]"
+	if post.Text != want {
+		t.Errorf("Text = %q, want %q", post.Text, want)
+	}
+	if !reflect.DeepEqual(post.ImageKeys, []string{"img_1", "img_2"}) {
+		t.Errorf("ImageKeys = %v", post.ImageKeys)
+	}
+	if len(post.Mentions) != 1 || post.Mentions[0].Key != "@_user_1" || post.Mentions[0].Name != "张三" {
+		t.Errorf("Mentions = %+v", post.Mentions)
+	}
+}
+
+func TestParsePostFlat(t *testing.T) {
+	post := parsePost(`{"title":"","content":[[{"tag":"text","text":"hello"}]]}`)
+	if post.Text != "hello" {
+		t.Errorf("Text = %q, want %q", post.Text, "hello")
+	}
+}
@@ -0,0 +1,239 @@
+package lark
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+// 回复方式
+const (
+	replyModeChat   = "chat"
+	replyModeQuote  = "quote"
+	replyModeThread = "thread"
+)
+
+// Send 发送消息，每个片段渲染为一条飞书消息
+// text 渲染为富文本（post），markdown 渲染为交互卡片（过大时为富文本），image、file 先上传再发送，card 以交互卡片发送；
+// Mentions 只添加到第一个文本片段，ReplyTo 不为空时按回复方式引用回复或在话题中回复；
+// 每条消息的 uuid 由幂等键和片段序号生成，重试时飞书不会重复发送已送达的片段
+func (a *Adapter) Send(ctx context.Context, msg *message.OutboundMessage) error {
+	mentions := a.replyMentions(msg)
+	for i, part := range msg.Parts {
+		msgType, content, err := a.renderPart(ctx, part, mentions)
+		if err != nil {
+			return err
+		}
+		if part.Type == message.PartText || part.Type == message.PartMarkdown {
+			mentions = nil
+		}
+
+		if err := a.deliver(ctx, msg, idempotencyUUID(msg.IdempotencyKey, fmt.Sprint(i)), msgType, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderPart 将片段渲染为飞书消息类型及 content
+func (a *Adapter) renderPart(ctx context.Context, part message.Part, mentions []message.Mention) (string, string, error) {
+	switch part.Type {
+	case message.PartText:
+		content, err := postContent(mentions, map[string]interface{}{
+			"tag":  "text",
+			"text": part.Text,
+		})
+		return larkim.MsgTypePost, content, err
+
+	case message.PartMarkdown:
+		return message.RenderLarkMarkdown(part.Text, mentions)
+
+	case message.PartImage:
+		data, _, err := platform.PartData(ctx, part)
+		if err != nil {
+			return "", "", err
+		}
+		imageKey, err := a.uploadImage(ctx, data)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to upload image: %w", err)
+		}
+		content, err := json.Marshal(map[string]string{"image_key": imageKey})
+		return larkim.MsgTypeImage, string(content), err
+
+	case message.PartFile:
+		data, name, err := platform.PartData(ctx, part)
+		if err != nil {
+			return "", "", err
+		}
+		if name == "" {
+			name = "file"
+		}
+		fileKey, err := a.uploadFile(ctx, name, data)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to upload file: %w", err)
+		}
+		content, err := json.Marshal(map[string]string{"file_key": fileKey})
+		return larkim.MsgTypeFile, string(content), err
+
+	case message.PartCard:
+		if len(part.Card) == 0 {
+			return "", "", fmt.Errorf("card part is empty")
+		}
+		return larkim.MsgTypeInteractive, string(part.Card), nil
+	}
+
+	return "", "", fmt.Errorf("unsupported part type: %s", part.Type)
+}
+
+// postContent 构建只有一段的富文本消息，mentions 中的用户以 at 标签放在段首
+func postContent(mentions []message.Mention, element map[string]interface{}) (string, error) {
+	line := make([]map[string]interface{}, 0, len(mentions)+1)
+	for _, m := range mentions {
+		line = append(line, map[string]interface{}{
+			"tag":     "at",
+			"user_id": m.UserID,
+		})
+	}
+	line = append(line, element)
+
+	content, err := json.Marshal(map[string]interface{}{
+		"zh_cn": map[string]interface{}{
+			"title":   "",
+			"content": [][]map[string]interface{}{line},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal message content: %w", err)
+	}
+	return string(content), nil
+}
+
+// replyMentions 返回需要 @ 的用户，未指定时群聊中按配置 @ 提问者
+func (a *Adapter) replyMentions(msg *message.OutboundMessage) []message.Mention {
+	if len(msg.Mentions) > 0 || !a.cfg.Reply.MentionSender {
+		return msg.Mentions
+	}
+	if msg.Metadata[message.MetadataChatType] != "group" {
+		return nil
+	}
+	if senderID := msg.Metadata[message.MetadataSenderID]; senderID != "" {
+		return []message.Mention{{UserID: senderID}}
+	}
+	return nil
+}
+
+// replyTarget 返回回复的消息 ID 及是否在话题中回复，回复方式为 chat 时返回空字符串
+func (a *Adapter) replyTarget(replyTo string) (string, bool) {
+	if a.cfg.Reply.Mode == replyModeChat {
+		return "", false
+	}
+	return replyTo, a.cfg.Reply.Mode == replyModeThread
+}
+
+// deliver 发送一条已渲染的消息，有回复目标时回复该消息，否则发送到会话
+func (a *Adapter) deliver(ctx context.Context, msg *message.OutboundMessage, uuid, msgType, content string) error {
+	messageID, err := a.sendMessage(ctx, msg.SessionID, msg.Metadata, msg.ReplyTo, uuid, msgType, content)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Debug("Sent message to Lark",
+		zap.String("session_id", msg.SessionID),
+		zap.String("msg_type", msgType),
+		zap.String("reply_to", msg.ReplyTo),
+		zap.String("message_id", messageID),
+	)
+	return nil
+}
+
+// sendMessage 按回复方式回复 replyTo 或发送到会话，返回新消息的 ID
+func (a *Adapter) sendMessage(ctx context.Context, sessionID string, metadata map[string]string, replyTo, uuid, msgType, content string) (string, error) {
+	var messageID *string
+	if replyTo, inThread := a.replyTarget(replyTo); replyTo != "" {
+		req := larkim.NewReplyMessageReqBuilder().
+			MessageId(replyTo).
+			Body(larkim.NewReplyMessageReqBodyBuilder().
+				Content(content).
+				MsgType(msgType).
+				ReplyInThread(inThread).
+				Uuid(uuid).
+				Build()).
+			Build()
+
+		resp, err := a.client.Im.V1.Message.Reply(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("failed to reply message: %w", err)
+		}
+		if !resp.Success() {
+			return "", sendError("failed to reply message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		if resp.Data != nil {
+			messageID = resp.Data.MessageId
+		}
+	} else {
+		receiveID, receiveIDType := receiveTarget(sessionID, metadata)
+
+		req := larkim.NewCreateMessageReqBuilder().
+			ReceiveIdType(receiveIDType).
+			Body(larkim.NewCreateMessageReqBodyBuilder().
+				ReceiveId(receiveID).
+				Content(content).
+				MsgType(msgType).
+				Uuid(uuid).
+				Build()).
+			Build()
+
+		resp, err := a.client.Im.V1.Message.Create(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("failed to send message: %w", err)
+		}
+		if !resp.Success() {
+			return "", sendError("failed to send message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		if resp.Data != nil {
+			messageID = resp.Data.MessageId
+		}
+	}
+	return strValue(messageID), nil
+}
+
+// retryableCodes 稍后重试可能成功的飞书错误码
+var retryableCodes = map[int]bool{
+	99991400: true, // 应用请求频率超限
+	11232:    true, // 发送消息频率超限
+	230020:   true, // 触发群或用户的频率限制
+}
+
+// sendError 将飞书接口的错误转换为 platform.SendError，按错误码和 HTTP 状态判断能否重试
+func sendError(op string, apiResp *larkcore.ApiResp, code int, msg string) error {
+	retryable := retryableCodes[code]
+	if apiResp != nil && (apiResp.StatusCode >= http.StatusInternalServerError || apiResp.StatusCode == http.StatusTooManyRequests) {
+		retryable = true
+	}
+	return &platform.SendError{Op: op, Code: code, Msg: msg, Retryable: retryable}
+}
+
+// idempotencyUUID 由幂等键和后缀生成请求的 uuid（飞书限制 50 个字符，超长时取摘要），
+// 没有幂等键时使用时间戳，不做去重
+func idempotencyUUID(key, suffix string) string {
+	if key == "" {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	uuid := key + "-" + suffix
+	if len(uuid) > 50 {
+		sum := md5.Sum([]byte(uuid))
+		uuid = hex.EncodeToString(sum[:])
+	}
+	return uuid
+}
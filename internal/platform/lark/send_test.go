@@ -0,0 +1,252 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// uploadLark 上传图片返回 img_1，上传文件返回 file_1，其余请求返回默认结果
+func uploadLark(req larkRequest) (int, string) {
+	switch req.Path {
+	case "/open-apis/im/v1/images":
+		return http.StatusOK, `{"code":0,"msg":"success","data":{"image_key":"img_1"}}`
+	case "/open-apis/im/v1/files":
+		return http.StatusOK, `{"code":0,"msg":"success","data":{"file_key":"file_1"}}`
+	}
+	return http.StatusOK, ""
+}
+
+func TestRenderPart(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	f.reply = uploadLark
+	mentions := []message.Mention{{UserID: "ou_1"}}
+
+	tests := []struct {
+		name     string
+		part     message.Part
+		mentions []message.Mention
+		msgType  string
+		contains []string
+	}{
+		{name: "text", part: message.TextPart("你好"), mentions: mentions, msgType: "post",
+			contains: []string{`{"tag":"at","user_id":"ou_1"}`, `{"tag":"text","text":"你好"}`}},
+		{name: "markdown", part: message.MarkdownPart("**粗体**"), mentions: mentions, msgType: "interactive",
+			contains: []string{`\u003cat id=ou_1\u003e`, `**粗体**`}},
+		{name: "image", part: message.ImagePart([]byte("png")), msgType: "image",
+			contains: []string{`{"image_key":"img_1"}`}},
+		{name: "file", part: message.FilePart("a.pdf", []byte("pdf")), msgType: "file",
+			contains: []string{`{"file_key":"file_1"}`}},
+		{name: "card", part: message.CardPart(json.RawMessage(`{"elements":[]}`)), msgType: "interactive",
+			contains: []string{`{"elements":[]}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgType, content, err := a.renderPart(context.Background(), tt.part, tt.mentions)
+			if err != nil {
+				t.Fatalf("renderPart: %v", err)
+			}
+			if msgType != tt.msgType {
+				t.Errorf("msgType = %q, want %q", msgType, tt.msgType)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(content, s) {
+					t.Errorf("content %s missing %s", content, s)
+				}
+			}
+		})
+	}
+
+	uploads := f.calls("POST")
+	if len(uploads) != 2 || uploads[0].Path != "/open-apis/im/v1/images" || uploads[1].Path != "/open-apis/im/v1/files" {
+		t.Fatalf("uploads = %+v, want image and file", uploads)
+	}
+	if body := string(uploads[1].Body); !strings.Contains(body, "a.pdf") || !strings.Contains(body, "pdf") {
+		t.Errorf("file upload missing name or type: %s", body)
+	}
+}
+
+func TestRenderPartErrors(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	f.reply = func(req larkRequest) (int, string) {
+		return http.StatusBadRequest, `{"code":234001,"msg":"Invalid request param."}`
+	}
+
+	parts := map[string]message.Part{
+		"empty card":  message.CardPart(nil),
+		"no data":     {Type: message.PartImage},
+		"unsupported": {Type: "video", Text: "x"},
+		"upload":      message.ImagePart([]byte("png")),
+	}
+	for name, part := range parts {
+		if _, _, err := a.renderPart(context.Background(), part, nil); err == nil {
+			t.Errorf("%s: renderPart succeeded, want error", name)
+		}
+	}
+}
+
+func TestSendMentionsFirstTextPartOnly(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	f.reply = uploadLark
+
+	out := message.NewOutboundMessage("oc_group",
+		message.ImagePart([]byte("png")),
+		message.TextPart("第一段"),
+		message.TextPart("第二段"),
+	)
+	out.Metadata[message.MetadataChatID] = "oc_group"
+	out.Mentions = []message.Mention{{UserID: "ou_1"}}
+	out.IdempotencyKey = "om_in"
+	if err := a.Send(context.Background(), out); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var sent []map[string]string
+	for _, req := range f.calls("POST") {
+		if req.Path != "/open-apis/im/v1/messages" {
+			continue
+		}
+		var body map[string]string
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, body)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(sent))
+	}
+	if sent[0]["msg_type"] != "image" || sent[1]["msg_type"] != "post" || sent[2]["msg_type"] != "post" {
+		t.Errorf("msg types = %s %s %s", sent[0]["msg_type"], sent[1]["msg_type"], sent[2]["msg_type"])
+	}
+	if !strings.Contains(sent[1]["content"], "ou_1") || strings.Contains(sent[2]["content"], "ou_1") {
+		t.Errorf("mention not only on first text part: %s / %s", sent[1]["content"], sent[2]["content"])
+	}
+	uuids := map[string]bool{}
+	for _, body := range sent {
+		uuids[body["uuid"]] = true
+	}
+	if len(uuids) != 3 {
+		t.Errorf("uuids not distinct per part: %v", uuids)
+	}
+}
+
+func TestSendReplyModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		path     string
+		query    string
+		inThread bool
+	}{
+		{mode: "quote", path: "/open-apis/im/v1/messages/om_in/reply"},
+		{mode: "thread", path: "/open-apis/im/v1/messages/om_in/reply", inThread: true},
+		{mode: "chat", path: "/open-apis/im/v1/messages", query: "receive_id_type=chat_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			a, f := newFakeLark(t, config.LarkConfig{Reply: config.LarkReplyConfig{Mode: tt.mode}})
+			if replyTo, inThread := a.replyTarget("om_in"); inThread != tt.inThread || (replyTo == "") != (tt.mode == "chat") {
+				t.Errorf("replyTarget = %q, %v", replyTo, inThread)
+			}
+
+			out := message.NewOutboundMessage("oc_group", message.TextPart("hi"))
+			out.ReplyTo = "om_in"
+			out.Metadata[message.MetadataChatID] = "oc_group"
+			if err := a.Send(context.Background(), out); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			reqs := f.calls("POST")
+			if len(reqs) != 1 || reqs[0].Path != tt.path || reqs[0].Query != tt.query {
+				t.Fatalf("requests = %+v, want %s?%s", reqs, tt.path, tt.query)
+			}
+			var body map[string]interface{}
+			json.Unmarshal(reqs[0].Body, &body)
+			if inThread, _ := body["reply_in_thread"].(bool); inThread != tt.inThread {
+				t.Errorf("reply_in_thread = %v, want %v", body["reply_in_thread"], tt.inThread)
+			}
+		})
+	}
+}
+
+func TestReceiveTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+		metadata  map[string]string
+		id        string
+		idType    string
+	}{
+		{"chat id", "ou_user", map[string]string{message.MetadataChatID: "oc_p2p", message.MetadataSenderID: "ou_user"}, "oc_p2p", "chat_id"},
+		{"sender open id", "custom-session", map[string]string{message.MetadataSenderID: "ou_user"}, "ou_user", "open_id"},
+		{"session with chat", "ou_user%oc_group", nil, "oc_group", "chat_id"},
+		{"chat prefix", "oc_group", nil, "oc_group", "chat_id"},
+		{"union prefix", "on_user", nil, "on_user", "union_id"},
+		{"open id", "ou_user", nil, "ou_user", "open_id"},
+	}
+	for _, tt := range tests {
+		id, idType := receiveTarget(tt.sessionID, tt.metadata)
+		if id != tt.id || idType != tt.idType {
+			t.Errorf("%s: receiveTarget = %s %s, want %s %s", tt.name, id, idType, tt.id, tt.idType)
+		}
+	}
+}
+
+func TestReplyMentions(t *testing.T) {
+	explicit := []message.Mention{{UserID: "ou_other"}}
+	tests := []struct {
+		name          string
+		mentionSender bool
+		chatType      string
+		mentions      []message.Mention
+		want          string
+	}{
+		{name: "group", mentionSender: true, chatType: "group", want: "ou_sender"},
+		{name: "p2p", mentionSender: true, chatType: "p2p"},
+		{name: "disabled", chatType: "group"},
+		{name: "explicit", mentionSender: true, chatType: "group", mentions: explicit, want: "ou_other"},
+	}
+	for _, tt := range tests {
+		a := NewAdapter(config.LarkConfig{Reply: config.LarkReplyConfig{MentionSender: tt.mentionSender}}, nil, zap.NewNop())
+		out := message.NewOutboundMessage("oc_1", message.TextPart("hi"))
+		out.Mentions = tt.mentions
+		out.Metadata[message.MetadataChatType] = tt.chatType
+		out.Metadata[message.MetadataSenderID] = "ou_sender"
+
+		got := a.replyMentions(out)
+		if tt.want == "" && len(got) != 0 || tt.want != "" && (len(got) != 1 || got[0].UserID != tt.want) {
+			t.Errorf("%s: replyMentions = %v, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIdempotencyUUID(t *testing.T) {
+	// 同一幂等键和片段序号在重试时生成相同的 uuid
+	if a, b := idempotencyUUID("om_in", "0"), idempotencyUUID("om_in", "0"); a != b || a != "om_in-0" {
+		t.Errorf("uuid = %q, %q, want stable om_in-0", a, b)
+	}
+	if idempotencyUUID("om_in", "0") == idempotencyUUID("om_in", "1") {
+		t.Error("parts share a uuid")
+	}
+
+	// 超过飞书 50 字符限制时取 md5
+	long := strings.Repeat("k", 60)
+	uuid := idempotencyUUID(long, "0")
+	if len(uuid) != 32 || uuid != idempotencyUUID(long, "0") || uuid == idempotencyUUID(long, "1") {
+		t.Errorf("long key uuid = %q", uuid)
+	}
+
+	// 没有幂等键时每次不同，不去重
+	first := idempotencyUUID("", "0")
+	time.Sleep(time.Microsecond)
+	if first == "" || first == idempotencyUUID("", "0") {
+		t.Errorf("empty key uuid = %q, want unique", first)
+	}
+}
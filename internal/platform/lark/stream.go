@@ -0,0 +1,195 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultStreamInterval 默认更新间隔，飞书单条消息更新频率限制为 5 QPS
+	defaultStreamInterval = 800 * time.Millisecond
+	// defaultStreamMinChars 默认触发更新的新增字符数
+	defaultStreamMinChars = 50
+	// streamPlaceholder 流式回复开始时的占位内容
+	streamPlaceholder = "..."
+)
+
+// StreamingEnabled 是否启用流式回复
+func (a *Adapter) StreamingEnabled() bool {
+	return a.cfg.Streaming.Enabled
+}
+
+// BeginStream 发送一张卡片作为流式回复的载体，之后通过更新卡片实现打字机效果
+// metadata 为入站消息的元数据，按回复方式引用回复提问消息
+func (a *Adapter) BeginStream(ctx context.Context, sessionID string, metadata map[string]string) (platform.Stream, error) {
+	content, err := streamCardContent(streamPlaceholder)
+	if err != nil {
+		return nil, err
+	}
+
+	replyTo := metadata["message_id"]
+	messageID, err := a.sendMessage(ctx, sessionID, metadata, replyTo, idempotencyUUID(replyTo, "stream"), larkim.MsgTypeInteractive, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send stream card: %w", err)
+	}
+	if messageID == "" {
+		return nil, fmt.Errorf("stream card message id is empty")
+	}
+
+	interval := time.Duration(a.cfg.Streaming.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultStreamInterval
+	}
+	minChars := a.cfg.Streaming.MinChars
+	if minChars <= 0 {
+		minChars = defaultStreamMinChars
+	}
+
+	return &stream{
+		adapter:   a,
+		ctx:       ctx,
+		messageID: messageID,
+		interval:  interval,
+		minChars:  minChars,
+		lastPatch: time.Now(),
+	}, nil
+}
+
+// stream 飞书流式回复，按时间间隔或新增字符数节流更新卡片
+type stream struct {
+	adapter   *Adapter
+	ctx       context.Context
+	messageID string
+	interval  time.Duration
+	minChars  int
+
+	mu        sync.Mutex
+	lastPatch time.Time
+	lastLen   int    // 最近一次更新时的内容长度（字符数）
+	shown     string // 卡片中当前显示的内容
+	finished  bool
+	rest      string // 结束时卡片放不下的内容
+}
+
+// Update 更新卡片内容，未达到节流条件时跳过
+func (s *stream) Update(content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return nil
+	}
+	length := len([]rune(content))
+	if time.Since(s.lastPatch) < s.interval && length-s.lastLen < s.minChars {
+		return nil
+	}
+
+	// 超出卡片大小时只显示放得下的部分，该部分不再变化时不必更新
+	shown, _, err := splitStreamContent(content)
+	if err != nil {
+		return err
+	}
+	if shown == s.shown {
+		s.lastLen = length
+		return nil
+	}
+	return s.patch(shown, length)
+}
+
+// Finish 写入最终内容，返回卡片放不下、需要另行发送的内容
+// 更新失败时可以重试，成功后再次调用直接返回
+func (s *stream) Finish(content string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return s.rest, nil
+	}
+	shown, rest, err := splitStreamContent(content)
+	if err != nil {
+		return "", err
+	}
+	if err := s.patch(shown, len([]rune(content))); err != nil {
+		return "", err
+	}
+	s.finished = true
+	s.rest = rest
+	return rest, nil
+}
+
+// patch 调用飞书接口将卡片更新为 shown，length 为当前内容的字符数，调用方需持有锁
+func (s *stream) patch(shown string, length int) error {
+	cardContent, err := streamCardContent(shown)
+	if err != nil {
+		return err
+	}
+
+	req := larkim.NewPatchMessageReqBuilder().
+		MessageId(s.messageID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
+			Content(cardContent).
+			Build()).
+		Build()
+
+	resp, err := s.adapter.client.Im.V1.Message.Patch(s.ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to patch stream card: %w", err)
+	}
+	if !resp.Success() {
+		return sendError("failed to patch stream card", resp.ApiResp, resp.Code, resp.Msg)
+	}
+
+	s.lastPatch = time.Now()
+	s.lastLen = length
+	s.shown = shown
+	s.adapter.logger.Debug("Patched Lark stream card",
+		zap.String("message_id", s.messageID),
+		zap.Int("length", length),
+	)
+	return nil
+}
+
+// splitStreamContent 按卡片大小上限拆分内容，返回卡片中显示的部分和放不下的部分
+// 优先在换行和句末标点处拆分，卡片 JSON 仍超出上限时缩小拆分长度重试
+func splitStreamContent(content string) (string, string, error) {
+	budget := message.MaxLarkCardSize
+	for {
+		head := content
+		if len(head) > budget {
+			head = message.SplitText(content, budget)[0]
+		}
+		card, err := streamCardContent(head)
+		if err != nil {
+			return "", "", err
+		}
+		if len(card) <= message.MaxLarkCardSize {
+			return head, content[len(head):], nil
+		}
+		// 转义和卡片结构使 JSON 比原文大，按比例缩小
+		budget = min(len(head), budget) * message.MaxLarkCardSize / len(card)
+		if budget <= 0 {
+			return "", content, nil
+		}
+	}
+}
+
+// streamCardContent 将内容按 Markdown 转换为卡片，update_multi 为 true 的卡片才允许更新
+func streamCardContent(content string) (string, error) {
+	card := message.MarkdownToLarkCard(content)
+	card.Config.UpdateMulti = true
+
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal card: %w", err)
+	}
+	return string(data), nil
+}
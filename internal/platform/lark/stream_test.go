@@ -0,0 +1,251 @@
+package lark
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"go.uber.org/zap"
+)
+
+// larkRequest 模拟的飞书开放平台收到的请求
+type larkRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   []byte
+}
+
+// fakeLark 模拟飞书开放平台，自动返回 tenant_access_token，其余请求记录后交给 reply 处理
+type fakeLark struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []larkRequest
+	// reply 返回状态码和响应内容，为 nil 或返回空内容时返回成功
+	reply func(req larkRequest) (int, string)
+}
+
+// newFakeLark 创建指向模拟平台的适配器
+func newFakeLark(t *testing.T, cfg config.LarkConfig) (*Adapter, *fakeLark) {
+	f := &fakeLark{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/open-apis/auth/") {
+			io.WriteString(w, `{"code":0,"msg":"ok","tenant_access_token":"t-test","expire":7200}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := larkRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: body}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		reply := f.reply
+		f.mu.Unlock()
+
+		status, resp := http.StatusOK, ""
+		if reply != nil {
+			status, resp = reply(req)
+		}
+		if resp == "" {
+			resp = `{"code":0,"msg":"success","data":{"message_id":"om_new"}}`
+		}
+		if strings.HasPrefix(resp, "{") {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			// 非 JSON 响应按资源文件返回
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, resp)
+	}))
+	t.Cleanup(f.server.Close)
+
+	a := NewAdapter(cfg, nil, zap.NewNop())
+	a.client = lark.NewClient("cli_test", "secret",
+		lark.WithOpenBaseUrl(f.server.URL),
+		lark.WithLogLevel(larkcore.LogLevelError),
+	)
+	return a, f
+}
+
+// calls 返回收到的指定方法的请求
+func (f *fakeLark) calls(method string) []larkRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var reqs []larkRequest
+	for _, req := range f.requests {
+		if req.Method == method {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// patchedText 返回更新卡片请求中卡片的 Markdown 内容
+func patchedText(t *testing.T, req larkRequest) string {
+	t.Helper()
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatalf("unmarshal patch body: %v", err)
+	}
+	if len(body.Content) > message.MaxLarkCardSize {
+		t.Errorf("card size %d exceeds %d", len(body.Content), message.MaxLarkCardSize)
+	}
+	var card message.LarkCard
+	if err := json.Unmarshal([]byte(body.Content), &card); err != nil {
+		t.Fatalf("unmarshal card: %v", err)
+	}
+	var text []string
+	for _, el := range card.Elements {
+		if content, ok := el["content"].(string); ok {
+			text = append(text, content)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+func newTestStream(a *Adapter) *stream {
+	return &stream{
+		adapter:   a,
+		ctx:       context.Background(),
+		messageID: "om_card",
+		interval:  time.Hour,
+		minChars:  10,
+		lastPatch: time.Now(),
+	}
+}
+
+func TestStreamThrottlesUpdates(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	s := newTestStream(a)
+
+	steps := []struct {
+		content string
+		elapsed bool // 距上次更新已超过 interval
+		patched bool
+	}{
+		{content: "你好", patched: false},
+		{content: "你好，这是一个流式回复", patched: true}, // 新增字符达到 min_chars
+		{content: "你好，这是一个流式回复。", patched: false},
+		{content: "你好，这是一个流式回复。再", elapsed: true, patched: true},
+	}
+	for i, step := range steps {
+		if step.elapsed {
+			s.lastPatch = time.Now().Add(-2 * time.Hour)
+		}
+		before := len(f.calls(http.MethodPatch))
+		if err := s.Update(step.content); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if patched := len(f.calls(http.MethodPatch)) > before; patched != step.patched {
+			t.Errorf("step %d: patched = %v, want %v", i, patched, step.patched)
+		}
+	}
+
+	// Finish 不受节流限制，结束后不再更新
+	rest, err := s.Finish("你好，这是一个流式回复。再见")
+	if err != nil || rest != "" {
+		t.Fatalf("Finish = %q, %v", rest, err)
+	}
+	if err := s.Update(strings.Repeat("更多", 50)); err != nil {
+		t.Fatalf("Update after finish: %v", err)
+	}
+	patches := f.calls(http.MethodPatch)
+	if len(patches) != 3 {
+		t.Fatalf("patches = %d, want 3", len(patches))
+	}
+	if !strings.HasSuffix(patches[2].Path, "/om_card") || patchedText(t, patches[2]) != "你好，这是一个流式回复。再见" {
+		t.Errorf("final patch = %s %s", patches[2].Path, patches[2].Body)
+	}
+}
+
+func TestStreamFinishSplitsOversizedContent(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	s := newTestStream(a)
+
+	content := strings.Repeat("这一行是很长的回答内容，用来超过飞书卡片的大小限制。\n", 1000)
+	rest, err := s.Finish(content)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	patches := f.calls(http.MethodPatch)
+	if len(patches) != 1 {
+		t.Fatalf("patches = %d, want 1", len(patches))
+	}
+	shown := content[:len(content)-len(rest)]
+	if rest == "" || !strings.HasSuffix(shown, "\n") {
+		t.Fatalf("content not split at a line break: shown %d bytes, rest %d bytes", len(shown), len(rest))
+	}
+	if got := patchedText(t, patches[0]); got != strings.TrimSpace(shown) {
+		t.Errorf("card shows %d bytes, want %d", len(got), len(strings.TrimSpace(shown)))
+	}
+
+	// 再次调用返回相同的剩余内容，不再更新
+	if again, err := s.Finish(content); err != nil || again != rest {
+		t.Errorf("second Finish returned %d bytes, %v", len(again), err)
+	}
+	if len(f.calls(http.MethodPatch)) != 1 {
+		t.Error("finished stream patched again")
+	}
+
+	// 超出大小后只在卡片显示的部分变化时更新
+	s = newTestStream(a)
+	s.minChars = 1
+	s.Update(content)
+	s.Update(content + "更多")
+	if n := len(f.calls(http.MethodPatch)); n != 2 {
+		t.Errorf("patches = %d, want 2", n)
+	}
+}
+
+func TestStreamFinishCanBeRetried(t *testing.T) {
+	a, f := newFakeLark(t, config.LarkConfig{})
+	failures := 1
+	f.reply = func(req larkRequest) (int, string) {
+		if req.Method == http.MethodPatch && failures > 0 {
+			failures--
+			return http.StatusServiceUnavailable, `{"code":99991400,"msg":"busy"}`
+		}
+		return http.StatusOK, ""
+	}
+	s := newTestStream(a)
+
+	if _, err := s.Finish("done"); !platform.IsRetryable(err) {
+		t.Fatalf("Finish error = %v, want retryable", err)
+	}
+	if _, err := s.Finish("done"); err != nil {
+		t.Fatalf("retry Finish: %v", err)
+	}
+	if n := len(f.calls(http.MethodPatch)); n != 2 {
+		t.Errorf("patches = %d, want 2", n)
+	}
+}
+
+func TestStreamCardContent(t *testing.T) {
+	content, err := streamCardContent("**加粗** 文本")
+	if err != nil {
+		t.Fatalf("streamCardContent: %v", err)
+	}
+	var card message.LarkCard
+	if err := json.Unmarshal([]byte(content), &card); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !card.Config.UpdateMulti {
+		t.Error("stream card must allow updates")
+	}
+	if !strings.Contains(content, "**加粗** 文本") {
+		t.Errorf("card = %s", content)
+	}
+}
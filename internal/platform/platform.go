@@ -0,0 +1,264 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// Capabilities 平台能力描述
+type Capabilities struct {
+	Images  bool `json:"images"`  // 支持发送图片
+	Files   bool `json:"files"`   // 支持发送文件
+	Cards   bool `json:"cards"`   // 支持卡片消息
+	Edits   bool `json:"edits"`   // 支持编辑已发送的消息
+	Threads bool `json:"threads"` // 支持话题/回复线程
+}
+
+// Platform 平台适配器接口，飞书、企微等适配器都实现该接口
+type Platform interface {
+	// Name 平台标识（lark、wecom）
+	Name() string
+	// Start 启动适配器，阻塞直到 ctx 取消或出错
+	Start(ctx context.Context) error
+	// Stop 停止适配器
+	Stop() error
+	// Running 适配器是否在运行
+	Running() bool
+	// Capabilities 返回平台支持的能力
+	Capabilities() Capabilities
+	// Send 发送消息，按片段类型渲染为平台原生消息，不支持的片段返回错误
+	Send(ctx context.Context, msg *message.OutboundMessage) error
+}
+
+// Stream 流式回复，先发送一条消息再不断更新其内容
+type Stream interface {
+	// Update 更新为当前已生成的完整内容，实现方可自行节流
+	Update(content string) error
+	// Finish 写入最终内容并结束流式回复，返回超出平台消息大小、需要作为普通回复发送的剩余内容
+	// 失败后可以重试，成功后再次调用返回相同的剩余内容
+	Finish(content string) (string, error)
+}
+
+// StreamingSender 支持流式回复的平台实现该接口
+type StreamingSender interface {
+	// StreamingEnabled 是否启用流式回复
+	StreamingEnabled() bool
+	// BeginStream 开始一条流式回复，metadata 为触发消息的元数据
+	BeginStream(ctx context.Context, sessionID string, metadata map[string]string) (Stream, error)
+}
+
+// MediaDownloader 支持下载收到的媒体文件的平台实现该接口
+type MediaDownloader interface {
+	// DownloadMedia 下载消息中的图片、语音、视频、文件
+	DownloadMedia(ctx context.Context, msg *message.Message) (*message.MediaFile, error)
+}
+
+// Status 平台运行状态
+type Status struct {
+	Name         string       `json:"name"`
+	Running      bool         `json:"running"`
+	Capabilities Capabilities `json:"capabilities"`
+	StartedAt    *time.Time   `json:"started_at,omitempty"`
+	LastError    string       `json:"last_error,omitempty"`
+}
+
+// entry 注册表中的平台及其运行状态
+type entry struct {
+	platform  Platform
+	cancel    context.CancelFunc
+	done      chan struct{}
+	startedAt time.Time
+	lastErr   error
+}
+
+// Registry 平台注册表，按名称管理适配器的启动和停止
+type Registry struct {
+	logger  *zap.Logger
+	baseCtx context.Context
+	entries map[string]*entry
+	order   []string
+	mu      sync.RWMutex
+}
+
+// NewRegistry 创建平台注册表
+func NewRegistry(logger *zap.Logger) *Registry {
+	return &Registry{
+		logger:  logger,
+		baseCtx: context.Background(),
+		entries: make(map[string]*entry),
+	}
+}
+
+// Register 注册平台适配器，名称不能重复
+func (r *Registry) Register(p Platform) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := p.Name()
+	if _, exists := r.entries[name]; exists {
+		return fmt.Errorf("platform %q already registered", name)
+	}
+	r.entries[name] = &entry{platform: p}
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Get 按名称获取平台适配器
+func (r *Registry) Get(name string) (Platform, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, false
+	}
+	return e.platform, true
+}
+
+// List 按注册顺序返回所有平台适配器
+func (r *Registry) List() []Platform {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	platforms := make([]Platform, 0, len(r.order))
+	for _, name := range r.order {
+		platforms = append(platforms, r.entries[name].platform)
+	}
+	return platforms
+}
+
+// StartAll 启动所有已注册的平台，ctx 取消时全部停止
+// ctx 同时作为之后单独调用 Start 的父上下文
+func (r *Registry) StartAll(ctx context.Context) {
+	r.mu.Lock()
+	r.baseCtx = ctx
+	names := append([]string(nil), r.order...)
+	r.mu.Unlock()
+
+	for _, name := range names {
+		if err := r.Start(name); err != nil {
+			r.logger.Error("Failed to start platform",
+				zap.String("platform", name),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// Start 在后台启动指定平台
+func (r *Registry) Start(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[name]
+	if !ok {
+		return fmt.Errorf("platform %q not registered", name)
+	}
+	if e.done != nil {
+		select {
+		case <-e.done:
+		default:
+			return fmt.Errorf("platform %q is already running", name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.baseCtx)
+	done := make(chan struct{})
+	e.cancel = cancel
+	e.done = done
+	e.startedAt = time.Now()
+	e.lastErr = nil
+
+	go func() {
+		defer close(done)
+		err := e.platform.Start(ctx)
+		if err != nil {
+			r.logger.Error("Platform exited with error",
+				zap.String("platform", name),
+				zap.Error(err),
+			)
+		}
+		r.mu.Lock()
+		e.lastErr = err
+		r.mu.Unlock()
+	}()
+
+	r.logger.Info("Platform started", zap.String("platform", name))
+	return nil
+}
+
+// Stop 停止指定平台并等待其退出
+func (r *Registry) Stop(name string) error {
+	r.mu.RLock()
+	e, ok := r.entries[name]
+	var cancel context.CancelFunc
+	var done chan struct{}
+	if ok {
+		cancel, done = e.cancel, e.done
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("platform %q not registered", name)
+	}
+	if done == nil {
+		return nil
+	}
+
+	cancel()
+	<-done
+	r.logger.Info("Platform stopped", zap.String("platform", name))
+	return nil
+}
+
+// StopAll 停止所有平台并等待退出
+func (r *Registry) StopAll() {
+	r.mu.RLock()
+	names := append([]string(nil), r.order...)
+	r.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := r.Stop(name); err != nil {
+				r.logger.Warn("Failed to stop platform",
+					zap.String("platform", name),
+					zap.Error(err),
+				)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
+
+// Status 返回所有平台的运行状态
+func (r *Registry) Status() []Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(r.order))
+	for _, name := range r.order {
+		e := r.entries[name]
+		status := Status{
+			Name:         name,
+			Running:      e.platform.Running(),
+			Capabilities: e.platform.Capabilities(),
+		}
+		if !e.startedAt.IsZero() {
+			startedAt := e.startedAt
+			status.StartedAt = &startedAt
+		}
+		if e.lastErr != nil {
+			status.LastError = e.lastErr.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -8,46 +8,133 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"xia_adpter/internal/config"
+	"xia_adpter/internal/dedup"
 	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// defaultHTTPTimeout 调用企微接口的默认超时时间
+const defaultHTTPTimeout = 10 * time.Second
+
 // Adapter 企微适配器
 type Adapter struct {
-	cfg         config.WeComConfig
-	queue       *message.Queue
-	logger      *zap.Logger
-	server      *http.Server
-	accessToken string
-	tokenExpiry time.Time
-	tokenMu     sync.RWMutex
+	cfg      config.WeComConfig
+	queue    *message.Queue
+	logger   *zap.Logger
+	server   *http.Server
+	api      *apiClient
+	passive  *passiveReplies
+	progress *progressStore
+	events   dedup.Store
+	mu       sync.RWMutex
+	running  bool
 }
 
 // NewAdapter 创建新的企微适配器
 func NewAdapter(cfg config.WeComConfig, queue *message.Queue, logger *zap.Logger) *Adapter {
+	baseURL := strings.TrimRight(cfg.APIBase, "/")
+	if baseURL == "" {
+		baseURL = defaultAPIBase
+	}
+	httpClient := newHTTPClient(cfg, logger)
+
 	return &Adapter{
-		cfg:    cfg,
-		queue:  queue,
-		logger: logger,
+		cfg:      cfg,
+		queue:    queue,
+		logger:   logger,
+		passive:  newPassiveReplies(),
+		progress: newProgressStore(),
+		events:   dedup.NewMemoryStore(0),
+		api: &apiClient{
+			baseURL:    baseURL,
+			httpClient: httpClient,
+			tokens:     newTokenManager(cfg.CorpID, cfg.Secret, baseURL, httpClient, logger),
+		},
 	}
 }
 
+// SetDedupStore 设置入站事件去重存储，默认使用内存存储
+func (a *Adapter) SetDedupStore(store dedup.Store) {
+	a.events = store
+}
+
+// eventKey 返回回调的去重键：消息使用 MsgId，事件没有 MsgId，使用发送者、创建时间和事件类型
+// （进入应用与上报位置等事件可能在同一秒到达）
+func eventKey(msg *WeComDecryptedMessage) string {
+	if msg.MsgID != "" {
+		return dedup.Key(message.PlatformWeCom, msg.MsgID)
+	}
+	return dedup.Key(message.PlatformWeCom, fmt.Sprintf("%s:%d:%s:%s", msg.FromUserName, msg.CreateTime, msg.Event, msg.EventKey))
+}
+
+// newHTTPClient 按配置创建调用企微接口的 HTTP 客户端
+func newHTTPClient(cfg config.WeComConfig, logger *zap.Logger) *http.Client {
+	timeout := time.Duration(cfg.HTTPTimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			logger.Warn("Invalid WeCom proxy, ignored", zap.String("proxy", cfg.Proxy), zap.Error(err))
+		} else {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Name 返回平台标识
+func (a *Adapter) Name() string {
+	return message.PlatformWeCom
+}
+
+// Capabilities 返回企微适配器支持的能力
+func (a *Adapter) Capabilities() platform.Capabilities {
+	return platform.Capabilities{
+		Images: true,
+		Files:  true,
+		Cards:  true,
+	}
+}
+
+// Running 适配器是否在运行
+func (a *Adapter) Running() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.running
+}
+
 // Start 启动适配器
 func (a *Adapter) Start(ctx context.Context) error {
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		return fmt.Errorf("adapter is already running")
+	}
+	a.running = true
+	a.mu.Unlock()
+
+	// 后台提前刷新 access_token
+	go a.api.tokens.run(ctx)
+
 	// 设置 Gin 为发布模式
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
@@ -68,19 +155,34 @@ func (a *Adapter) Start(ctx context.Context) error {
 	)
 
 	// 在协程中启动服务器
+	serverErr := make(chan error, 1)
 	go func() {
 		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			a.logger.Error("WeCom server failed", zap.Error(err))
+			serverErr <- err
 		}
 	}()
 
-	// 等待上下文取消
-	<-ctx.Done()
-	return a.Stop()
+	// 等待上下文取消或服务器异常退出
+	select {
+	case <-ctx.Done():
+		return a.Stop()
+	case err := <-serverErr:
+		a.Stop()
+		return fmt.Errorf("WeCom server failed: %w", err)
+	}
 }
 
 // Stop 停止适配器
 func (a *Adapter) Stop() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.running {
+		return nil
+	}
+	a.running = false
+
 	if a.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -167,12 +269,54 @@ func (a *Adapter) handleCallback(c *gin.Context) {
 		return
 	}
 
-	// 转换为统一消息格式
-	msgObj := a.convertMessage(&decryptedMsg)
+	// 企微未及时收到响应时会重试回调，重试的消息只处理一次
+	key := eventKey(&decryptedMsg)
+	if dedup.Check(a.events, key, a.logger) {
+		a.logger.Info("Ignored duplicate WeCom callback", zap.String("key", key))
+		c.String(http.StatusOK, "success")
+		return
+	}
+
+	// 事件按配置直接回复、转为问题或忽略
+	var msgObj *message.Message
+	if decryptedMsg.MsgType == "event" {
+		var reply []byte
+		msgObj, reply = a.handleEvent(&decryptedMsg)
+		if reply != nil {
+			c.Data(http.StatusOK, "text/xml; charset=utf-8", reply)
+			return
+		}
+	} else {
+		// 转换为统一消息格式
+		msgObj = a.convertMessage(&decryptedMsg)
+	}
+
+	// 开启被动回复时，推送前登记等待回复，避免回复先于登记到达
+	var replyCh chan []byte
+	if msgObj != nil && a.cfg.PassiveReply.Enabled && decryptedMsg.MsgID != "" {
+		replyCh = a.passive.register(decryptedMsg.MsgID)
+	}
 
 	// 推送到消息队列
 	if msgObj != nil {
-		a.queue.Push(msgObj)
+		if err := a.queue.Push(msgObj); err != nil {
+			a.logger.Error("Failed to push WeCom message to queue",
+				zap.String("session_id", msgObj.SessionID),
+				zap.Error(err),
+			)
+			a.passive.take(decryptedMsg.MsgID)
+			replyCh = nil
+			// 入队失败时允许重试的回调重新处理
+			a.events.Forget(key)
+		}
+	}
+
+	// 等待回复，超时后响应 success，回复由 Send 主动发送
+	if replyCh != nil {
+		if reply := a.waitPassiveReply(replyCh, decryptedMsg.MsgID); reply != nil {
+			c.Data(http.StatusOK, "text/xml; charset=utf-8", reply)
+			return
+		}
 	}
 
 	c.String(http.StatusOK, "success")
@@ -190,6 +334,7 @@ func (a *Adapter) convertMessage(msg *WeComDecryptedMessage) *message.Message {
 			"msg_id":      msg.MsgID,
 			"to_user":     msg.ToUserName,
 			"create_time": fmt.Sprintf("%d", msg.CreateTime),
+			"chat_type":   "p2p", // 应用消息均为单聊
 		},
 	}
 
@@ -203,6 +348,19 @@ func (a *Adapter) convertMessage(msg *WeComDecryptedMessage) *message.Message {
 		}
 	}
 
+	// 处理视频、文件消息，由管道下载后交给 Agent
+	if msg.MsgType == "video" || msg.MsgType == "file" {
+		if msg.MediaID != "" {
+			msgObj.Metadata["media_id"] = msg.MediaID
+		}
+		if msg.ThumbMediaID != "" {
+			msgObj.Metadata["thumb_media_id"] = msg.ThumbMediaID
+		}
+		if msg.FileName != "" {
+			msgObj.Metadata["file_name"] = msg.FileName
+		}
+	}
+
 	// 处理语音消息
 	if msg.MsgType == "voice" {
 		if msg.MediaID != "" {
@@ -225,6 +383,10 @@ func (a *Adapter) getMessageType(wecomType string) string {
 		return "image"
 	case "voice":
 		return "voice"
+	case "video":
+		return "video"
+	case "file":
+		return "file"
 	default:
 		return "text"
 	}
@@ -233,14 +395,17 @@ func (a *Adapter) getMessageType(wecomType string) string {
 // verifySignature 验证签名
 func (a *Adapter) verifySignature(signature, timestamp, nonce, echostr string) bool {
 	// 企微签名算法：对 token、timestamp、nonce、echostr 进行字典序排序后拼接，然后进行 SHA1 加密
-	tokens := []string{a.cfg.Token, timestamp, nonce, echostr}
+	return a.signature(timestamp, nonce, echostr) == signature
+}
+
+// signature 计算消息签名：对 token、timestamp、nonce、密文进行字典序排序后拼接，然后进行 SHA1 加密
+func (a *Adapter) signature(timestamp, nonce, encrypted string) string {
+	tokens := []string{a.cfg.Token, timestamp, nonce, encrypted}
 	sort.Strings(tokens)
 	combined := strings.Join(tokens, "")
 
 	hash := sha1.Sum([]byte(combined))
-	calculatedSignature := fmt.Sprintf("%x", hash)
-
-	return calculatedSignature == signature
+	return fmt.Sprintf("%x", hash)
 }
 
 // decrypt 解密消息（AES-256-CBC）
@@ -252,21 +417,9 @@ func (a *Adapter) decrypt(encrypted, msgSignature, timestamp, nonce string) (str
 		return "", fmt.Errorf("failed to decode base64: %w", err)
 	}
 
-	// 解码 AES Key（EncodingAESKey 是 43 字节的 base64 字符串，需要补全到 44 字节）
-	aesKeyStr := a.cfg.EncodingAESKey
-	if len(aesKeyStr)%4 != 0 {
-		// 补全 base64 padding
-		padding := 4 - (len(aesKeyStr) % 4)
-		aesKeyStr += strings.Repeat("=", padding)
-	}
-	
-	aesKey, err := base64.StdEncoding.DecodeString(aesKeyStr)
+	aesKey, err := a.aesKey()
 	if err != nil {
-		return "", fmt.Errorf("failed to decode AES key: %w", err)
-	}
-
-	if len(aesKey) != 32 {
-		return "", fmt.Errorf("invalid AES key length: expected 32, got %d", len(aesKey))
+		return "", err
 	}
 
 	// 创建 AES 解密器
@@ -296,7 +449,7 @@ func (a *Adapter) decrypt(encrypted, msgSignature, timestamp, nonce string) (str
 
 	// 提取消息长度（第 16-20 字节，网络字节序大端）
 	contentLen := binary.BigEndian.Uint32(decrypted[16:20])
-	
+
 	// 验证消息长度
 	if int(contentLen) > len(decrypted)-20 {
 		return "", fmt.Errorf("invalid message length: %d > %d", contentLen, len(decrypted)-20)
@@ -326,6 +479,25 @@ func (a *Adapter) decrypt(encrypted, msgSignature, timestamp, nonce string) (str
 	return string(content), nil
 }
 
+// aesKey 解码 EncodingAESKey（43 字节的 base64 字符串，需要补全到 44 字节）
+func (a *Adapter) aesKey() ([]byte, error) {
+	aesKeyStr := a.cfg.EncodingAESKey
+	if len(aesKeyStr)%4 != 0 {
+		// 补全 base64 padding
+		padding := 4 - (len(aesKeyStr) % 4)
+		aesKeyStr += strings.Repeat("=", padding)
+	}
+
+	aesKey, err := base64.StdEncoding.DecodeString(aesKeyStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode AES key: %w", err)
+	}
+	if len(aesKey) != 32 {
+		return nil, fmt.Errorf("invalid AES key length: expected 32, got %d", len(aesKey))
+	}
+	return aesKey, nil
+}
+
 // pkcs7Unpad 去除 PKCS7 填充
 func (a *Adapter) pkcs7Unpad(data []byte) []byte {
 	if len(data) == 0 {
@@ -343,198 +515,12 @@ func (a *Adapter) pkcs7Unpad(data []byte) []byte {
 	return data[:len(data)-padding]
 }
 
-// getAccessToken 获取 access_token
-func (a *Adapter) getAccessToken() (string, error) {
-	a.tokenMu.RLock()
-	if a.accessToken != "" && time.Now().Before(a.tokenExpiry) {
-		token := a.accessToken
-		a.tokenMu.RUnlock()
-		return token, nil
-	}
-	a.tokenMu.RUnlock()
-
-	a.tokenMu.Lock()
-	defer a.tokenMu.Unlock()
-
-	// 双重检查
-	if a.accessToken != "" && time.Now().Before(a.tokenExpiry) {
-		return a.accessToken, nil
-	}
-
-	// 获取新的 access_token
-	url := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=%s&corpsecret=%s",
-		a.cfg.CorpID, a.cfg.Secret)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to get access token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// 解析 JSON 响应
-	var result struct {
-		ErrCode     int    `json:"errcode"`
-		ErrMsg      string `json:"errmsg"`
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if result.ErrCode != 0 {
-		return "", fmt.Errorf("failed to get access token: %d %s", result.ErrCode, result.ErrMsg)
-	}
-
-	a.accessToken = result.AccessToken
-	// 提前 5 分钟过期，避免边界情况
-	a.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn-300) * time.Second)
-
-	return a.accessToken, nil
-}
-
-// SendMessage 发送消息
-func (a *Adapter) SendMessage(sessionID string, content string) error {
-	// 获取 access_token
-	token, err := a.getAccessToken()
-	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
-	}
-
-	// 企微发送消息 API
-	url := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=%s", token)
-
-	// 构建请求体
-	reqBody := map[string]interface{}{
-		"touser":  sessionID,
-		"msgtype": "text",
-		"agentid": a.cfg.AgentID, // 需要从配置中获取
-		"text": map[string]string{
-			"content": content,
-		},
-		"safe": 0,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// 发送请求
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// 解析响应
-	var result struct {
-		ErrCode int    `json:"errcode"`
-		ErrMsg  string `json:"errmsg"`
-		MsgID   string `json:"msgid"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if result.ErrCode != 0 {
-		return fmt.Errorf("failed to send message: %d %s", result.ErrCode, result.ErrMsg)
-	}
-
-	a.logger.Debug("Sent message to WeCom",
-		zap.String("session_id", sessionID),
-		zap.String("msg_id", result.MsgID),
-	)
-
-	return nil
-}
-
-// SendImageMessage 发送图片消息
-func (a *Adapter) SendImageMessage(sessionID string, imageData []byte) error {
-	// 先上传图片获取 media_id
-	mediaID, err := a.uploadMedia("image", imageData)
-	if err != nil {
-		return fmt.Errorf("failed to upload image: %w", err)
-	}
-
-	// 获取 access_token
-	token, err := a.getAccessToken()
-	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
-	}
-
-	// 发送图片消息
-	url := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/message/send?access_token=%s", token)
-
-	reqBody := map[string]interface{}{
-		"touser":  sessionID,
-		"msgtype": "image",
-		"agentid": a.cfg.AgentID,
-		"image": map[string]string{
-			"media_id": mediaID,
-		},
-		"safe": 0,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var result struct {
-		ErrCode int    `json:"errcode"`
-		ErrMsg  string `json:"errmsg"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if result.ErrCode != 0 {
-		return fmt.Errorf("failed to send message: %d %s", result.ErrCode, result.ErrMsg)
-	}
-
-	return nil
-}
-
-// uploadMedia 上传媒体文件
-func (a *Adapter) uploadMedia(mediaType string, mediaData []byte) (string, error) {
-	// 获取 access_token
-	token, err := a.getAccessToken()
-	if err != nil {
-		return "", fmt.Errorf("failed to get access token: %w", err)
-	}
-
-	// 上传媒体文件
-	url := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/media/upload?access_token=%s&type=%s", token, mediaType)
-
+// uploadMedia 上传临时素材，返回 media_id
+func (a *Adapter) uploadMedia(ctx context.Context, mediaType string, fileName string, mediaData []byte) (string, error) {
 	// 创建 multipart form
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
-	part, err := writer.CreateFormFile("media", "media")
+	part, err := writer.CreateFormFile("media", fileName)
 	if err != nil {
 		return "", fmt.Errorf("failed to create form file: %w", err)
 	}
@@ -547,44 +533,30 @@ func (a *Adapter) uploadMedia(mediaType string, mediaData []byte) (string, error
 		return "", fmt.Errorf("failed to close writer: %w", err)
 	}
 
-	// 发送请求
-	resp, err := http.Post(url, writer.FormDataContentType(), &buf)
+	query := url.Values{}
+	query.Set("type", mediaType)
+	_, body, err := a.api.request(ctx, http.MethodPost, "/cgi-bin/media/upload", query, writer.FormDataContentType(), buf.Bytes(), maxJSONResponse)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload media: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
 
 	var result struct {
-		ErrCode  int    `json:"errcode"`
-		ErrMsg   string `json:"errmsg"`
-		Type     string `json:"type"`
-		MediaID  string `json:"media_id"`
-		CreatedAt int64 `json:"created_at"`
+		Type    string `json:"type"`
+		MediaID string `json:"media_id"`
 	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if result.ErrCode != 0 {
-		return "", fmt.Errorf("failed to upload media: %d %s", result.ErrCode, result.ErrMsg)
+	if err := decodeJSON(body, &result); err != nil {
+		return "", err
 	}
-
 	return result.MediaID, nil
 }
 
 // WeComMessage 企微消息（加密后）
 type WeComMessage struct {
-	XMLName     xml.Name `xml:"xml"`
-	Encrypt     string   `xml:"Encrypt"`
-	MsgSignature string  `xml:"MsgSignature"`
-	TimeStamp   string   `xml:"TimeStamp"`
-	Nonce       string   `xml:"Nonce"`
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      string   `xml:"Encrypt"`
+	MsgSignature string   `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        string   `xml:"Nonce"`
 }
 
 // WeComDecryptedMessage 企微消息（解密后）
@@ -599,4 +571,14 @@ type WeComDecryptedMessage struct {
 	PicURL       string   `xml:"PicUrl,omitempty"`
 	MediaID      string   `xml:"MediaId,omitempty"`
 	Format       string   `xml:"Format,omitempty"`
+	ThumbMediaID string   `xml:"ThumbMediaId,omitempty"`
+	FileName     string   `xml:"FileName,omitempty"`
+
+	// 事件消息（MsgType 为 event）
+	Event     string `xml:"Event,omitempty"`
+	EventKey  string `xml:"EventKey,omitempty"` // 菜单的 key、跳转的 URL 或模板卡片按钮的 key
+	TaskID    string `xml:"TaskId,omitempty"`   // 模板卡片的 task_id
+	Latitude  string `xml:"Latitude,omitempty"`
+	Longitude string `xml:"Longitude,omitempty"`
+	Precision string `xml:"Precision,omitempty"`
 }
@@ -0,0 +1,144 @@
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"xia_adpter/internal/platform"
+)
+
+const (
+	// defaultAPIBase 企微接口地址
+	defaultAPIBase = "https://qyapi.weixin.qq.com"
+	// maxJSONResponse JSON 接口响应的大小上限
+	maxJSONResponse = 1 << 20
+)
+
+// tokenErrorCodes access_token 无效或过期的错误码，刷新 token 后重试一次
+var tokenErrorCodes = map[int]bool{
+	40001: true, // 不合法的 secret 或 access_token
+	40014: true, // 不合法的 access_token
+	42001: true, // access_token 已过期
+}
+
+// retryableCodes 稍后重试可能成功的企微错误码
+var retryableCodes = map[int]bool{
+	-1:    true, // 系统繁忙
+	40014: true, // access_token 无效
+	42001: true, // access_token 已过期
+	45009: true, // 接口调用超过限制
+	45033: true, // 接口并发调用超过限制
+}
+
+// apiClient 企微接口客户端，自动附加 access_token，token 失效时刷新后重试一次
+// 发送消息、上传下载素材等接口共用
+type apiClient struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     *tokenManager
+}
+
+// postJSON 以 JSON 调用接口，响应解析到 out（可为 nil）
+func (c *apiClient) postJSON(ctx context.Context, path string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	_, data, err := c.request(ctx, http.MethodPost, path, nil, "application/json", body, maxJSONResponse)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(data, out)
+}
+
+// request 调用接口，返回响应头和内容；响应超过 limit 字节时返回错误
+// 接口返回错误码时返回 *platform.SendError，token 失效的错误码会先刷新 token 重试一次
+func (c *apiClient) request(ctx context.Context, method, path string, query url.Values, contentType string, body []byte, limit int64) (http.Header, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		header, data, err := c.do(ctx, method, path, token, query, contentType, body, limit)
+		var apiErr *platform.SendError
+		if attempt == 0 && errors.As(err, &apiErr) && tokenErrorCodes[apiErr.Code] {
+			c.tokens.Invalidate(token)
+			continue
+		}
+		return header, data, err
+	}
+}
+
+// do 发送一次请求
+func (c *apiClient) do(ctx context.Context, method, path, token string, query url.Values, contentType string, body []byte, limit int64) (http.Header, []byte, error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("access_token", token)
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path+"?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to call %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, fmt.Errorf("response of %s too large (max %d bytes)", path, limit)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, nil, &platform.SendError{Op: path, Code: resp.StatusCode, Msg: http.StatusText(resp.StatusCode), Retryable: true}
+	}
+
+	// 下载素材等接口出错时也返回 JSON 错误信息
+	respType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(respType, "application/json") || strings.HasPrefix(respType, "text/plain") {
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if err := json.Unmarshal(data, &result); err == nil && result.ErrCode != 0 {
+			return nil, nil, &platform.SendError{
+				Op:        path,
+				Code:      result.ErrCode,
+				Msg:       result.ErrMsg,
+				Retryable: retryableCodes[result.ErrCode],
+			}
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to call %s: HTTP %d", path, resp.StatusCode)
+	}
+	return resp.Header, data, nil
+}
+
+// decodeJSON 解析响应，out 为 nil 时忽略
+func decodeJSON(data []byte, out interface{}) error {
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
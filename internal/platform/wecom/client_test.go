@@ -0,0 +1,59 @@
+package wecom
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"xia_adpter/internal/config"
+
+	"go.uber.org/zap"
+)
+
+func TestClientRefreshesRevokedToken(t *testing.T) {
+	var issued, sent atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			n := issued.Add(1)
+			fmt.Fprintf(w, `{"errcode":0,"access_token":"token%d","expires_in":7200}`, n)
+		case "/cgi-bin/message/send":
+			sent.Add(1)
+			if r.URL.Query().Get("access_token") == "token1" {
+				fmt.Fprint(w, `{"errcode":42001,"errmsg":"access_token expired"}`)
+				return
+			}
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":"m1"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	a := NewAdapter(config.WeComConfig{CorpID: "corp", Secret: "secret", APIBase: server.URL}, nil, zap.NewNop())
+
+	var result struct {
+		MsgID string `json:"msgid"`
+	}
+	if err := a.api.postJSON(context.Background(), "/cgi-bin/message/send", map[string]string{}, &result); err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	if result.MsgID != "m1" {
+		t.Errorf("msgid = %q, want m1", result.MsgID)
+	}
+	if issued.Load() != 2 || sent.Load() != 2 {
+		t.Errorf("gettoken calls = %d, send calls = %d; want 2, 2", issued.Load(), sent.Load())
+	}
+
+	// 刷新后的 token 被缓存
+	if err := a.api.postJSON(context.Background(), "/cgi-bin/message/send", map[string]string{}, nil); err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	if issued.Load() != 2 {
+		t.Errorf("token not cached: gettoken calls = %d", issued.Load())
+	}
+}
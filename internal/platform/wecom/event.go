@@ -0,0 +1,96 @@
+package wecom
+
+import (
+	"strings"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+// 事件的处理方式
+const (
+	eventActionIgnore = "ignore"
+	eventActionReply  = "reply"
+	eventActionQuery  = "query"
+)
+
+// defaultEventRules 未配置时的事件处理：推荐问题按钮以按钮上的问题提问，其余事件忽略
+var defaultEventRules = []config.WeComEventConfig{
+	{Event: "template_card_event", Action: eventActionQuery, Text: "{key}"},
+}
+
+// handleEvent 按配置处理事件：query 时返回发送给 Agent 的消息，reply 时返回加密后的回调响应，
+// 两者都为 nil 时事件被忽略
+func (a *Adapter) handleEvent(event *WeComDecryptedMessage) (*message.Message, []byte) {
+	rule, ok := a.eventRule(event)
+	text := strings.TrimSpace(expandEventText(rule.Text, event))
+	if !ok || text == "" {
+		a.logger.Debug("Ignored WeCom event",
+			zap.String("event", event.Event),
+			zap.String("event_key", event.EventKey),
+			zap.String("user_id", event.FromUserName),
+		)
+		return nil, nil
+	}
+
+	switch rule.Action {
+	case eventActionReply:
+		reply, err := a.encryptReply(event.FromUserName, text)
+		if err != nil {
+			a.logger.Error("Failed to encrypt WeCom event reply", zap.String("event", event.Event), zap.Error(err))
+			return nil, nil
+		}
+		return nil, reply
+
+	case eventActionQuery:
+		msgObj := a.convertMessage(event)
+		msgObj.Content = text
+		msgObj.MessageType = message.MessageTypeText
+		msgObj.Metadata["event"] = event.Event
+		if event.EventKey != "" {
+			msgObj.Metadata["event_key"] = event.EventKey
+		}
+		if event.TaskID != "" {
+			msgObj.Metadata["task_id"] = event.TaskID
+		}
+		a.logger.Info("Received WeCom event",
+			zap.String("event", event.Event),
+			zap.String("event_key", event.EventKey),
+			zap.String("user_id", event.FromUserName),
+		)
+		return msgObj, nil
+	}
+
+	if rule.Action != eventActionIgnore {
+		a.logger.Warn("Unknown WeCom event action", zap.String("event", event.Event), zap.String("action", rule.Action))
+	}
+	return nil, nil
+}
+
+// eventRule 按顺序返回第一条匹配事件的规则，配置的规则优先于默认规则
+func (a *Adapter) eventRule(event *WeComDecryptedMessage) (config.WeComEventConfig, bool) {
+	for _, rules := range [][]config.WeComEventConfig{a.cfg.Events, defaultEventRules} {
+		for _, rule := range rules {
+			if !strings.EqualFold(rule.Event, event.Event) {
+				continue
+			}
+			if rule.EventKey != "" && rule.EventKey != event.EventKey {
+				continue
+			}
+			return rule, rule.Action != eventActionIgnore
+		}
+	}
+	return config.WeComEventConfig{}, false
+}
+
+// expandEventText 替换回复内容或问题中的占位符
+func expandEventText(text string, event *WeComDecryptedMessage) string {
+	return strings.NewReplacer(
+		"{key}", event.EventKey,
+		"{latitude}", event.Latitude,
+		"{longitude}", event.Longitude,
+		"{user}", event.FromUserName,
+	).Replace(text)
+}
@@ -0,0 +1,42 @@
+package wecom
+
+import (
+	"testing"
+
+	"xia_adpter/internal/config"
+)
+
+func TestHandleEvent(t *testing.T) {
+	a := newPassiveTestAdapter()
+	a.cfg.Events = []config.WeComEventConfig{
+		{Event: "subscribe", Action: eventActionReply, Text: "欢迎"},
+		{Event: "click", EventKey: "help", Action: eventActionQuery, Text: "你能做什么？"},
+		{Event: "click", Action: eventActionIgnore},
+	}
+
+	// 推荐问题按钮默认以按钮上的问题提问
+	msg, reply := a.handleEvent(&WeComDecryptedMessage{FromUserName: "u1", MsgType: "event", Event: "template_card_event", EventKey: "明天天气如何", TaskID: "suggest_1"})
+	if msg == nil || reply != nil || msg.Content != "明天天气如何" || msg.Metadata["task_id"] != "suggest_1" {
+		t.Errorf("template_card_event: msg = %+v, reply = %q", msg, reply)
+	}
+
+	if msg, reply := a.handleEvent(&WeComDecryptedMessage{FromUserName: "u1", MsgType: "event", Event: "subscribe"}); msg != nil || reply == nil {
+		t.Errorf("subscribe: msg = %+v, reply = %q", msg, reply)
+	}
+
+	msg, _ = a.handleEvent(&WeComDecryptedMessage{FromUserName: "u1", MsgType: "event", Event: "click", EventKey: "help"})
+	if msg == nil || msg.Content != "你能做什么？" || msg.MessageType != "text" {
+		t.Errorf("click help: msg = %+v", msg)
+	}
+
+	// 未配置或配置为忽略的事件不发送空问题
+	for _, event := range []*WeComDecryptedMessage{
+		{FromUserName: "u1", MsgType: "event", Event: "click", EventKey: "other"},
+		{FromUserName: "u1", MsgType: "event", Event: "enter_agent"},
+		{FromUserName: "u1", MsgType: "event", Event: "LOCATION", Latitude: "23.1", Longitude: "113.3"},
+	} {
+		if msg, reply := a.handleEvent(event); msg != nil || reply != nil {
+			t.Errorf("%s %s not ignored: msg = %+v", event.Event, event.EventKey, msg)
+		}
+	}
+}
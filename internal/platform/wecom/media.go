@@ -0,0 +1,53 @@
+package wecom
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"xia_adpter/internal/message"
+)
+
+// maxMediaSize 下载临时素材的大小上限（变量以便测试调整）
+var maxMediaSize int64 = 30 << 20
+
+// DownloadMedia 下载消息中的图片、语音、视频、文件（临时素材），语音的格式为 amr
+func (a *Adapter) DownloadMedia(ctx context.Context, msg *message.Message) (*message.MediaFile, error) {
+	mediaID := msg.Metadata["media_id"]
+	if mediaID == "" {
+		return nil, fmt.Errorf("message has no media_id")
+	}
+
+	data, name, err := a.downloadMedia(ctx, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = msg.Metadata["file_name"]
+	}
+	return &message.MediaFile{
+		Type:     msg.MessageType,
+		Name:     name,
+		MimeType: http.DetectContentType(data),
+		Format:   msg.Metadata["format"],
+		Data:     data,
+	}, nil
+}
+
+// downloadMedia 调用获取临时素材接口下载文件，返回文件内容及文件名
+func (a *Adapter) downloadMedia(ctx context.Context, mediaID string) ([]byte, string, error) {
+	query := url.Values{}
+	query.Set("media_id", mediaID)
+	header, data, err := a.api.request(ctx, http.MethodGet, "/cgi-bin/media/get", query, "", nil, maxMediaSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download media: %w", err)
+	}
+
+	var name string
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	return data, name, nil
+}
@@ -0,0 +1,100 @@
+package wecom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// pngHeader PNG 文件头，用于验证按内容识别 MIME 类型
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+func newMediaServer(t *testing.T) *Adapter {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"errcode":0,"access_token":"token","expires_in":7200}`)
+		case "/cgi-bin/media/get":
+			switch r.URL.Query().Get("media_id") {
+			case "png":
+				w.Header().Set("Content-Type", "image/png")
+				w.Header().Set("Content-Disposition", `attachment; filename="photo.png"`)
+				fmt.Fprint(w, pngHeader+"data")
+			case "noname":
+				w.Header().Set("Content-Type", "application/octet-stream")
+				fmt.Fprint(w, "%PDF-1.4 body")
+			case "large":
+				w.Header().Set("Content-Type", "application/octet-stream")
+				fmt.Fprint(w, strings.Repeat("x", 2048))
+			default:
+				// 素材不存在时以 JSON 返回错误码（Content-Type 为 text/plain）
+				w.Header().Set("Content-Type", "text/plain")
+				fmt.Fprint(w, `{"errcode":40007,"errmsg":"invalid media_id"}`)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return NewAdapter(config.WeComConfig{CorpID: "corp", Secret: "secret", APIBase: server.URL}, nil, zap.NewNop())
+}
+
+func mediaMessage(msgType, mediaID string) *message.Message {
+	return &message.Message{
+		MessageType: msgType,
+		Metadata:    map[string]string{"media_id": mediaID, "file_name": "fallback.pdf"},
+	}
+}
+
+func TestDownloadMedia(t *testing.T) {
+	a := newMediaServer(t)
+
+	media, err := a.DownloadMedia(context.Background(), mediaMessage(message.MessageTypeImage, "png"))
+	if err != nil {
+		t.Fatalf("DownloadMedia: %v", err)
+	}
+	if media.Name != "photo.png" || media.MimeType != "image/png" || string(media.Data) != pngHeader+"data" {
+		t.Errorf("media = %s %s %q", media.Name, media.MimeType, media.Data)
+	}
+
+	// 响应没有文件名时使用消息中的文件名，MIME 类型按内容识别
+	media, err = a.DownloadMedia(context.Background(), mediaMessage(message.MessageTypeFile, "noname"))
+	if err != nil {
+		t.Fatalf("DownloadMedia: %v", err)
+	}
+	if media.Name != "fallback.pdf" || media.MimeType != "application/pdf" || media.Type != message.MessageTypeFile {
+		t.Errorf("media = %s %s %s", media.Type, media.Name, media.MimeType)
+	}
+}
+
+func TestDownloadMediaErrors(t *testing.T) {
+	a := newMediaServer(t)
+
+	_, err := a.DownloadMedia(context.Background(), mediaMessage(message.MessageTypeFile, "missing"))
+	var apiErr *platform.SendError
+	if !errors.As(err, &apiErr) || apiErr.Code != 40007 {
+		t.Errorf("err = %v, want errcode 40007", err)
+	}
+
+	if _, err := a.DownloadMedia(context.Background(), &message.Message{Metadata: map[string]string{}}); err == nil {
+		t.Error("message without media_id downloaded")
+	}
+
+	old := maxMediaSize
+	maxMediaSize = 1024
+	defer func() { maxMediaSize = old }()
+	if _, err := a.DownloadMedia(context.Background(), mediaMessage(message.MessageTypeFile, "large")); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("err = %v, want too large", err)
+	}
+}
@@ -0,0 +1,227 @@
+package wecom
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultPassiveReplyTimeout 默认等待被动回复的时间
+	defaultPassiveReplyTimeout = 4 * time.Second
+	// maxPassiveReplyTimeout 等待被动回复的时间上限，企微 5 秒内收不到响应会重试回调
+	maxPassiveReplyTimeout = 4500 * time.Millisecond
+	// encryptBlockSize 企微加密使用的 PKCS7 填充块大小
+	encryptBlockSize = 32
+)
+
+// passiveReplies 等待被动回复的回调请求，按企微消息 ID 索引
+// 回调与 Send 都通过 take 取出等待者，只有一方能取到：
+// Send 取到时必须向通道写入响应（为空表示改为主动发送），回调取到时表示已超时放弃等待
+type passiveReplies struct {
+	waiters map[string]chan []byte
+	mu      sync.Mutex
+}
+
+func newPassiveReplies() *passiveReplies {
+	return &passiveReplies{waiters: make(map[string]chan []byte)}
+}
+
+// register 登记等待回复的消息
+func (r *passiveReplies) register(msgID string) chan []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan []byte, 1)
+	r.waiters[msgID] = ch
+	return ch
+}
+
+// take 取出并删除等待者
+func (r *passiveReplies) take(msgID string) (chan []byte, bool) {
+	if msgID == "" {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.waiters[msgID]
+	if ok {
+		delete(r.waiters, msgID)
+	}
+	return ch, ok
+}
+
+// passiveReplyTimeout 返回等待被动回复的时间，不超过企微的响应时限
+func (a *Adapter) passiveReplyTimeout() time.Duration {
+	timeout := time.Duration(a.cfg.PassiveReply.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultPassiveReplyTimeout
+	}
+	return min(timeout, maxPassiveReplyTimeout)
+}
+
+// waitPassiveReply 等待管道的回复，返回加密后的响应；超时或回复不能被动发送时返回 nil
+func (a *Adapter) waitPassiveReply(ch chan []byte, msgID string) []byte {
+	timer := time.NewTimer(a.passiveReplyTimeout())
+	defer timer.Stop()
+
+	select {
+	case reply := <-ch:
+		return reply
+	case <-timer.C:
+	}
+	if _, ok := a.passive.take(msgID); ok {
+		a.logger.Debug("Passive reply timed out, falling back to active send", zap.String("msg_id", msgID))
+		return nil
+	}
+	// Send 已取走等待者，回复即将写入
+	return <-ch
+}
+
+// replyPassively 尝试把回复交给等待中的回调请求，返回是否已交付
+// 被动回复只支持单条纯文本，其余内容改为主动发送
+func (a *Adapter) replyPassively(msg *message.OutboundMessage, format string) bool {
+	ch, ok := a.passive.take(msg.ReplyTo)
+	if !ok {
+		return false
+	}
+
+	content, ok := passiveText(msg, format)
+	if !ok {
+		ch <- nil
+		return false
+	}
+	reply, err := a.encryptReply(msg.SessionID, content)
+	if err != nil {
+		a.logger.Warn("Failed to encrypt passive reply, falling back to active send", zap.Error(err))
+		ch <- nil
+		return false
+	}
+	ch <- reply
+	return true
+}
+
+// passiveText 返回可被动回复的纯文本：只有一个文本片段、没有推荐问题、按纯文本渲染且不需要分段
+func passiveText(msg *message.OutboundMessage, format string) (string, bool) {
+	if len(msg.Parts) != 1 || len(msg.Suggestions) > 0 {
+		return "", false
+	}
+	part := msg.Parts[0]
+	switch {
+	case part.Type == message.PartText && (format == message.ReplyFormatText || format == message.ReplyFormatAuto):
+	case part.Type == message.PartMarkdown && format == message.ReplyFormatText:
+	default:
+		return "", false
+	}
+	if part.Text == "" || len(part.Text) > maxTextBytes {
+		return "", false
+	}
+	return part.Text, true
+}
+
+// passiveTextMessage 被动回复的文本消息（加密前）
+type passiveTextMessage struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   cdata    `xml:"ToUserName"`
+	FromUserName cdata    `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      cdata    `xml:"MsgType"`
+	Content      cdata    `xml:"Content"`
+}
+
+// encryptedReply 加密后的被动回复
+type encryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      cdata    `xml:"Encrypt"`
+	MsgSignature cdata    `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        cdata    `xml:"Nonce"`
+}
+
+// cdata 以 CDATA 输出的 XML 文本
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// encryptReply 生成回复给 toUser 的加密文本消息，带新的时间戳、随机串和签名
+func (a *Adapter) encryptReply(toUser, content string) ([]byte, error) {
+	now := time.Now()
+	plain, err := xml.Marshal(passiveTextMessage{
+		ToUserName:   cdata{toUser},
+		FromUserName: cdata{a.cfg.CorpID},
+		CreateTime:   now.Unix(),
+		MsgType:      cdata{"text"},
+		Content:      cdata{content},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal reply: %w", err)
+	}
+
+	encrypted, err := a.encrypt(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonceStr := fmt.Sprintf("%x", nonce)
+
+	return xml.Marshal(encryptedReply{
+		Encrypt:      cdata{encrypted},
+		MsgSignature: cdata{a.signature(timestamp, nonceStr, encrypted)},
+		TimeStamp:    timestamp,
+		Nonce:        cdata{nonceStr},
+	})
+}
+
+// encrypt 加密消息（AES-256-CBC），格式与 decrypt 相同：
+// 随机16字节 + 消息长度4字节(网络字节序) + 消息内容 + CorpID，按 32 字节 PKCS7 填充后 base64 编码
+func (a *Adapter) encrypt(plain []byte) (string, error) {
+	aesKey, err := a.aesKey()
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, 20, 20+len(plain)+len(a.cfg.CorpID)+encryptBlockSize)
+	if _, err := rand.Read(data[:16]); err != nil {
+		return "", fmt.Errorf("failed to generate random prefix: %w", err)
+	}
+	binary.BigEndian.PutUint32(data[16:20], uint32(len(plain)))
+	data = append(data, plain...)
+	data = append(data, a.cfg.CorpID...)
+	data = pkcs7Pad(data, encryptBlockSize)
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+	encrypted := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, aesKey[:16]).CryptBlocks(encrypted, data)
+
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// pkcs7Pad 按 blockSize 添加 PKCS7 填充
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	for i := 0; i < padding; i++ {
+		data = append(data, byte(padding))
+	}
+	return data
+}
@@ -0,0 +1,79 @@
+package wecom
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+func newPassiveTestAdapter() *Adapter {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	return NewAdapter(config.WeComConfig{
+		CorpID:         "corp",
+		Token:          "token",
+		EncodingAESKey: strings.TrimRight(key, "="),
+		PassiveReply:   config.WeComPassiveReplyConfig{Enabled: true},
+	}, nil, zap.NewNop())
+}
+
+func TestEncryptReplyRoundTrip(t *testing.T) {
+	a := newPassiveTestAdapter()
+
+	data, err := a.encryptReply("user1", "你好 <world>")
+	if err != nil {
+		t.Fatalf("encryptReply: %v", err)
+	}
+	var envelope WeComMessage
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("unmarshal envelope: %v", err)
+	}
+	if !a.verifySignature(envelope.MsgSignature, envelope.TimeStamp, envelope.Nonce, envelope.Encrypt) {
+		t.Fatal("signature mismatch")
+	}
+
+	plain, err := a.decrypt(envelope.Encrypt, envelope.MsgSignature, envelope.TimeStamp, envelope.Nonce)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	var reply WeComDecryptedMessage
+	if err := xml.Unmarshal([]byte(plain), &reply); err != nil {
+		t.Fatalf("unmarshal reply: %v", err)
+	}
+	if reply.ToUserName != "user1" || reply.FromUserName != "corp" || reply.MsgType != "text" || reply.Content != "你好 <world>" {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestReplyPassively(t *testing.T) {
+	a := newPassiveTestAdapter()
+
+	ch := a.passive.register("m1")
+	out := message.NewOutboundMessage("user1", message.TextPart("hi"))
+	out.ReplyTo = "m1"
+	if !a.replyPassively(out, message.ReplyFormatAuto) {
+		t.Fatal("text reply not delivered passively")
+	}
+	if reply := <-ch; reply == nil {
+		t.Error("empty passive reply")
+	}
+	if a.replyPassively(out, message.ReplyFormatAuto) {
+		t.Error("second reply delivered to the same callback")
+	}
+
+	// Markdown 回复改为主动发送，同时通知回调不再等待
+	ch = a.passive.register("m2")
+	out = message.NewOutboundMessage("user1", message.MarkdownPart("**hi**"))
+	out.ReplyTo = "m2"
+	if a.replyPassively(out, message.ReplyFormatAuto) {
+		t.Error("markdown reply delivered passively")
+	}
+	if reply := <-ch; reply != nil {
+		t.Error("callback not released with empty reply")
+	}
+}
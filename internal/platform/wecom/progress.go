@@ -0,0 +1,124 @@
+package wecom
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// sendProgressTTL 未完成投递的进度保留时间，覆盖管道重试和死信重发
+const sendProgressTTL = 24 * time.Hour
+
+// sendProgress 同一幂等键的投递进度
+// 同一出站消息每次渲染出的企微消息、上传的素材顺序相同，重试时按顺序跳过已送达的消息、复用已上传的 media_id
+type sendProgress struct {
+	delivered int      // 已按顺序送达的消息数
+	media     []string // 已按顺序上传的 media_id
+	expires   time.Time
+}
+
+// progressStore 按幂等键保存未完成的投递进度
+type progressStore struct {
+	entries map[string]*sendProgress
+	mu      sync.Mutex
+}
+
+func newProgressStore() *progressStore {
+	return &progressStore{entries: make(map[string]*sendProgress)}
+}
+
+// get 返回幂等键的投递进度，不存在时创建，并清理过期的进度
+func (s *progressStore) get(key string) *sendProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if p, ok := s.entries[key]; ok && now.Before(p.expires) {
+		return p
+	}
+	for k, p := range s.entries {
+		if now.After(p.expires) {
+			delete(s.entries, k)
+		}
+	}
+	p := &sendProgress{expires: now.Add(sendProgressTTL)}
+	s.entries[key] = p
+	return p
+}
+
+// remove 投递完成后删除进度
+func (s *progressStore) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// sendCursor 单次 Send 在投递进度中的位置，通过上下文传给 postMessage、uploadMedia
+type sendCursor struct {
+	progress *sendProgress
+	posts    int
+	uploads  int
+}
+
+// sendCursorKey 上下文中保存 *sendCursor 的键
+type sendCursorKey struct{}
+
+// withSendCursor 为有幂等键的投递在上下文中附加进度游标
+func (a *Adapter) withSendCursor(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sendCursorKey{}, &sendCursor{progress: a.progress.get(key)})
+}
+
+// cursorFrom 返回上下文中的进度游标，没有幂等键时为 nil
+func cursorFrom(ctx context.Context) *sendCursor {
+	c, _ := ctx.Value(sendCursorKey{}).(*sendCursor)
+	return c
+}
+
+// skipPost 当前消息在之前的尝试中已送达时返回 true
+func (c *sendCursor) skipPost() bool {
+	if c == nil || c.posts >= c.progress.delivered {
+		return false
+	}
+	c.posts++
+	return true
+}
+
+// posted 记录当前消息已送达
+func (c *sendCursor) posted() {
+	if c == nil {
+		return
+	}
+	c.posts++
+	c.progress.delivered = c.posts
+}
+
+// cachedMedia 返回之前的尝试中按相同顺序上传的 media_id
+func (c *sendCursor) cachedMedia() (string, bool) {
+	if c == nil || c.uploads >= len(c.progress.media) {
+		return "", false
+	}
+	id := c.progress.media[c.uploads]
+	c.uploads++
+	return id, true
+}
+
+// uploaded 记录上传的 media_id
+func (c *sendCursor) uploaded(mediaID string) {
+	if c == nil {
+		return
+	}
+	c.progress.media = append(c.progress.media, mediaID)
+	c.uploads++
+}
+
+// suggestionTaskID 由幂等键派生推荐问题卡片的 task_id，重试时保持不变
+// task_id 只能包含数字、字母和 _-@，使用幂等键的 MD5
+func suggestionTaskID(key string) string {
+	sum := md5.Sum([]byte(key))
+	return "suggest_" + hex.EncodeToString(sum[:])
+}
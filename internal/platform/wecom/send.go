@@ -0,0 +1,215 @@
+package wecom
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"xia_adpter/internal/message"
+	"xia_adpter/internal/platform"
+
+	"go.uber.org/zap"
+)
+
+// maxTextBytes 企微文本、Markdown 消息的长度上限
+const maxTextBytes = 2048
+
+// duplicateCheckInterval 重试时重复消息检查的时间间隔（秒）
+const duplicateCheckInterval = 1800
+
+// duplicateCheckKey 上下文中标记本次发送需要开启重复消息检查
+type duplicateCheckKey struct{}
+
+// Send 发送应用消息，每个片段渲染为一条或多条企微消息
+// text、markdown 按回复格式渲染（超长时分段发送），image、file 先上传再发送，card 以模板卡片发送，
+// 推荐问题以按钮卡片附在最后；应用消息不支持 @ 和引用回复，Mentions 被忽略。
+// ReplyTo 对应的回调仍在等待被动回复时，单条纯文本回复在回调响应中返回，其余内容主动发送。
+// 企微接口没有幂等键：按 IdempotencyKey 记录已送达的消息和已上传的素材，重试时跳过已送达的消息；
+// 重试时还会开启重复消息检查，作为没有幂等键时的兜底
+func (a *Adapter) Send(ctx context.Context, msg *message.OutboundMessage) error {
+	format := a.replyFormat(msg)
+	if a.replyPassively(msg, format) {
+		return nil
+	}
+
+	if msg.Attempt > 1 {
+		ctx = context.WithValue(ctx, duplicateCheckKey{}, true)
+	}
+	ctx = a.withSendCursor(ctx, msg.IdempotencyKey)
+	for _, part := range msg.Parts {
+		if err := a.sendPart(ctx, msg.SessionID, format, part); err != nil {
+			return err
+		}
+	}
+	if err := a.sendSuggestions(ctx, msg.SessionID, msg.IdempotencyKey, format, msg.Suggestions); err != nil {
+		return err
+	}
+	if msg.IdempotencyKey != "" {
+		a.progress.remove(msg.IdempotencyKey)
+	}
+	return nil
+}
+
+// replyFormat 返回回复格式，路由在元数据中指定的格式优先于配置
+func (a *Adapter) replyFormat(msg *message.OutboundMessage) string {
+	if format := msg.Metadata[message.MetadataReplyFormat]; format != "" {
+		return format
+	}
+	if a.cfg.ReplyFormat != "" {
+		return a.cfg.ReplyFormat
+	}
+	return message.ReplyFormatAuto
+}
+
+// sendPart 发送单个片段
+func (a *Adapter) sendPart(ctx context.Context, sessionID, format string, part message.Part) error {
+	switch part.Type {
+	case message.PartText, message.PartMarkdown:
+		return a.sendText(ctx, sessionID, format, part)
+
+	case message.PartImage:
+		mediaID, err := a.uploadPart(ctx, "image", part)
+		if err != nil {
+			return fmt.Errorf("failed to upload image: %w", err)
+		}
+		return a.postMessage(ctx, sessionID, "image", map[string]string{
+			"media_id": mediaID,
+		})
+
+	case message.PartFile:
+		mediaID, err := a.uploadPart(ctx, "file", part)
+		if err != nil {
+			return fmt.Errorf("failed to upload file: %w", err)
+		}
+		return a.postMessage(ctx, sessionID, "file", map[string]string{
+			"media_id": mediaID,
+		})
+
+	case message.PartCard:
+		if len(part.Card) == 0 {
+			return fmt.Errorf("card part is empty")
+		}
+		return a.postMessage(ctx, sessionID, "template_card", part.Card)
+	}
+
+	return fmt.Errorf("unsupported part type: %s", part.Type)
+}
+
+// uploadPart 上传图片、文件片段，返回 media_id；之前的尝试中已上传时直接复用
+func (a *Adapter) uploadPart(ctx context.Context, mediaType string, part message.Part) (string, error) {
+	cursor := cursorFrom(ctx)
+	if mediaID, ok := cursor.cachedMedia(); ok {
+		return mediaID, nil
+	}
+
+	data, name, err := platform.PartData(ctx, part)
+	if err != nil {
+		return "", err
+	}
+	// 文件名会展示在聊天中，图片不展示
+	if mediaType == "image" || name == "" {
+		name = mediaType
+	}
+	mediaID, err := a.uploadMedia(ctx, mediaType, name, data)
+	if err != nil {
+		return "", err
+	}
+	cursor.uploaded(mediaID)
+	return mediaID, nil
+}
+
+// sendText 按回复格式发送文本：
+// text 原样发送纯文本；markdown 转换为企微 Markdown；
+// card 包含链接时发送文本卡片，否则按 markdown 发送；auto 按片段类型选择纯文本或 Markdown
+func (a *Adapter) sendText(ctx context.Context, sessionID, format string, part message.Part) error {
+	msgType := "text"
+	content := part.Text
+
+	switch format {
+	case message.ReplyFormatText:
+	case message.ReplyFormatCard:
+		if card, ok := message.WeComTextCard(part.Text); ok {
+			return a.postMessage(ctx, sessionID, "textcard", card)
+		}
+		msgType, content = "markdown", message.MarkdownToWeCom(part.Text)
+	case message.ReplyFormatMarkdown:
+		msgType, content = "markdown", message.MarkdownToWeCom(part.Text)
+	default:
+		if part.Type == message.PartMarkdown {
+			msgType, content = "markdown", message.MarkdownToWeCom(part.Text)
+		}
+	}
+
+	for _, chunk := range message.SplitText(content, maxTextBytes) {
+		err := a.postMessage(ctx, sessionID, msgType, map[string]string{
+			"content": chunk,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSuggestions 发送推荐问题，text 格式下以文本列出，其余格式发送按钮卡片
+// 有幂等键时卡片的 task_id 由幂等键派生，重试时保持不变
+func (a *Adapter) sendSuggestions(ctx context.Context, sessionID, key, format string, suggestions []string) error {
+	if len(suggestions) == 0 {
+		return nil
+	}
+
+	if format == message.ReplyFormatText {
+		var b strings.Builder
+		b.WriteString("猜你想问：")
+		for i, s := range suggestions {
+			fmt.Fprintf(&b, "\n%d. %s", i+1, s)
+		}
+		return a.sendText(ctx, sessionID, format, message.TextPart(b.String()))
+	}
+	card := message.WeComSuggestionCard(suggestions)
+	if key != "" {
+		card["task_id"] = suggestionTaskID(key)
+	}
+	return a.postMessage(ctx, sessionID, "template_card", card)
+}
+
+// postMessage 调用应用消息接口发送一条消息，body 为 msgtype 对应字段的内容
+// 之前的尝试中已送达的消息跳过
+func (a *Adapter) postMessage(ctx context.Context, sessionID string, msgType string, body interface{}) error {
+	cursor := cursorFrom(ctx)
+	if cursor.skipPost() {
+		a.logger.Debug("Skipped message delivered by a previous attempt",
+			zap.String("session_id", sessionID),
+			zap.String("msg_type", msgType),
+		)
+		return nil
+	}
+
+	reqBody := map[string]interface{}{
+		"touser":  sessionID,
+		"msgtype": msgType,
+		"agentid": a.cfg.AgentID,
+		msgType:   body,
+		"safe":    0,
+	}
+	if dup, _ := ctx.Value(duplicateCheckKey{}).(bool); dup {
+		reqBody["enable_duplicate_check"] = 1
+		reqBody["duplicate_check_interval"] = duplicateCheckInterval
+	}
+
+	var result struct {
+		MsgID string `json:"msgid"`
+	}
+	if err := a.api.postJSON(ctx, "/cgi-bin/message/send", reqBody, &result); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	cursor.posted()
+
+	a.logger.Debug("Sent message to WeCom",
+		zap.String("session_id", sessionID),
+		zap.String("msg_type", msgType),
+		zap.String("msg_id", result.MsgID),
+	)
+
+	return nil
+}
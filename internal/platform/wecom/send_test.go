@@ -0,0 +1,82 @@
+package wecom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"xia_adpter/internal/config"
+	"xia_adpter/internal/message"
+
+	"go.uber.org/zap"
+)
+
+func TestSendRetrySkipsDeliveredParts(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		uploads int
+		sent    []map[string]interface{}
+		failed  bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cgi-bin/gettoken":
+			fmt.Fprint(w, `{"errcode":0,"access_token":"token","expires_in":7200}`)
+		case "/cgi-bin/media/upload":
+			uploads++
+			fmt.Fprintf(w, `{"errcode":0,"type":"image","media_id":"media%d"}`, uploads)
+		case "/cgi-bin/message/send":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			// 第一次发送文本时限流
+			if body["msgtype"] == "text" && !failed {
+				failed = true
+				fmt.Fprint(w, `{"errcode":45009,"errmsg":"api freq out of limit"}`)
+				return
+			}
+			sent = append(sent, body)
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":"m"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	a := NewAdapter(config.WeComConfig{CorpID: "corp", Secret: "secret", APIBase: server.URL, ReplyFormat: "text"}, nil, zap.NewNop())
+
+	out := message.NewOutboundMessage("user1",
+		message.Part{Type: message.PartImage, Data: []byte("png")},
+		message.Part{Type: message.PartFile, Data: []byte("pdf"), Name: "a.pdf"},
+		message.TextPart("hello"),
+	)
+	out.IdempotencyKey = "msg1"
+	out.Attempt = 1
+	if err := a.Send(context.Background(), out); err == nil {
+		t.Fatal("first attempt succeeded, want rate limit error")
+	}
+	out.Attempt = 2
+	if err := a.Send(context.Background(), out); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	var types []string
+	for _, body := range sent {
+		types = append(types, body["msgtype"].(string))
+	}
+	if uploads != 2 {
+		t.Errorf("uploads = %d, want 2 (media reused on retry)", uploads)
+	}
+	if len(types) != 3 || types[0] != "image" || types[1] != "file" || types[2] != "text" {
+		t.Errorf("sent %v, want [image file text]", types)
+	}
+	if _, ok := a.progress.entries["msg1"]; ok {
+		t.Error("progress kept after delivery")
+	}
+}
@@ -0,0 +1,152 @@
+package wecom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// tokenExpiryMargin 提前视为过期的时间，避免使用即将过期的 token
+	tokenExpiryMargin = 5 * time.Minute
+	// tokenRefreshAhead 后台在过期前多久主动刷新
+	tokenRefreshAhead = 10 * time.Minute
+	// tokenRetryInterval 后台刷新失败后的重试间隔
+	tokenRetryInterval = 30 * time.Second
+)
+
+// tokenManager 管理企微 access_token
+// 缓存 token 直到过期前 tokenExpiryMargin；接口返回 token 失效的错误码时由调用方 Invalidate；
+// 启动后台刷新后在过期前 tokenRefreshAhead 主动刷新，请求时通常不需要等待获取 token
+type tokenManager struct {
+	corpID     string
+	secret     string
+	baseURL    string
+	httpClient *http.Client
+	logger     *zap.Logger
+
+	token  string
+	expiry time.Time // 企微返回的过期时间
+	mu     sync.Mutex
+}
+
+func newTokenManager(corpID, secret, baseURL string, httpClient *http.Client, logger *zap.Logger) *tokenManager {
+	return &tokenManager{
+		corpID:     corpID,
+		secret:     secret,
+		baseURL:    baseURL,
+		httpClient: httpClient,
+		logger:     logger,
+	}
+}
+
+// Token 返回有效的 access_token，缓存失效时重新获取
+func (m *tokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && time.Now().Before(m.expiry.Add(-tokenExpiryMargin)) {
+		return m.token, nil
+	}
+	if err := m.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return m.token, nil
+}
+
+// Invalidate 使 token 失效，下次 Token 时重新获取
+// 只在缓存的仍是 token 时失效，避免并发请求把刚刷新的 token 再次作废
+func (m *tokenManager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == token {
+		m.token = ""
+		m.logger.Info("WeCom access token invalidated")
+	}
+}
+
+// run 后台主动刷新 token，直到 ctx 取消
+func (m *tokenManager) run(ctx context.Context) {
+	for {
+		m.mu.Lock()
+		wait := time.Until(m.expiry.Add(-tokenRefreshAhead))
+		if m.token == "" {
+			wait = 0
+		}
+		m.mu.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		m.mu.Lock()
+		err := m.refreshLocked(ctx)
+		m.mu.Unlock()
+		if err == nil {
+			continue
+		}
+
+		m.logger.Warn("Failed to refresh WeCom access token", zap.Error(err))
+		timer := time.NewTimer(tokenRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshLocked 调用 gettoken 接口获取新的 token，调用方需持有 mu
+func (m *tokenManager) refreshLocked(ctx context.Context) error {
+	query := url.Values{}
+	query.Set("corpid", m.corpID)
+	query.Set("corpsecret", m.secret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+"/cgi-bin/gettoken?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJSONResponse))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var result struct {
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("failed to get access token: %d %s", result.ErrCode, result.ErrMsg)
+	}
+
+	m.token = result.AccessToken
+	m.expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	m.logger.Debug("WeCom access token refreshed", zap.Time("expiry", m.expiry))
+	return nil
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultFilePath 文件存储默认路径
+	defaultFilePath = "data/sessions.log"
+	// compactThreshold 日志记录数超过该值且多数已被覆盖、过期或删除时重写日志
+	compactThreshold = 1000
+)
+
+// FileStore 文件会话存储，在内存存储的基础上把会话追加到日志文件，重启后可恢复
+// 每次保存或删除只追加一行，记录数远多于有效会话时重写日志，去掉被覆盖、过期和已删除的记录
+type FileStore struct {
+	*MemoryStore
+	path    string
+	file    *os.File
+	records int // 日志中的记录数
+}
+
+// NewFileStore 创建文件会话存储，并加载已有的会话
+func NewFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	if path == "" {
+		path = defaultFilePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(ttl),
+		path:        path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	// 重写日志，去掉加载时已过期的记录
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set 保存 Agent 会话 ID 并追加到日志
+func (s *FileStore) Set(key Key, conversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, conversationID)
+	return s.append(s.entries[key.String()])
+}
+
+// Delete 删除会话并追加删除记录
+func (s *FileStore) Delete(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key.String())
+	return s.append(entry{Key: key})
+}
+
+// Close 重写日志后关闭文件
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.rewrite()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+// load 重放日志，恢复未过期的会话；ExpiresAt 为零值的记录表示删除该会话
+// 兼容旧版本写入的 JSON 数组格式
+func (s *FileStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var entries []entry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return fmt.Errorf("failed to parse session file: %w", err)
+		}
+	} else {
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var e entry
+			if len(line) == 0 || json.Unmarshal(line, &e) != nil {
+				// 最后一条记录可能因进程中断写入不完整，忽略无法解析的行
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.ExpiresAt.IsZero() || now.After(e.ExpiresAt) {
+			delete(s.entries, e.Key.String())
+			continue
+		}
+		s.entries[e.Key.String()] = e
+	}
+	return nil
+}
+
+// append 追加一条记录，记录数过多时重写日志，调用方需持有锁
+func (s *FileStore) append(e entry) error {
+	if s.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	s.records++
+
+	if s.records > compactThreshold && s.records > 2*len(s.entries) {
+		return s.rewrite()
+	}
+	return nil
+}
+
+// rewrite 只保留未过期的会话重写日志（先写临时文件再重命名，避免写入中断导致文件损坏），调用方需持有锁
+func (s *FileStore) rewrite() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create session file: %w", err)
+	}
+
+	now := time.Now()
+	records := 0
+	w := bufio.NewWriter(f)
+	for _, e := range s.entries {
+		if now.After(e.ExpiresAt) {
+			continue
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to marshal session: %w", err)
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+		records++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to replace session file: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	// 继续使用同一文件句柄追加
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to seek session file: %w", err)
+	}
+	s.file = f
+	s.records = records
+	return nil
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"xia_adpter/internal/config"
+)
+
+// defaultTTL 会话默认有效期
+const defaultTTL = 24 * time.Hour
+
+// Key 会话键，同一平台会话中的同一用户在同一 Agent 上共享一个 Agent 会话
+type Key struct {
+	Platform  string `json:"platform"`
+	SessionID string `json:"session_id"`
+	UserID    string `json:"user_id"`
+	Agent     string `json:"agent"`
+}
+
+// String 返回键的字符串形式
+func (k Key) String() string {
+	return strings.Join([]string{k.Platform, k.SessionID, k.UserID, k.Agent}, "|")
+}
+
+// Store 会话存储接口，保存平台会话到 Agent 会话 ID 的映射
+type Store interface {
+	// Get 获取 Agent 会话 ID，不存在或已过期时返回 false
+	Get(key Key) (string, bool)
+	// Set 保存 Agent 会话 ID，并刷新有效期
+	Set(key Key, conversationID string) error
+	// Delete 删除会话
+	Delete(key Key) error
+	// Close 关闭存储
+	Close() error
+}
+
+// New 根据配置创建会话存储
+func New(cfg config.SessionConfig) (Store, error) {
+	ttl := time.Duration(cfg.TTL) * time.Second
+
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(ttl), nil
+	case "file":
+		return NewFileStore(cfg.Path, ttl)
+	default:
+		return nil, fmt.Errorf("unknown session backend %q", cfg.Backend)
+	}
+}
+
+// entry 会话记录
+type entry struct {
+	Key            Key       `json:"key"`
+	ConversationID string    `json:"conversation_id"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// MemoryStore 内存会话存储
+type MemoryStore struct {
+	ttl       time.Duration
+	entries   map[string]entry
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore 创建内存会话存储，ttl 不大于 0 时使用默认有效期
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &MemoryStore{
+		ttl:       ttl,
+		entries:   make(map[string]entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get 获取 Agent 会话 ID
+func (s *MemoryStore) Get(key Key) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key.String()]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.ExpiresAt) {
+		delete(s.entries, key.String())
+		return "", false
+	}
+	return e.ConversationID, true
+}
+
+// Set 保存 Agent 会话 ID
+func (s *MemoryStore) Set(key Key, conversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, conversationID)
+	return nil
+}
+
+// set 保存会话并定期清理过期记录，调用方需持有锁
+func (s *MemoryStore) set(key Key, conversationID string) {
+	now := time.Now()
+	s.entries[key.String()] = entry{
+		Key:            key,
+		ConversationID: conversationID,
+		ExpiresAt:      now.Add(s.ttl),
+	}
+
+	// 每分钟最多清理一次过期记录
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.ExpiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+}
+
+// Delete 删除会话
+func (s *MemoryStore) Delete(key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key.String())
+	return nil
+}
+
+// Close 关闭存储
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testKey(user string) Key {
+	return Key{Platform: "lark", SessionID: "oc_1", UserID: user, Agent: "dify"}
+}
+
+func TestMemoryStoreExpiresAndDeletes(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	s.Set(testKey("u1"), "c1")
+	s.Set(testKey("u2"), "c2")
+
+	if cid, ok := s.Get(testKey("u1")); !ok || cid != "c1" {
+		t.Fatalf("Get = %q, %v", cid, ok)
+	}
+	if _, ok := s.Get(Key{Platform: "lark", SessionID: "oc_1", UserID: "u1", Agent: "coze"}); ok {
+		t.Error("sessions of different agents collided")
+	}
+
+	// 过期的会话不再返回
+	s.mu.Lock()
+	e := s.entries[testKey("u1").String()]
+	e.ExpiresAt = time.Now().Add(-time.Second)
+	s.entries[testKey("u1").String()] = e
+	s.mu.Unlock()
+	if _, ok := s.Get(testKey("u1")); ok {
+		t.Error("expired session returned")
+	}
+
+	// Set 刷新有效期
+	s.Set(testKey("u1"), "c3")
+	if cid, ok := s.Get(testKey("u1")); !ok || cid != "c3" {
+		t.Errorf("Get after refresh = %q, %v", cid, ok)
+	}
+
+	s.Delete(testKey("u2"))
+	if _, ok := s.Get(testKey("u2")); ok {
+		t.Error("deleted session returned")
+	}
+}
+
+func TestFileStoreReloadsAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	s.Set(testKey("u1"), "c1")
+	s.Set(testKey("u2"), "c2")
+	s.Set(testKey("u1"), "c3")
+	s.Delete(testKey("u2"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if cid, ok := s.Get(testKey("u1")); !ok || cid != "c3" {
+		t.Errorf("Get u1 = %q, %v, want c3", cid, ok)
+	}
+	if _, ok := s.Get(testKey("u2")); ok {
+		t.Error("deleted session restored")
+	}
+}
+
+func TestFileStoreReplaysLogAndSkipsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	now := time.Now()
+	var log bytes.Buffer
+	for _, e := range []entry{
+		{Key: testKey("u1"), ConversationID: "c1", ExpiresAt: now.Add(time.Hour)},
+		{Key: testKey("u2"), ConversationID: "c2", ExpiresAt: now.Add(-time.Minute)},
+		{Key: testKey("u3"), ConversationID: "c3", ExpiresAt: now.Add(time.Hour)},
+		{Key: testKey("u3")},
+	} {
+		data, _ := json.Marshal(e)
+		log.Write(append(data, '\n'))
+	}
+	log.WriteString(`{"key":{"platform":"lark"`) // 进程中断时写入不完整的记录
+	if err := os.WriteFile(path, log.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+	if cid, ok := s.Get(testKey("u1")); !ok || cid != "c1" {
+		t.Errorf("Get u1 = %q, %v", cid, ok)
+	}
+	for _, user := range []string{"u2", "u3"} {
+		if _, ok := s.Get(testKey(user)); ok {
+			t.Errorf("%s restored", user)
+		}
+	}
+}
+
+func TestFileStoreLoadsLegacyJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	data, _ := json.Marshal([]entry{{Key: testKey("u1"), ConversationID: "c1", ExpiresAt: time.Now().Add(time.Hour)}})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+	if cid, ok := s.Get(testKey("u1")); !ok || cid != "c1" {
+		t.Errorf("Get = %q, %v", cid, ok)
+	}
+}
+
+func TestFileStoreAppendsAndCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer s.Close()
+
+	// 同一会话的每次回复追加一条记录，记录远多于有效会话时重写日志
+	for i := 0; i < 3*compactThreshold; i++ {
+		if err := s.Set(testKey("u1"), "c"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if s.records > compactThreshold+1 {
+		t.Errorf("log not compacted: %d records", s.records)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte{'\n'}); lines != s.records {
+		t.Errorf("file has %d lines, store counts %d", lines, s.records)
+	}
+}
@@ -0,0 +1,84 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// inputPlaceholder 命令参数中替换为音频文件路径的占位符
+const inputPlaceholder = "{input}"
+
+// CommandProvider 调用本地命令识别语音（如 whisper.cpp），命令的标准输出作为识别结果
+// 参数中包含 {input} 时写入临时文件并替换为其路径，否则通过标准输入传入音频
+type CommandProvider struct {
+	args []string
+}
+
+// NewCommandProvider 创建本地命令识别服务，command 按空白拆分为参数
+func NewCommandProvider(command string) *CommandProvider {
+	return &CommandProvider{args: strings.Fields(command)}
+}
+
+// Name 返回识别服务名称
+func (p *CommandProvider) Name() string {
+	return "command"
+}
+
+// Transcribe 执行命令并返回标准输出
+func (p *CommandProvider) Transcribe(ctx context.Context, audio Audio) (string, error) {
+	if len(p.args) == 0 {
+		return "", fmt.Errorf("command is empty")
+	}
+
+	args := append([]string(nil), p.args[1:]...)
+	var stdin []byte
+	if strings.Contains(strings.Join(args, " "), inputPlaceholder) {
+		path, err := writeTemp(audio)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(path)
+		for i, arg := range args {
+			args[i] = strings.ReplaceAll(arg, inputPlaceholder, path)
+		}
+	} else {
+		stdin = audio.Data
+	}
+
+	cmd := exec.CommandContext(ctx, p.args[0], args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", p.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// writeTemp 将音频写入临时文件
+func writeTemp(audio Audio) (string, error) {
+	format := audio.Format
+	if format == "" {
+		format = "wav"
+	}
+	f, err := os.CreateTemp("", "voice-*."+format)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := f.Write(audio.Data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to close temp file: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,61 @@
+package voice
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCommandProviderInput(t *testing.T) {
+	for _, name := range []string{"cat", "echo"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not found", name)
+		}
+	}
+	audio := Audio{Data: []byte("audio-bytes"), Format: "amr"}
+
+	// 没有 {input} 时音频通过标准输入传入
+	got, err := NewCommandProvider("cat").Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	if got != "audio-bytes" {
+		t.Errorf("stdin output = %q", got)
+	}
+
+	// {input} 替换为临时文件路径，标准输入为空
+	got, err = NewCommandProvider("cat {input}").Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	if got != "audio-bytes" {
+		t.Errorf("file output = %q", got)
+	}
+
+	// 占位符可以是参数的一部分，临时文件使用音频格式作为扩展名并在识别后删除
+	got, err = NewCommandProvider("echo --file={input}").Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	path := strings.TrimPrefix(strings.TrimSpace(got), "--file=")
+	if path == strings.TrimSpace(got) || !strings.HasSuffix(path, ".amr") {
+		t.Fatalf("echo output = %q, want --file=<path>.amr", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("temp file %s not removed: %v", path, err)
+	}
+}
+
+func TestCommandProviderErrors(t *testing.T) {
+	if _, err := NewCommandProvider("").Transcribe(context.Background(), Audio{}); err == nil {
+		t.Error("empty command succeeded")
+	}
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false not found")
+	}
+	if _, err := NewCommandProvider("false").Transcribe(context.Background(), Audio{Data: []byte("x")}); err == nil {
+		t.Error("failing command succeeded")
+	}
+}
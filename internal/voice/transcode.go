@@ -0,0 +1,45 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Transcoder 使用 ffmpeg 将 amr、opus 等格式转码为 16kHz 单声道 wav
+type Transcoder struct {
+	ffmpeg string
+}
+
+// NewTranscoder 创建转码器，ffmpegPath 为空时从 PATH 中查找 ffmpeg
+func NewTranscoder(ffmpegPath string) *Transcoder {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	return &Transcoder{ffmpeg: ffmpegPath}
+}
+
+// ToWAV 转码为 wav，输入格式由 ffmpeg 自动识别
+func (t *Transcoder) ToWAV(ctx context.Context, data []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, t.ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-ac", "1", "-ar", "16000",
+		"-f", "wav", "pipe:1",
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no output")
+	}
+	return stdout.Bytes(), nil
+}
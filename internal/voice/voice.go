@@ -0,0 +1,132 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"xia_adpter/internal/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTimeout 单条语音识别的默认超时时间
+	defaultTimeout = 60 * time.Second
+	// defaultMaxSize 默认的语音文件大小上限（与 Whisper 接口一致）
+	defaultMaxSize = 25 << 20
+)
+
+// ErrEmptyTranscript 识别结果为空（静音或无法识别）
+var ErrEmptyTranscript = errors.New("empty transcript")
+
+// Audio 待识别的音频
+type Audio struct {
+	Data   []byte
+	Format string // 文件格式，如 wav、amr、opus
+}
+
+// Provider 语音识别服务
+type Provider interface {
+	// Name 识别服务名称
+	Name() string
+	// Transcribe 识别音频，返回文本
+	Transcribe(ctx context.Context, audio Audio) (string, error)
+}
+
+// Transcriber 语音识别器，先将音频转码为 wav，再交给识别服务
+type Transcriber struct {
+	provider  Provider
+	transcode *Transcoder
+	timeout   time.Duration
+	maxSize   int
+	logger    *zap.Logger
+}
+
+// NewTranscriber 创建语音识别器，transcoder 为 nil 时不转码
+func NewTranscriber(provider Provider, transcoder *Transcoder, timeout time.Duration, maxSize int, logger *zap.Logger) *Transcriber {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return &Transcriber{
+		provider:  provider,
+		transcode: transcoder,
+		timeout:   timeout,
+		maxSize:   maxSize,
+		logger:    logger,
+	}
+}
+
+// New 根据配置创建语音识别器，未启用时返回 nil
+func New(cfg config.VoiceConfig, logger *zap.Logger) (*Transcriber, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	var provider Provider
+	switch cfg.Provider {
+	case "", "whisper":
+		provider = NewWhisperProvider(cfg.APIBase, cfg.APIKey, cfg.Model, cfg.Language)
+	case "command":
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("voice command is required for command provider")
+		}
+		provider = NewCommandProvider(cfg.Command)
+	default:
+		return nil, fmt.Errorf("unknown voice provider %q", cfg.Provider)
+	}
+
+	return NewTranscriber(
+		provider,
+		NewTranscoder(cfg.FFmpegPath),
+		time.Duration(cfg.TimeoutMs)*time.Millisecond,
+		cfg.MaxSizeMB<<20,
+		logger,
+	), nil
+}
+
+// Transcribe 识别一段语音，format 为原始格式（amr、opus 等），非 wav 格式先转码
+func (t *Transcriber) Transcribe(ctx context.Context, data []byte, format string) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("audio is empty")
+	}
+	if len(data) > t.maxSize {
+		return "", fmt.Errorf("audio too large: %d bytes (max %d)", len(data), t.maxSize)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	audio := Audio{Data: data, Format: strings.ToLower(format)}
+	if audio.Format != "wav" && t.transcode != nil {
+		wav, err := t.transcode.ToWAV(ctx, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to transcode %s audio: %w", format, err)
+		}
+		audio = Audio{Data: wav, Format: "wav"}
+	}
+
+	start := time.Now()
+	text, err := t.provider.Transcribe(ctx, audio)
+	if err != nil {
+		return "", fmt.Errorf("%s transcription failed: %w", t.provider.Name(), err)
+	}
+	text = strings.TrimSpace(text)
+
+	t.logger.Debug("Voice transcribed",
+		zap.String("provider", t.provider.Name()),
+		zap.String("format", format),
+		zap.Int("size", len(data)),
+		zap.Duration("elapsed", time.Since(start)),
+	)
+
+	if text == "" {
+		return "", ErrEmptyTranscript
+	}
+	return text, nil
+}
@@ -0,0 +1,89 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeProvider 返回预设结果并记录收到的音频
+type fakeProvider struct {
+	text  string
+	err   error
+	calls []Audio
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Transcribe(ctx context.Context, audio Audio) (string, error) {
+	p.calls = append(p.calls, audio)
+	return p.text, p.err
+}
+
+func TestTranscriberTranscribe(t *testing.T) {
+	// 转码器指向不存在的命令，被调用时必然失败
+	broken := NewTranscoder("/nonexistent/ffmpeg")
+
+	tests := []struct {
+		name       string
+		data       string
+		format     string
+		transcoder *Transcoder
+		text       string
+		want       string
+		wantErr    string
+		wantFormat string // 交给识别服务的格式，为空表示不应调用识别服务
+	}{
+		{name: "wav skips transcode", data: "RIFF", format: "WAV", transcoder: broken, text: " 你好 \n", want: "你好", wantFormat: "wav"},
+		{name: "no transcoder passes original", data: "amr", format: "amr", text: "hi", want: "hi", wantFormat: "amr"},
+		{name: "transcode failure", data: "amr", format: "amr", transcoder: broken, wantErr: "failed to transcode amr audio"},
+		{name: "too large", data: strings.Repeat("x", 11), format: "wav", wantErr: "audio too large"},
+		{name: "empty audio", data: "", format: "wav", wantErr: "audio is empty"},
+		{name: "empty transcript", data: "RIFF", format: "wav", text: "  \n", wantErr: ErrEmptyTranscript.Error(), wantFormat: "wav"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{text: tt.text}
+			tr := NewTranscriber(provider, tt.transcoder, 0, 10, zap.NewNop())
+
+			got, err := tr.Transcribe(context.Background(), []byte(tt.data), tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Transcribe: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+
+			if tt.wantFormat == "" {
+				if len(provider.calls) != 0 {
+					t.Errorf("provider called %d times, want 0", len(provider.calls))
+				}
+				return
+			}
+			if len(provider.calls) != 1 {
+				t.Fatalf("provider called %d times, want 1", len(provider.calls))
+			}
+			if call := provider.calls[0]; call.Format != tt.wantFormat || string(call.Data) != tt.data {
+				t.Errorf("provider got %s audio %q, want %s audio %q", call.Format, call.Data, tt.wantFormat, tt.data)
+			}
+		})
+	}
+}
+
+func TestTranscriberProviderError(t *testing.T) {
+	provider := &fakeProvider{err: errors.New("boom")}
+	tr := NewTranscriber(provider, nil, 0, 0, zap.NewNop())
+
+	_, err := tr.Transcribe(context.Background(), []byte("RIFF"), "wav")
+	if err == nil || errors.Is(err, ErrEmptyTranscript) || !strings.Contains(err.Error(), "fake transcription failed") {
+		t.Errorf("err = %v", err)
+	}
+}
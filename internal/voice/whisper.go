@@ -0,0 +1,108 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// WhisperProvider 调用 OpenAI 兼容的 /audio/transcriptions 接口
+// 也适用于 faster-whisper-server、LocalAI 等本地部署的兼容服务
+type WhisperProvider struct {
+	apiBase  string
+	apiKey   string
+	model    string
+	language string
+	client   *http.Client
+}
+
+// NewWhisperProvider 创建 Whisper 识别服务
+func NewWhisperProvider(apiBase, apiKey, model, language string) *WhisperProvider {
+	if apiBase == "" {
+		apiBase = "https://api.openai.com/v1"
+	}
+	if model == "" {
+		model = "whisper-1"
+	}
+	return &WhisperProvider{
+		apiBase:  strings.TrimRight(apiBase, "/"),
+		apiKey:   apiKey,
+		model:    model,
+		language: language,
+		client:   &http.Client{},
+	}
+}
+
+// Name 返回识别服务名称
+func (p *WhisperProvider) Name() string {
+	return "whisper"
+}
+
+// Transcribe 上传音频并返回识别文本
+func (p *WhisperProvider) Transcribe(ctx context.Context, audio Audio) (string, error) {
+	format := audio.Format
+	if format == "" {
+		format = "wav"
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "audio."+format)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(audio.Data); err != nil {
+		return "", fmt.Errorf("failed to write audio data: %w", err)
+	}
+	fields := map[string]string{
+		"model":           p.model,
+		"response_format": "json",
+	}
+	if p.language != "" {
+		fields["language"] = p.language
+	}
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			return "", fmt.Errorf("failed to write field %s: %w", k, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/audio/transcriptions", &buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return result.Text, nil
+}
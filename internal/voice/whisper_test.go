@@ -0,0 +1,61 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWhisperProviderRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/audio/transcriptions" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatalf("ParseMultipartForm: %v", err)
+		}
+		for field, want := range map[string]string{"model": "whisper-1", "language": "zh", "response_format": "json"} {
+			if got := r.FormValue(field); got != want {
+				t.Errorf("%s = %q, want %q", field, got, want)
+			}
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("FormFile: %v", err)
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "audio.wav" || string(data) != "RIFF" {
+			t.Errorf("file = %s %q", header.Filename, data)
+		}
+		fmt.Fprint(w, `{"text":"你好"}`)
+	}))
+	defer server.Close()
+
+	p := NewWhisperProvider(server.URL+"/v1/", "key", "", "zh")
+	got, err := p.Transcribe(context.Background(), Audio{Data: []byte("RIFF"), Format: "wav"})
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if got != "你好" {
+		t.Errorf("text = %q", got)
+	}
+}
+
+func TestWhisperProviderAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid file"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := NewWhisperProvider(server.URL, "", "", "").Transcribe(context.Background(), Audio{Data: []byte("x")})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("err = %v, want API error 400", err)
+	}
+}